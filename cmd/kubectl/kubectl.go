@@ -18,15 +18,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
-	"syscall"
 
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/cmd"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
 	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/dispatcher"
-	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
-	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
-	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/version"
-	utilflag "k8s.io/component-base/cli/flag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/klog"
 
 	// Import to initialize client auth plugins.
@@ -56,40 +56,76 @@ var clientVersion = version.Info{
 // filenames with "kubectl.". Example: "kubectl.1.12"
 func main() {
 
-	InitLogging(os.Args[1:])
+	// The dispatcher's own flags (e.g. --dispatcher-v) are removed from
+	// the arguments passed to kubectl.
+	flags, args, err := dispatcher.ParseFlags(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubectl dispatcher error: %v\n", err)
+		os.Exit(1)
+	}
+	InitLogging(flags.Verbosity)
 	defer klog.Flush()
 
-	// Dispatch() does not return if successful; the current process is overwritten.
-	dispatcher.Execute(clientVersion)
-
-	// Dispatch to the default kubectl binary given by clientVersion.
-	filepathBuilder := filepath.NewFilepathBuilder(&filepath.ExeDirGetter{}, os.Stat)
-	kubectlDefaultFilepath, err := filepathBuilder.VersionedFilePath(clientVersion)
+	if flags.Version {
+		fmt.Printf("kubectl-dispatcher v%s (default kubectl %s)\n", client.DispatcherVersion, clientVersion.GitVersion)
+		return
+	}
+	// The configuration is read once, and passed down. A config file given
+	// on the command line must be valid.
+	cfg, err := config.Load(flags.Env(os.Environ()))
 	if err != nil {
-		klog.Errorf("Error creating default kubectl: (%v)", err)
-		os.Exit(1)
+		if flags.ConfigFile != "" {
+			klog.Errorf("kubectl dispatcher error: bad config file: %v", err)
+			klog.Flush()
+			os.Exit(1)
+		}
+		klog.Warningf("Ignoring dispatcher config file: %v", err)
 	}
-	if err := filepathBuilder.ValidateFilepath(kubectlDefaultFilepath); err != nil {
-		klog.Errorf("Error validating default kubectl: %s (%v)", kubectlDefaultFilepath, err)
-		os.Exit(1)
+
+	// "kubectl dispatcher ..." manages the dispatcher itself; it is never
+	// delegated to a versioned kubectl binary.
+	if cmd.IsDispatcherCommand(args) {
+		streams := genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
+		dispatcherCmd := cmd.NewCmdDispatcher(streams, cfg)
+		dispatcherCmd.SetArgs(args[2:])
+		if err := dispatcherCmd.Execute(); err != nil {
+			klog.Flush()
+			os.Exit(1)
+		}
+		return
 	}
 
-	klog.Infof("Default kubectl dispatched: %s", kubectlDefaultFilepath)
-	err = syscall.Exec(kubectlDefaultFilepath, os.Args, os.Environ())
-	if err != nil {
-		klog.Errorf("kubectl dispatcher error: problem with Exec: (%v)", err)
+	// Execute() does not return if successful; the current process is overwritten.
+	if err := dispatcher.Execute(clientVersion, args, flags, cfg); err != nil {
+		// In child exec mode, exit as kubectl did.
+		if exitErr, ok := err.(*dispatcher.ExitError); ok {
+			klog.Flush()
+			exitErr.Exit()
+		}
+		// In strict mode, explain how to install the missing binary.
+		if dispatcher.IsMissingBinary(err) {
+			klog.Flush()
+			fmt.Fprintf(os.Stderr, "kubectl dispatcher: %v\n", err)
+			os.Exit(dispatcher.ExitCodeMissingBinary)
+		}
+		klog.Errorf("kubectl dispatcher error: %v", err)
+		klog.Flush()
+		// As with shells: 127 if no kubectl binary was found, and 126 if
+		// one was found but could not be executed.
+		if dispatchErr, ok := err.(*dispatcher.DispatchError); ok {
+			os.Exit(dispatchErr.ExitCode())
+		}
+		os.Exit(1)
 	}
 }
 
-// Initialize klog logging by parsing the log-related flags.
-func InitLogging(args []string) {
+// Initialize klog logging. The dispatcher logs to stderr at the verbosity
+// given by --dispatcher-v; the -v flag on the command line is kubectl's.
+func InitLogging(verbosity string) {
 	logFlagSet := flag.NewFlagSet("dispatcher-logs", flag.ContinueOnError)
 	klog.InitFlags(logFlagSet)
-	plogFlagSet := pflag.NewFlagSet("dispatcher-kube-config", pflag.ContinueOnError)
-	plogFlagSet.ParseErrorsWhitelist.UnknownFlags = true
-	plogFlagSet.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
-	plogFlagSet.AddGoFlagSet(logFlagSet)
-	// Remove help flags, pflags handles them specially.
-	args = util.FilterList(args, dispatcher.HelpFlags)
-	plogFlagSet.Parse(args)
+	logFlagSet.Set("logtostderr", "true")
+	if verbosity != "" {
+		logFlagSet.Set("v", verbosity)
+	}
 }
@@ -0,0 +1,221 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package binary inspects executable files to determine the operating
+// system and architectures they were built for.
+package binary
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Executable file formats.
+const (
+	FormatELF    = "elf"
+	FormatMachO  = "macho"
+	FormatPE     = "pe"
+	FormatScript = "script"
+)
+
+// OSUnix is the OS of an ELF binary which does not name its operating
+// system (OSABI 0, System V), as Linux binaries and those of most BSDs do:
+// it may run on any host which runs ELF binaries.
+const OSUnix = "unix"
+
+// elfHosts are the GOOS values of hosts which run ELF binaries.
+var elfHosts = map[string]bool{
+	"android":   true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"illumos":   true,
+	"linux":     true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"solaris":   true,
+}
+
+// Info describes the platform an executable file was built for.
+type Info struct {
+	Format string
+	// OS is the GOOS value of the binary, OSUnix for an ELF binary which
+	// does not name its operating system, and empty for scripts.
+	OS string
+	// Arches are the GOARCH values of the binary. Universal Mach-O binaries
+	// have several; scripts have none.
+	Arches []string
+}
+
+// Supports returns true if the executable runs on the passed platform.
+// Scripts are assumed to run everywhere.
+func (i *Info) Supports(goos, goarch string) bool {
+	if i.Format == FormatScript {
+		return true
+	}
+	if i.OS != goos && !(i.OS == OSUnix && elfHosts[goos]) {
+		return false
+	}
+	for _, arch := range i.Arches {
+		if arch == goarch {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsHost returns true if the executable runs on this host.
+func (i *Info) SupportsHost() bool {
+	return i.Supports(runtime.GOOS, runtime.GOARCH)
+}
+
+// String returns the platform as "<os>/<arch>[,<arch>...]".
+func (i *Info) String() string {
+	if i.Format == FormatScript {
+		return FormatScript
+	}
+	return i.OS + "/" + strings.Join(i.Arches, ",")
+}
+
+var elfArches = map[elf.Machine]string{
+	elf.EM_386:     "386",
+	elf.EM_X86_64:  "amd64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_S390:    "s390x",
+	elf.EM_RISCV:   "riscv64",
+}
+
+var elfOSes = map[elf.OSABI]string{
+	elf.ELFOSABI_LINUX:   "linux",
+	elf.ELFOSABI_FREEBSD: "freebsd",
+	elf.ELFOSABI_NETBSD:  "netbsd",
+	elf.ELFOSABI_OPENBSD: "openbsd",
+	elf.ELFOSABI_SOLARIS: "solaris",
+}
+
+var machoArches = map[macho.Cpu]string{
+	macho.Cpu386:   "386",
+	macho.CpuAmd64: "amd64",
+	macho.CpuArm:   "arm",
+	macho.CpuArm64: "arm64",
+}
+
+var peArches = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+}
+
+// Inspect returns the platform information of the executable file.
+func Inspect(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return InspectReader(f)
+}
+
+// InspectReader returns the platform information of the executable read
+// from "r".
+func InspectReader(r io.ReaderAt) (*Info, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, fmt.Errorf("reading executable header: %v", err)
+	}
+	switch {
+	case string(magic[:2]) == "#!":
+		return &Info{Format: FormatScript}, nil
+	case string(magic) == elf.ELFMAG:
+		return inspectELF(r)
+	case string(magic[:2]) == "MZ":
+		return inspectPE(r)
+	}
+	return inspectMachO(r)
+}
+
+func inspectELF(r io.ReaderAt) (*Info, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	arch, ok := elfArches[f.Machine]
+	switch f.Machine {
+	case elf.EM_PPC64:
+		arch, ok = "ppc64", true
+		if f.ByteOrder.String() == "LittleEndian" {
+			arch = "ppc64le"
+		}
+	case elf.EM_MIPS:
+		arch, ok = "mips", true
+		if f.Class == elf.ELFCLASS64 {
+			arch = "mips64"
+		}
+		if f.ByteOrder.String() == "LittleEndian" {
+			arch += "le"
+		}
+	}
+	if !ok {
+		arch = f.Machine.String()
+	}
+	goos, ok := elfOSes[f.OSABI]
+	if !ok {
+		goos = OSUnix
+	}
+	return &Info{Format: FormatELF, OS: goos, Arches: []string{arch}}, nil
+}
+
+func inspectPE(r io.ReaderAt) (*Info, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	arch, ok := peArches[f.Machine]
+	if !ok {
+		arch = fmt.Sprintf("machine-0x%x", f.Machine)
+	}
+	return &Info{Format: FormatPE, OS: "windows", Arches: []string{arch}}, nil
+}
+
+func inspectMachO(r io.ReaderAt) (*Info, error) {
+	info := &Info{Format: FormatMachO, OS: "darwin"}
+	if fat, err := macho.NewFatFile(r); err == nil {
+		for _, a := range fat.Arches {
+			info.Arches = append(info.Arches, machoArch(a.Cpu))
+		}
+		return info, nil
+	}
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized executable format")
+	}
+	info.Arches = []string{machoArch(f.Cpu)}
+	return info, nil
+}
+
+func machoArch(cpu macho.Cpu) string {
+	if arch, ok := machoArches[cpu]; ok {
+		return arch
+	}
+	return cpu.String()
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package binary
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// elfHeader returns a minimal 64-bit little endian ELF header for the
+// passed machine type, with OSABI 0 (System V).
+func elfHeader(machine elf.Machine) []byte {
+	header := make([]byte, 64)
+	copy(header, elf.ELFMAG)
+	header[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.LittleEndian.PutUint16(header[16:], uint16(elf.ET_EXEC))
+	binary.LittleEndian.PutUint16(header[18:], uint16(machine))
+	binary.LittleEndian.PutUint32(header[20:], uint32(elf.EV_CURRENT))
+	binary.LittleEndian.PutUint16(header[52:], 64) // e_ehsize
+	binary.LittleEndian.PutUint16(header[54:], 56) // e_phentsize
+	binary.LittleEndian.PutUint16(header[58:], 64) // e_shentsize
+	return header
+}
+
+// elfHeaderOSABI returns a minimal ELF header, as elfHeader does, naming the
+// operating system.
+func elfHeaderOSABI(machine elf.Machine, osabi elf.OSABI) []byte {
+	header := elfHeader(machine)
+	header[elf.EI_OSABI] = byte(osabi)
+	return header
+}
+
+func TestInspectReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		contents    []byte
+		format      string
+		platform    string
+		expectError bool
+	}{
+		{
+			name:     "script",
+			contents: []byte("#!/bin/sh\necho kubectl\n"),
+			format:   FormatScript,
+			platform: "script",
+		},
+		{
+			name:     "unix/amd64",
+			contents: elfHeader(elf.EM_X86_64),
+			format:   FormatELF,
+			platform: "unix/amd64",
+		},
+		{
+			name:     "unix/arm64",
+			contents: elfHeader(elf.EM_AARCH64),
+			format:   FormatELF,
+			platform: "unix/arm64",
+		},
+		{
+			name:     "freebsd/amd64",
+			contents: elfHeaderOSABI(elf.EM_X86_64, elf.ELFOSABI_FREEBSD),
+			format:   FormatELF,
+			platform: "freebsd/amd64",
+		},
+		{
+			name:     "linux/amd64",
+			contents: elfHeaderOSABI(elf.EM_X86_64, elf.ELFOSABI_LINUX),
+			format:   FormatELF,
+			platform: "linux/amd64",
+		},
+		{
+			name:        "garbage",
+			contents:    []byte("this is not an executable"),
+			expectError: true,
+		},
+		{
+			name:        "too short",
+			contents:    []byte("#"),
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		info, err := InspectReader(bytes.NewReader(test.contents))
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected error; received none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if test.format != info.Format {
+			t.Errorf("%s: expected format (%s), got (%s)", test.name, test.format, info.Format)
+		}
+		if test.platform != info.String() {
+			t.Errorf("%s: expected platform (%s), got (%s)", test.name, test.platform, info.String())
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		contents  []byte
+		platform  string
+		supported bool
+	}{
+		// A System V binary runs on any host which runs ELF binaries.
+		{contents: elfHeader(elf.EM_AARCH64), platform: "linux/arm64", supported: true},
+		{contents: elfHeader(elf.EM_AARCH64), platform: "freebsd/arm64", supported: true},
+		{contents: elfHeader(elf.EM_AARCH64), platform: "openbsd/arm64", supported: true},
+		{contents: elfHeader(elf.EM_AARCH64), platform: "linux/amd64"},
+		{contents: elfHeader(elf.EM_AARCH64), platform: "darwin/arm64"},
+		{contents: elfHeader(elf.EM_AARCH64), platform: "windows/arm64"},
+		{contents: elfHeaderOSABI(elf.EM_X86_64, elf.ELFOSABI_FREEBSD), platform: "freebsd/amd64", supported: true},
+		{contents: elfHeaderOSABI(elf.EM_X86_64, elf.ELFOSABI_FREEBSD), platform: "linux/amd64"},
+		{contents: elfHeaderOSABI(elf.EM_X86_64, elf.ELFOSABI_LINUX), platform: "freebsd/amd64"},
+	}
+	for _, test := range tests {
+		info, err := InspectReader(bytes.NewReader(test.contents))
+		if err != nil {
+			t.Fatalf("Unexpected error inspecting header: %v", err)
+		}
+		platform := strings.SplitN(test.platform, "/", 2)
+		if actual := info.Supports(platform[0], platform[1]); test.supported != actual {
+			t.Errorf("Supports(%s, %s): expected (%t), got (%t)", info, test.platform, test.supported, actual)
+		}
+	}
+	script := &Info{Format: FormatScript}
+	if !script.SupportsHost() {
+		t.Errorf("Expected script to support the host")
+	}
+}
+
+func TestInspectTestBinary(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skipf("no test executable: %v", err)
+	}
+	info, err := Inspect(exe)
+	if err != nil {
+		t.Fatalf("Unexpected error inspecting test binary: %v", err)
+	}
+	if !info.SupportsHost() {
+		t.Errorf("Expected test binary (%s) to support host %s/%s", info, runtime.GOOS, runtime.GOARCH)
+	}
+}
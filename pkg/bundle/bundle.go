@@ -0,0 +1,452 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bundle exports a set of versioned kubectl binaries as a single
+// gzipped tarball, and imports such a bundle on a host without network
+// access. A bundle contains, in order:
+//
+//	manifest.json      the versions, file names, sizes and SHA-256 digests
+//	SHA256SUMS         the same digests in "sha256sum -c" format
+//	manifest.json.sig  optional ed25519 signature over manifest.json
+//	kubectl.<major>.<minor>[.exe] binaries listed in the manifest
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	ManifestFile  = "manifest.json"
+	ChecksumsFile = "SHA256SUMS"
+	SignatureFile = "manifest.json.sig"
+
+	manifestAPIVersion = "kubectl-dispatcher.bundle/v1"
+)
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	APIVersion string    `json:"apiVersion"`
+	Created    time.Time `json:"created"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Binaries   []Binary  `json:"binaries"`
+}
+
+// Binary describes one versioned kubectl binary within a bundle.
+type Binary struct {
+	// Version is the "<major>.<minor>" version of the binary.
+	Version string `json:"version"`
+	// File is the base name of the binary within the bundle.
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Export writes a bundle containing the versioned kubectl binaries for the
+// passed versions to "w". The binaries are located with the FilepathBuilder,
+// so they must already exist in the dispatcher's search directory. If the
+// signing key is not nil, the manifest is signed with it.
+func Export(w io.Writer, builder *dfilepath.FilepathBuilder, versions []version.Info, key ed25519.PrivateKey) (*Manifest, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions to export")
+	}
+	manifest := &Manifest{
+		APIVersion: manifestAPIVersion,
+		Created:    time.Now().UTC(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+	}
+	paths := map[string]string{}
+	for _, v := range versions {
+		majorMinor, err := util.MajorMinor(v)
+		if err != nil {
+			return nil, err
+		}
+		path, err := builder.VersionedFilePath(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := builder.ValidateFilepath(path); err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		if _, ok := paths[name]; ok {
+			continue
+		}
+		size, digest, err := util.FileDigest(path)
+		if err != nil {
+			return nil, err
+		}
+		paths[name] = path
+		manifest.Binaries = append(manifest.Binaries, Binary{
+			Version: majorMinor,
+			File:    name,
+			Size:    size,
+			SHA256:  digest,
+		})
+	}
+	sort.Slice(manifest.Binaries, func(i, j int) bool {
+		return manifest.Binaries[i].File < manifest.Binaries[j].File
+	})
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	if err := writeTarFile(tw, ManifestFile, manifestBytes, 0644); err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, ChecksumsFile, checksums(manifest), 0644); err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if err := writeTarFile(tw, SignatureFile, ed25519.Sign(key, manifestBytes), 0644); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range manifest.Binaries {
+		if err := copyTarFile(tw, b, paths[b.File]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ImportOptions controls how a bundle is verified on import.
+type ImportOptions struct {
+	// PublicKey verifies the manifest signature, which the bundle must
+	// then have. If nil, a signature in the bundle is ignored.
+	PublicKey ed25519.PublicKey
+}
+
+// Import verifies the bundle read from "r", and installs its binaries using
+// the layout of the passed FilepathBuilder (kubectl.<major>.<minor>). Every
+// binary is written to a temporary file and checked against the manifest
+// before it is renamed into place, so a corrupt bundle installs nothing
+// it has not verified. Returns the manifest of the imported bundle.
+func Import(r io.Reader, builder *dfilepath.FilepathBuilder, opts ImportOptions) (*Manifest, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("bundle is not gzip compressed: %v", err)
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+
+	var manifestBytes, sums, signature []byte
+	var manifest *Manifest
+	expected := map[string]Binary{}
+	staged := map[string]string{} // bundle file name -> temp file path
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected bundle entry type for %q", header.Name)
+		}
+		switch header.Name {
+		case ManifestFile, ChecksumsFile, SignatureFile:
+			if manifest != nil {
+				return nil, fmt.Errorf("bundle entry %q must precede the binaries", header.Name)
+			}
+		}
+		switch header.Name {
+		case ManifestFile:
+			if manifestBytes, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+			continue
+		case ChecksumsFile:
+			if sums, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+			continue
+		case SignatureFile:
+			if signature, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// The first binary closes the metadata section of the bundle,
+		// which must verify before anything is written to disk.
+		if manifest == nil {
+			if manifest, err = verifyManifest(manifestBytes, sums, signature, opts); err != nil {
+				return nil, err
+			}
+			for _, b := range manifest.Binaries {
+				expected[b.File] = b
+			}
+		}
+		b, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle entry %q not listed in manifest", header.Name)
+		}
+		if _, ok := staged[b.File]; ok {
+			return nil, fmt.Errorf("duplicate bundle entry %q", header.Name)
+		}
+		tmp, err := stageBinary(tr, builder, b)
+		if err != nil {
+			return nil, err
+		}
+		staged[b.File] = tmp
+	}
+	if manifest == nil {
+		if manifest, err = verifyManifest(manifestBytes, sums, signature, opts); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range manifest.Binaries {
+		if _, ok := staged[b.File]; !ok {
+			return nil, fmt.Errorf("bundle is missing binary %q", b.File)
+		}
+	}
+	for _, b := range manifest.Binaries {
+		target, err := targetPath(builder, b)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Rename(staged[b.File], target); err != nil {
+			return nil, err
+		}
+		delete(staged, b.File)
+	}
+	return manifest, nil
+}
+
+// verifyManifest parses the manifest, checks the signature if a public key
+// is given, and checks that the SHA256SUMS file agrees with the manifest.
+func verifyManifest(manifestBytes, sums, signature []byte, opts ImportOptions) (*Manifest, error) {
+	if manifestBytes == nil {
+		return nil, fmt.Errorf("bundle is missing %s", ManifestFile)
+	}
+	if opts.PublicKey != nil {
+		if signature == nil {
+			return nil, fmt.Errorf("bundle is missing %s", SignatureFile)
+		}
+		if !ed25519.Verify(opts.PublicKey, manifestBytes, signature) {
+			return nil, fmt.Errorf("bundle signature verification failed")
+		}
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("bad bundle manifest: %v", err)
+	}
+	if manifest.APIVersion != manifestAPIVersion {
+		return nil, fmt.Errorf("unsupported bundle version %q", manifest.APIVersion)
+	}
+	if manifest.OS != runtime.GOOS || manifest.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("bundle is for %s/%s, this host is %s/%s",
+			manifest.OS, manifest.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	if len(manifest.Binaries) == 0 {
+		return nil, fmt.Errorf("bundle manifest lists no binaries")
+	}
+	if sums == nil {
+		return nil, fmt.Errorf("bundle is missing %s", ChecksumsFile)
+	}
+	if !bytes.Equal(sums, checksums(&manifest)) {
+		return nil, fmt.Errorf("%s does not match %s", ChecksumsFile, ManifestFile)
+	}
+	return &manifest, nil
+}
+
+// stageBinary copies the binary from the tar reader into a temporary file
+// in the target directory, verifying its size and digest. Returns the
+// temporary file path.
+func stageBinary(r io.Reader, builder *dfilepath.FilepathBuilder, b Binary) (string, error) {
+	target, err := targetPath(builder, b)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size != b.Size {
+		err = fmt.Errorf("size mismatch for %s: expected (%d), got (%d)", b.File, b.Size, size)
+	}
+	if digest := hex.EncodeToString(hash.Sum(nil)); err == nil && digest != b.SHA256 {
+		err = fmt.Errorf("checksum mismatch for %s: expected (%s), got (%s)", b.File, b.SHA256, digest)
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// targetPath returns the install path of the binary, derived from its
+// version rather than the (untrusted) file name within the bundle.
+func targetPath(builder *dfilepath.FilepathBuilder, b Binary) (string, error) {
+	v, err := util.ParseVersion(b.Version)
+	if err != nil {
+		return "", err
+	}
+	target, err := builder.VersionedFilePath(v)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Base(target) != b.File {
+		return "", fmt.Errorf("bundle file %q does not match version %s", b.File, b.Version)
+	}
+	return target, nil
+}
+
+// checksums returns the contents of the SHA256SUMS file for the manifest.
+func checksums(manifest *Manifest) []byte {
+	var buf bytes.Buffer
+	for _, b := range manifest.Binaries {
+		fmt.Fprintf(&buf, "%s  %s\n", b.SHA256, b.File)
+	}
+	return buf.Bytes()
+}
+
+func writeTarFile(tw *tar.Writer, name string, contents []byte, mode int64) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(contents)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(contents)
+	return err
+}
+
+func copyTarFile(tw *tar.Writer, b Binary, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := &tar.Header{
+		Name:    b.File,
+		Mode:    0755,
+		Size:    b.Size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// A binary modified since it was digested fails here on size, or on
+	// import on checksum.
+	if _, err := io.CopyN(tw, f, b.Size); err != nil {
+		return fmt.Errorf("copying %s into bundle: %v", path, err)
+	}
+	return nil
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 ed25519 private key, such as
+// one generated by "openssl genpkey -algorithm ed25519".
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	key, err := loadPEMKey(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return key.(ed25519.PrivateKey), nil
+}
+
+// LoadPublicKey reads a PEM encoded PKIX ed25519 public key, such as one
+// generated by "openssl pkey -pubout".
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := loadPEMKey(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return key.(ed25519.PublicKey), nil
+}
+
+func loadPEMKey(path string, private bool) (interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	var key interface{}
+	if private {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing key %s: %v", path, err)
+	}
+	switch key.(type) {
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key %s is not an ed25519 key", path)
+}
+
+// String returns a one line summary of the manifest.
+func (m *Manifest) String() string {
+	versions := []string{}
+	for _, b := range m.Binaries {
+		versions = append(versions, b.Version)
+	}
+	return fmt.Sprintf("%s/%s kubectl %s", m.OS, m.Arch, strings.Join(versions, ", "))
+}
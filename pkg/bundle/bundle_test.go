@@ -0,0 +1,277 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// setupSourceDir creates a temporary directory with fake versioned kubectl
+// binaries for the passed versions, and returns its FilepathBuilder.
+func setupSourceDir(t *testing.T, versions ...string) (string, *dfilepath.FilepathBuilder, []version.Info) {
+	dir, err := ioutil.TempDir("", "bundle-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, os.Stat)
+	infos := []version.Info{}
+	for _, v := range versions {
+		info, err := util.ParseVersion(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := builder.VersionedFilePath(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("fake kubectl "+v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+	return dir, builder, infos
+}
+
+func setupTargetDir(t *testing.T) (string, *dfilepath.FilepathBuilder) {
+	dir, err := ioutil.TempDir("", "bundle-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, os.Stat)
+}
+
+// rewriteBundle rewrites every entry of the bundle with the passed function.
+// Entries for which the function returns nil are dropped.
+func rewriteBundle(t *testing.T, data []byte, rewrite func(name string, contents []byte) []byte) []byte {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents = rewrite(header.Name, contents); contents == nil {
+			continue
+		}
+		if err := writeTarFile(tw, header.Name, contents, header.Mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func TestExportImport(t *testing.T) {
+	srcDir, source, versions := setupSourceDir(t, "1.11", "1.12")
+	defer os.RemoveAll(srcDir)
+
+	var buf bytes.Buffer
+	exported, err := Export(&buf, source, versions, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error exporting bundle: %v", err)
+	}
+	if len(exported.Binaries) != 2 {
+		t.Fatalf("Expected 2 exported binaries, got (%d)", len(exported.Binaries))
+	}
+
+	dir, target := setupTargetDir(t)
+	defer os.RemoveAll(dir)
+	imported, err := Import(bytes.NewReader(buf.Bytes()), target, ImportOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error importing bundle: %v", err)
+	}
+	for i, b := range imported.Binaries {
+		if b != exported.Binaries[i] {
+			t.Errorf("Imported binary error: expected (%+v), got (%+v)", exported.Binaries[i], b)
+		}
+		path, _ := target.VersionedFilePath(versions[i])
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("Expected installed binary (%s): %v", path, err)
+			continue
+		}
+		if expected := "fake kubectl " + b.Version; string(contents) != expected {
+			t.Errorf("Installed binary error: expected (%s), got (%s)", expected, contents)
+		}
+		if fi, _ := os.Stat(path); fi.Mode()&0111 == 0 {
+			t.Errorf("Installed binary (%s) is not executable", path)
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".*"))
+	if len(leftovers) != 0 {
+		t.Errorf("Unexpected temporary files after import: %v", leftovers)
+	}
+}
+
+func TestImportSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcDir, source, versions := setupSourceDir(t, "1.13")
+	defer os.RemoveAll(srcDir)
+	var signed, unsigned bytes.Buffer
+	if _, err := Export(&signed, source, versions, private); err != nil {
+		t.Fatalf("Unexpected error exporting signed bundle: %v", err)
+	}
+	if _, err := Export(&unsigned, source, versions, nil); err != nil {
+		t.Fatalf("Unexpected error exporting unsigned bundle: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		bundle      []byte
+		opts        ImportOptions
+		expectError bool
+	}{
+		{
+			name:   "signed bundle, matching key",
+			bundle: signed.Bytes(),
+			opts:   ImportOptions{PublicKey: public},
+		},
+		{
+			name:        "signed bundle, other key",
+			bundle:      signed.Bytes(),
+			opts:        ImportOptions{PublicKey: otherPublic},
+			expectError: true,
+		},
+		// A public key means the signature is verified.
+		{
+			name:        "unsigned bundle, public key given",
+			bundle:      unsigned.Bytes(),
+			opts:        ImportOptions{PublicKey: public},
+			expectError: true,
+		},
+		{
+			name:   "unsigned bundle, no key",
+			bundle: unsigned.Bytes(),
+		},
+	}
+	for _, test := range tests {
+		dir, target := setupTargetDir(t)
+		_, err := Import(bytes.NewReader(test.bundle), target, test.opts)
+		if test.expectError && err == nil {
+			t.Errorf("%s: expected error; received none", test.name)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestImportRejectsTamperedBundle(t *testing.T) {
+	srcDir, source, versions := setupSourceDir(t, "1.11", "1.12")
+	defer os.RemoveAll(srcDir)
+	var buf bytes.Buffer
+	if _, err := Export(&buf, source, versions, nil); err != nil {
+		t.Fatalf("Unexpected error exporting bundle: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rewrite func(name string, contents []byte) []byte
+	}{
+		{
+			name: "modified binary",
+			rewrite: func(name string, contents []byte) []byte {
+				if name == "kubectl.1.12" {
+					return []byte("fake kubectl 1.99")
+				}
+				return contents
+			},
+		},
+		{
+			name: "missing binary",
+			rewrite: func(name string, contents []byte) []byte {
+				if name == "kubectl.1.11" {
+					return nil
+				}
+				return contents
+			},
+		},
+		{
+			name: "modified checksums",
+			rewrite: func(name string, contents []byte) []byte {
+				if name == ChecksumsFile {
+					return append(contents, []byte("0000  kubectl.1.13\n")...)
+				}
+				return contents
+			},
+		},
+		{
+			name: "missing manifest",
+			rewrite: func(name string, contents []byte) []byte {
+				if name == ManifestFile {
+					return nil
+				}
+				return contents
+			},
+		},
+		{
+			name: "path traversal",
+			rewrite: func(name string, contents []byte) []byte {
+				if name == ManifestFile {
+					return bytes.Replace(contents, []byte(`"kubectl.1.11"`), []byte(`"../kubectl.1.11"`), 1)
+				}
+				return contents
+			},
+		},
+	}
+	for _, test := range tests {
+		dir, target := setupTargetDir(t)
+		tampered := rewriteBundle(t, buf.Bytes(), test.rewrite)
+		if _, err := Import(bytes.NewReader(tampered), target, ImportOptions{}); err == nil {
+			t.Errorf("%s: expected error importing tampered bundle; received none", test.name)
+		}
+		if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: expected nothing installed, got (%d) files", test.name, len(entries))
+		}
+		os.RemoveAll(dir)
+	}
+}
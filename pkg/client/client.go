@@ -18,27 +18,59 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/discovery"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog"
 )
 
 const defaultRequestTimeout time.Duration = 5 * time.Second
 const defaultCacheMaxAge = 60 * 60 // One hour in seconds
 
+const (
+	maxRetries   = 2                      // Retries of transient errors
+	retryBackoff = 200 * time.Millisecond // Doubled before each retry
+)
+
 // Encapsulates the client which fetches the server version. Implements
 // the discovery.ServerVersionInterface, allowing the creation of a
 // mock or fake for testing.
 type ServerVersionClient struct {
 	flags          *genericclioptions.ConfigFlags
-	delegate       restclient.Interface
-	requestTimeout time.Duration // Query timeout duration
-	cacheMaxAge    uint64        // Maximum cache age allowed in seconds
+	config         *restclient.Config // Used instead of the flags, if set
+	connFlags      *ConnectionFlags
+	delegate       restclient.Interface // Authenticated
+	anonymous      restclient.Interface // Without credentials
+	anonymousFirst bool                 // Query without credentials first
+	fresh          bool                 // New connections, no cached answer
+	env            []string             // Environment of the pod, if any
+	accountDir     string               // Service account credentials of the pod
+	host           string               // Cluster of the delegate
+	requestTimeout time.Duration        // Query timeout duration
+	cacheMaxAge    uint64               // Maximum cache age allowed in seconds
+	latencies      *LatencyHistory
+	roundTrips     int  // Queries which reached the server, if counted
+	countsTrips    bool // The transport counts roundTrips, under the HTTP cache
+	sleep          func(time.Duration)
+	now            func() time.Time
 }
 
 var _ discovery.ServerVersionInterface = &ServerVersionClient{}
@@ -49,15 +81,37 @@ func NewServerVersionClient(kubeConfigFlags *genericclioptions.ConfigFlags) *Ser
 		delegate:       nil,
 		requestTimeout: defaultRequestTimeout,
 		cacheMaxAge:    defaultCacheMaxAge,
+		anonymousFirst: true,
+		env:            os.Environ(),
+		accountDir:     ServiceAccountDir,
+		sleep:          time.Sleep,
+		now:            time.Now,
 	}
 }
 
+// NewServerVersionClientForConfig returns a client querying the server
+// version of the cluster in the REST config (e.g. rest.InClusterConfig()),
+// instead of the cluster selected by kube config flags.
+func NewServerVersionClientForConfig(config *restclient.Config) *ServerVersionClient {
+	c := NewServerVersionClient(genericclioptions.NewConfigFlags(false))
+	c.config = config
+	return c
+}
+
+// SetConnectionFlags sets the kubectl connection flags which are not part
+// of the kube config flags (e.g. --tls-server-name).
+func (c *ServerVersionClient) SetConnectionFlags(connFlags *ConnectionFlags) {
+	c.connFlags = connFlags
+}
+
 func (c *ServerVersionClient) GetRequestTimeout() time.Duration {
 	return c.requestTimeout
 }
 
+// SetRequestTimeout sets the query timeout, in the format of kubectl's
+// --request-timeout flag: a duration, or an integer number of seconds.
 func (c *ServerVersionClient) SetRequestTimeout(requestTimeout string) error {
-	timeout, err := time.ParseDuration(requestTimeout)
+	timeout, err := clientcmd.ParseTimeout(requestTimeout)
 	if err != nil {
 		return err
 	}
@@ -65,6 +119,52 @@ func (c *ServerVersionClient) SetRequestTimeout(requestTimeout string) error {
 	return nil
 }
 
+// SetLatencyHistory makes the query timeout adapt to the latency of the
+// cluster recorded in the history, instead of the request timeout, and
+// records the latency of each query.
+func (c *ServerVersionClient) SetLatencyHistory(latencies *LatencyHistory) {
+	c.latencies = latencies
+}
+
+// SetClock sets the function returning the current time, which measures
+// the latency of queries.
+func (c *ServerVersionClient) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// SetAnonymousFirst sets whether the server version is first queried
+// without credentials (the default), which avoids running credential
+// plugins. Queries refused without credentials are repeated with them.
+func (c *ServerVersionClient) SetAnonymousFirst(anonymousFirst bool) {
+	c.anonymousFirst = anonymousFirst
+}
+
+// SetFreshConnections sets whether each query is made on a new connection,
+// and bypasses the HTTP cache, so that successive queries may be answered
+// by different API servers behind a load balancer.
+func (c *ServerVersionClient) SetFreshConnections(fresh bool) {
+	c.fresh = fresh
+}
+
+// SetInClusterEnv sets the environment (as returned by os.Environ()) and
+// the service account directory of the pod the client may run in, in which
+// case the service account is used if no cluster is selected otherwise.
+// The defaults are the process environment and ServiceAccountDir.
+func (c *ServerVersionClient) SetInClusterEnv(env []string, serviceAccountDir string) {
+	c.env = env
+	c.accountDir = serviceAccountDir
+}
+
+// IsInCluster returns true if the client queries the cluster with the
+// service account of the pod it runs in.
+func (c *ServerVersionClient) IsInCluster() bool {
+	if c.config != nil {
+		return false
+	}
+	inClusterConfig, err := c.inClusterConfig()
+	return err == nil && inClusterConfig != nil
+}
+
 func (c *ServerVersionClient) GetCacheMaxAge() uint64 {
 	return c.cacheMaxAge
 }
@@ -73,52 +173,271 @@ func (c *ServerVersionClient) SetCacheMaxAge(cacheMaxAge uint64) {
 	c.cacheMaxAge = cacheMaxAge
 }
 
+// ServerVersion queries the server version. The server version is public
+// on most clusters, so the query is first made without credentials (but
+// still verifying the server certificate), and only repeated with them if
+// it is refused (see anonymousRefusal). Credential plugins (e.g. exec
+// plugins) then run only when they are needed.
 func (c *ServerVersionClient) ServerVersion() (*version.Info, error) {
-	request, err := c.createRequest()
-	if err != nil {
-		return nil, err
-	}
-	body, err := request.DoRaw()
+	body, err := c.get(serverVersionPath, nil)
 	if err != nil {
 		return nil, err
 	}
 	var info version.Info
 	err = json.Unmarshal(body, &info)
 	if err != nil {
-		return nil, fmt.Errorf("got '%s': %v", string(body), err)
+		return nil, fmt.Errorf("got '%s': %w", string(body), err)
 	}
 	return &info, nil
 }
 
+// get queries the path of the server, first without credentials unless
+// told otherwise, and returns the body of the response.
+func (c *ServerVersionClient) get(path string, params url.Values) ([]byte, error) {
+	// A delegate already set is used as is (e.g. a fake in tests, or after
+	// a refused anonymous query).
+	if c.anonymousFirst && c.delegate == nil {
+		body, err := c.getWithRetries(path, params, true, c.GetCacheMaxAge())
+		refusal := anonymousRefusal(body, err)
+		if refusal == nil {
+			return body, err
+		}
+		klog.V(3).Infof("Query of %s without credentials refused; retrying with credentials: %v", path, refusal)
+		// The HTTP cache keeps the refusal, which must not answer the query
+		// with credentials; the answer replaces it.
+		return c.getWithRetries(path, params, false, 0)
+	}
+	return c.getWithRetries(path, params, false, c.GetCacheMaxAge())
+}
+
+// anonymousRefusal returns why the server refused a query without
+// credentials, or nil if it did not. Besides refusing it outright (401 or
+// 403), the server may require a client certificate in the TLS handshake,
+// or an authenticating proxy may redirect to its login page, which is not
+// JSON like every answer of the API server.
+func anonymousRefusal(body []byte, err error) error {
+	if err == nil {
+		if !json.Valid(body) {
+			return fmt.Errorf("unexpected response (not JSON, e.g. a login page): %.40q", body)
+		}
+		return nil
+	}
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) || isRedirect(err) || isCertificateRefused(err) {
+		return err
+	}
+	return nil
+}
+
+// isRedirect returns true for a redirect (3xx) which was not followed.
+func isRedirect(err error) bool {
+	if status, ok := err.(apierrors.APIStatus); ok {
+		return status.Status().Code >= 300 && status.Status().Code < 400
+	}
+	return false
+}
+
+// isCertificateRefused returns true if the server ended the TLS handshake
+// with an alert for a missing or refused client certificate.
+func isCertificateRefused(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return false
+	}
+	switch opErr.Err.Error() {
+	case "tls: certificate required", "tls: bad certificate", "tls: handshake failure":
+		return true
+	}
+	return false
+}
+
+// getWithRetries queries the path, with or without credentials, accepting
+// a cached response up to the passed age in seconds. Transient errors are
+// retried a few times, with jittered exponential backoff.
+func (c *ServerVersionClient) getWithRetries(path string, params url.Values, anonymous bool, cacheMaxAge uint64) ([]byte, error) {
+	for retry := 0; ; retry++ {
+		body, err := c.doRequest(path, params, anonymous, cacheMaxAge)
+		if err == nil {
+			return body, nil
+		}
+		if retry >= maxRetries || !isTransient(err) {
+			return nil, err
+		}
+		backoff := retryBackoff << uint(retry)
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		klog.V(3).Infof("Retrying query of %s in %s: %v", path, backoff, err)
+		c.sleep(backoff)
+	}
+}
+
 const (
 	userAgentHeader    = "User-Agent"
 	cacheControlHeader = "Cache-Control"
+	connectionHeader   = "Connection"
 	serverVersionPath  = "/version"
 )
 
-func (c *ServerVersionClient) createRequest() (*restclient.Request, error) {
-	if c.delegate == nil {
-		discoveryClient, err := c.flags.ToDiscoveryClient()
+// doRequest queries the path once. With a latency history, the timeout is
+// adapted to the cluster, and the latency of server version queries is
+// recorded unless the query failed early, or was answered by the HTTP cache
+// without reaching the server.
+func (c *ServerVersionClient) doRequest(path string, params url.Values, anonymous bool, cacheMaxAge uint64) ([]byte, error) {
+	request, err := c.createRequest(path, params, anonymous, cacheMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	timeout := c.timeout()
+	roundTrips := c.roundTrips
+	start := c.now()
+	body, err := request.DoRaw()
+	latency := c.now().Sub(start)
+	cached := c.countsTrips && c.roundTrips == roundTrips
+	klog.V(4).Infof("Query of %s%s took %s (timeout %s, cached %t)", c.host, path, latency, timeout, cached)
+	if c.latencies != nil && path == serverVersionPath && !cached && (err == nil || latency >= timeout) {
+		if err := c.latencies.Record(c.host, latency); err != nil {
+			klog.V(3).Infof("Unable to record server version query latency: %v", err)
+		}
+	}
+	return body, err
+}
+
+// timeout returns the query timeout for the cluster.
+func (c *ServerVersionClient) timeout() time.Duration {
+	if c.latencies != nil {
+		return c.latencies.Timeout(c.host)
+	}
+	return c.GetRequestTimeout()
+}
+
+// isTransient returns true for errors worth retrying: connection resets,
+// connections closed early, and server errors (5xx).
+func isTransient(err error) bool {
+	if utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	if status, ok := err.(apierrors.APIStatus); ok {
+		return status.Status().Code >= 500
+	}
+	return false
+}
+
+func (c *ServerVersionClient) createRequest(path string, params url.Values, anonymous bool, cacheMaxAge uint64) (*restclient.Request, error) {
+	delegate := &c.delegate
+	if anonymous {
+		delegate = &c.anonymous
+	}
+	if *delegate == nil {
+		discoveryClient, err := c.toDiscoveryClient(anonymous)
 		if err != nil {
 			return nil, err
 		}
-		c.delegate = discoveryClient.RESTClient()
+		*delegate = discoveryClient.RESTClient()
 	}
-	request := c.delegate.Get()
+	request := (*delegate).Get()
 	request.SetHeader(userAgentHeader, c.getUserAgent())
-	request.SetHeader(cacheControlHeader, fmt.Sprintf("max-age=%d", c.GetCacheMaxAge()))
-	request.Timeout(c.GetRequestTimeout())
-	request.AbsPath(serverVersionPath)
+	if c.fresh {
+		cacheMaxAge = 0
+		request.SetHeader(connectionHeader, "close")
+	}
+	request.SetHeader(cacheControlHeader, fmt.Sprintf("max-age=%d", cacheMaxAge))
+	request.Timeout(c.timeout())
+	request.AbsPath(path)
+	for name, values := range params {
+		for _, value := range values {
+			request.Param(name, value)
+		}
+	}
 	return request, nil
 }
 
-const (
-	dispatcherVersion   = "1.0"
-	dispatcherUserAgent = "kubectl-dispatcher/v%s (%s/%s)"
-)
+var overlyCautiousIllegalFileCharacters = regexp.MustCompile(`[^(\w/\.)]`)
+
+// toDiscoveryClient returns the same cached discovery client as the kube
+// config flags, with the connection settings the flags are missing applied.
+// An anonymous client has no credentials, but verifies the server as usual.
+func (c *ServerVersionClient) toDiscoveryClient(anonymous bool) (discovery.CachedDiscoveryInterface, error) {
+	config, err := c.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	if anonymous {
+		config = restclient.AnonymousClientConfig(config)
+	}
+	config.Burst = 100
+	c.host = config.Host
+	httpCacheDir := filepath.Join(homedir.HomeDir(), ".kube", "http-cache")
+	if c.flags.CacheDir != nil {
+		httpCacheDir = *c.flags.CacheDir
+	}
+	// Count the queries which reach the server: the HTTP cache wraps the
+	// transport after these wrappers.
+	wt := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wt != nil {
+			rt = wt(rt)
+		}
+		return &countingRoundTripper{delegate: rt, count: &c.roundTrips}
+	}
+	c.countsTrips = true
+	schemelessHost := strings.Replace(strings.Replace(config.Host, "https://", "", 1), "http://", "", 1)
+	discoveryCacheDir := filepath.Join(homedir.HomeDir(), ".kube", "cache", "discovery",
+		overlyCautiousIllegalFileCharacters.ReplaceAllString(schemelessHost, "_"))
+	return discovery.NewCachedDiscoveryClientForConfig(config, discoveryCacheDir, httpCacheDir, 10*time.Minute)
+}
+
+// countingRoundTripper counts the requests it sends.
+type countingRoundTripper struct {
+	delegate http.RoundTripper
+	count    *int
+}
+
+func (rt *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	*rt.count++
+	return rt.delegate.RoundTrip(req)
+}
+
+// restConfig returns the REST config of the client, or else the config of
+// the kube config flags (or of the pod's service account, see
+// inClusterConfig), with the connection settings they are missing applied.
+func (c *ServerVersionClient) restConfig() (*restclient.Config, error) {
+	if c.config != nil {
+		return restclient.CopyConfig(c.config), nil
+	}
+	config, err := c.inClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		if config, err = c.flags.ToRESTConfig(); err != nil {
+			return nil, err
+		}
+	}
+	settings, err := loadClusterSettings(c.flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyConnectionSettings(config, c.connFlags, settings); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Host returns the URL of the cluster the client queries, without
+// querying it.
+func (c *ServerVersionClient) Host() (string, error) {
+	config, err := c.restConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Host, nil
+}
+
+// DispatcherVersion is the version of the dispatcher itself.
+const DispatcherVersion = "1.0"
+
+const dispatcherUserAgent = "kubectl-dispatcher/v%s (%s/%s)"
 
 func (c *ServerVersionClient) getUserAgent() string {
 	os := runtime.GOOS
 	arch := runtime.GOARCH
-	return fmt.Sprintf(dispatcherUserAgent, dispatcherVersion, os, arch)
+	return fmt.Sprintf(dispatcherUserAgent, DispatcherVersion, os, arch)
 }
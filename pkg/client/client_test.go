@@ -19,9 +19,12 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -165,6 +168,156 @@ func TestServerVersionErrorOnBadVersion(t *testing.T) {
 	}
 }
 
+func TestRequestTimeoutSeconds(t *testing.T) {
+	svclient := NewServerVersionClient(nil)
+	// An integer is a number of seconds, as with kubectl --request-timeout.
+	if err := svclient.SetRequestTimeout("12"); err != nil {
+		t.Errorf("Unexpected error setting request timeout: (%v)", err)
+	}
+	if actual := svclient.GetRequestTimeout(); actual != 12*time.Second {
+		t.Errorf("Request timeout error: expected (12s), got (%s)", actual)
+	}
+}
+
+// fakeVersionServer returns a fake RESTClient answering server version
+// queries with the passed status codes in turn, and counting the queries.
+func fakeVersionServer(t *testing.T, queries *int, timeouts *[]string, statusCodes ...int) *fake.RESTClient {
+	serverVersionBytes, err := json.Marshal(*createServerVersion(1, 10))
+	if err != nil {
+		t.Fatalf("Unexpected JSON marshal error for server version: (%v)", err)
+	}
+	return &fake.RESTClient{
+		NegotiatedSerializer: resource.UnstructuredPlusDefaultContentConfig().NegotiatedSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			statusCode := statusCodes[*queries]
+			*queries++
+			if timeouts != nil {
+				*timeouts = append(*timeouts, req.URL.Query().Get("timeout"))
+			}
+			body := ioutil.NopCloser(bytes.NewReader(serverVersionBytes))
+			if statusCode != 200 {
+				body = ioutil.NopCloser(bytes.NewReader([]byte("error")))
+			}
+			return &http.Response{StatusCode: statusCode, Header: defaultHeader(), Body: body}, nil
+		}),
+	}
+}
+
+func TestServerVersionRetries(t *testing.T) {
+	tests := []struct {
+		statusCodes     []int
+		expectedQueries int
+		expectedError   bool
+	}{
+		{statusCodes: []int{200}, expectedQueries: 1},
+		// Server errors are retried.
+		{statusCodes: []int{503, 500, 200}, expectedQueries: 3},
+		// Retries are bounded.
+		{statusCodes: []int{503, 503, 503, 200}, expectedQueries: 3, expectedError: true},
+		// Client errors are not retried.
+		{statusCodes: []int{404, 200}, expectedQueries: 1, expectedError: true},
+		{statusCodes: []int{401, 200}, expectedQueries: 1, expectedError: true},
+	}
+	for _, test := range tests {
+		queries := 0
+		backoffs := []time.Duration{}
+		svclient := NewServerVersionClient(nil)
+		svclient.delegate = fakeVersionServer(t, &queries, nil, test.statusCodes...)
+		svclient.sleep = func(d time.Duration) { backoffs = append(backoffs, d) }
+		_, err := svclient.ServerVersion()
+		if test.expectedError != (err != nil) {
+			t.Errorf("ServerVersion error for (%v): expected error (%t), got (%v)", test.statusCodes, test.expectedError, err)
+		}
+		if test.expectedQueries != queries {
+			t.Errorf("ServerVersion queries for (%v): expected (%d), got (%d)", test.statusCodes, test.expectedQueries, queries)
+		}
+		// Each backoff is doubled, with up to as much jitter.
+		for i, backoff := range backoffs {
+			min := retryBackoff << uint(i)
+			if backoff < min || backoff >= 2*min {
+				t.Errorf("Backoff (%d) error: expected [%s, %s), got (%s)", i, min, 2*min, backoff)
+			}
+		}
+	}
+}
+
+func TestServerVersionIsTransient(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: fmt.Errorf("read tcp: connection reset by peer"), expected: true},
+		{err: io.EOF, expected: true},
+		{err: fmt.Errorf("x509: certificate signed by unknown authority"), expected: false},
+		{err: fmt.Errorf("context deadline exceeded"), expected: false},
+	}
+	for _, test := range tests {
+		if actual := isTransient(test.err); test.expected != actual {
+			t.Errorf("isTransient(%v) error: expected (%t), got (%t)", test.err, test.expected, actual)
+		}
+	}
+}
+
+func TestServerVersionAdaptiveTimeout(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	latencies := NewLatencyHistory(tmp)
+	latencies.Record("", 4*time.Second)
+
+	queries := 0
+	timeouts := []string{}
+	svclient := NewServerVersionClient(nil)
+	svclient.delegate = fakeVersionServer(t, &queries, &timeouts, 200)
+	svclient.SetLatencyHistory(latencies)
+	if _, err := svclient.ServerVersion(); err != nil {
+		t.Fatalf("Unexpected error retrieving ServerVersion: %v", err)
+	}
+	if len(timeouts) != 1 || timeouts[0] != "12s" {
+		t.Errorf("Adaptive timeout error: expected (12s), got (%v)", timeouts)
+	}
+	// The fast query was recorded; the slow one is still the slowest.
+	if samples := latencies.load()[""]; len(samples) != 2 {
+		t.Errorf("Expected (2) latency samples, got (%v)", samples)
+	}
+}
+
+// Answers of the HTTP cache, which never reach the server, are not
+// recorded as latencies.
+func TestServerVersionLatencyCached(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "13", "gitVersion": "v1.13.4"}`)
+	}))
+	defer server.Close()
+	// The timeout, which is part of the cached URL, stays the shortest.
+	latencies := NewLatencyHistory(tmp)
+	latencies.Record(server.URL, time.Millisecond)
+	svclient := newTestClient(t, tmp, writeKubeConfig(t, tmp, "    server: "+server.URL))
+	svclient.SetLatencyHistory(latencies)
+	svclient.SetCacheMaxAge(3600)
+	for i := 0; i < 2; i++ {
+		if _, err := svclient.ServerVersion(); err != nil {
+			t.Fatalf("Unexpected error retrieving ServerVersion: %v", err)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("Expected (1) query to reach the server, got (%d)", queries)
+	}
+	if samples := latencies.load()[server.URL]; len(samples) != 2 {
+		t.Errorf("Expected (2) latency samples, got (%v)", samples)
+	}
+}
+
 func createServerVersion(major int, minor int) *version.Info {
 	return &version.Info{
 		Major: strconv.Itoa(major),
@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	restclient "k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// ConnectionFlags are the kubectl connection flags which the vendored
+// genericclioptions.ConfigFlags does not define.
+type ConnectionFlags struct {
+	TLSServerName      *string
+	DisableCompression *bool
+}
+
+// NewConnectionFlags returns ConnectionFlags with default values.
+func NewConnectionFlags() *ConnectionFlags {
+	tlsServerName := ""
+	disableCompression := false
+	return &ConnectionFlags{
+		TLSServerName:      &tlsServerName,
+		DisableCompression: &disableCompression,
+	}
+}
+
+// AddFlags binds the connection flags to the flag set, with kubectl's names.
+func (f *ConnectionFlags) AddFlags(flags *pflag.FlagSet) {
+	if f.TLSServerName != nil {
+		flags.StringVar(f.TLSServerName, "tls-server-name", *f.TLSServerName, "Server name to use for server certificate validation. If it is not provided, the hostname used to contact the server is used")
+	}
+	if f.DisableCompression != nil {
+		flags.BoolVar(f.DisableCompression, "disable-compression", *f.DisableCompression, "If true, opt-out of response compression for all requests to the server")
+	}
+}
+
+// clusterSettings are the kubeconfig cluster fields which the vendored
+// kubeconfig loader drops.
+type clusterSettings struct {
+	TLSServerName      string           `json:"tls-server-name,omitempty"`
+	ProxyURL           string           `json:"proxy-url,omitempty"`
+	DisableCompression bool             `json:"disable-compression,omitempty"`
+	Extensions         []namedExtension `json:"extensions,omitempty"`
+}
+
+// namedExtension is a kubeconfig extension, left undecoded.
+type namedExtension struct {
+	Name      string          `json:"name"`
+	Extension json.RawMessage `json:"extension"`
+}
+
+// kubeconfigClusters is the subset of a kubeconfig file holding clusters.
+type kubeconfigClusters struct {
+	Clusters []struct {
+		Name    string          `json:"name"`
+		Cluster clusterSettings `json:"cluster"`
+	} `json:"clusters"`
+}
+
+// loadClusterSettings returns the dropped fields of the cluster selected
+// by the kube config flags (--cluster, or the cluster of the current
+// context). As when kubeconfig files are merged, the first file defining
+// the cluster wins.
+func loadClusterSettings(flags *genericclioptions.ConfigFlags) (*clusterSettings, error) {
+	loader := flags.ToRawKubeConfigLoader()
+	rawConfig, err := loader.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	contextName := rawConfig.CurrentContext
+	if flags.Context != nil && *flags.Context != "" {
+		contextName = *flags.Context
+	}
+	clusterName := ""
+	if context, ok := rawConfig.Contexts[contextName]; ok {
+		clusterName = context.Cluster
+	}
+	if flags.ClusterName != nil && *flags.ClusterName != "" {
+		clusterName = *flags.ClusterName
+	}
+	settings := &clusterSettings{}
+	if clusterName == "" {
+		return settings, nil
+	}
+	configAccess := loader.ConfigAccess()
+	filenames := configAccess.GetLoadingPrecedence()
+	if explicitFile := configAccess.GetExplicitFile(); explicitFile != "" {
+		filenames = []string{explicitFile}
+	}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var clusters kubeconfigClusters
+		if err := yaml.Unmarshal(data, &clusters); err != nil {
+			return nil, fmt.Errorf("error loading %s: %v", filename, err)
+		}
+		for _, c := range clusters.Clusters {
+			if c.Name == clusterName {
+				return &c.Cluster, nil
+			}
+		}
+	}
+	return settings, nil
+}
+
+// applyConnectionSettings sets the TLS server name, proxy and compression
+// of the REST config. Flags take precedence over the kubeconfig cluster.
+func applyConnectionSettings(config *restclient.Config, flags *ConnectionFlags, settings *clusterSettings) error {
+	config.TLSClientConfig.ServerName = settings.TLSServerName
+	disableCompression := settings.DisableCompression
+	if flags != nil {
+		if flags.TLSServerName != nil && *flags.TLSServerName != "" {
+			config.TLSClientConfig.ServerName = *flags.TLSServerName
+		}
+		if flags.DisableCompression != nil && *flags.DisableCompression {
+			disableCompression = true
+		}
+	}
+	var proxyURL *url.URL
+	if settings.ProxyURL != "" {
+		var err error
+		if proxyURL, err = url.Parse(settings.ProxyURL); err != nil {
+			return fmt.Errorf("invalid proxy-url %q: %v", settings.ProxyURL, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy-url %q: unsupported scheme %q", settings.ProxyURL, proxyURL.Scheme)
+		}
+	}
+	if proxyURL == nil && !disableCompression {
+		return nil
+	}
+	// The REST config has no proxy or compression settings, so change a
+	// copy of the underlying transport instead, before any other wrapper.
+	wrapTransport := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if transport, ok := rt.(*http.Transport); ok {
+			transport = transport.Clone()
+			if proxyURL != nil {
+				transport.Proxy = http.ProxyURL(proxyURL)
+			}
+			if disableCompression {
+				transport.DisableCompression = true
+			}
+			rt = transport
+		}
+		if wrapTransport != nil {
+			rt = wrapTransport(rt)
+		}
+		return rt
+	}
+	return nil
+}
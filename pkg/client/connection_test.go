@@ -0,0 +1,645 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	restclient "k8s.io/client-go/rest"
+)
+
+const versionBody = `{"major": "1", "minor": "13", "gitVersion": "v1.13.4"}`
+
+// writeKubeConfig writes a kubeconfig file with a single cluster.
+func writeKubeConfig(t *testing.T, dir string, cluster string) string {
+	kubeconfig := filepath.Join(dir, "config")
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+%s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: secret
+`, cluster)
+	if err := ioutil.WriteFile(kubeconfig, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kubeconfig
+}
+
+// newTestClient returns a server version client for the kubeconfig file
+// and the passed command line flags, which may include unknown flags.
+func newTestClient(t *testing.T, dir string, kubeconfig string, args ...string) *ServerVersionClient {
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flagSet.ParseErrorsWhitelist.UnknownFlags = true
+	kubeConfigFlags := genericclioptions.NewConfigFlags(true)
+	kubeConfigFlags.AddFlags(flagSet)
+	connFlags := NewConnectionFlags()
+	connFlags.AddFlags(flagSet)
+	cacheDir, err := ioutil.TempDir(dir, "http-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args = append([]string{"--kubeconfig=" + kubeconfig, "--cache-dir=" + cacheDir}, args...)
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatalf("Unexpected error parsing flags (%v): %v", args, err)
+	}
+	c := NewServerVersionClient(kubeConfigFlags)
+	c.SetConnectionFlags(connFlags)
+	c.SetCacheMaxAge(0)
+	return c
+}
+
+func TestServerVersionTLSServerName(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	serverNames := []string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverNames = append(serverNames, r.TLS.ServerName)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer server.Close()
+	ca := filepath.Join(tmp, "ca.crt")
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, caData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cluster  string
+		args     []string
+		expected string
+	}{
+		// The server is contacted by IP address, so no server name is sent.
+		{
+			cluster:  "",
+			expected: "",
+		},
+		{
+			cluster:  "    tls-server-name: example.com",
+			expected: "example.com",
+		},
+		// The flag overrides the kubeconfig, and unknown flags are ignored.
+		{
+			cluster:  "    tls-server-name: example.org",
+			args:     []string{"--tls-server-name=example.com", "--unknown-flag=foo"},
+			expected: "example.com",
+		},
+	}
+	for _, test := range tests {
+		serverNames = serverNames[:0]
+		cluster := fmt.Sprintf("    server: %s\n    certificate-authority: %s\n%s", server.URL, ca, test.cluster)
+		c := newTestClient(t, tmp, writeKubeConfig(t, tmp, cluster), test.args...)
+		actual, err := c.ServerVersion()
+		if err != nil {
+			t.Errorf("Unexpected error retrieving ServerVersion: %v", err)
+			continue
+		}
+		if actual.GitVersion != "v1.13.4" {
+			t.Errorf("Server version error: expected (v1.13.4), got (%s)", actual.GitVersion)
+		}
+		if len(serverNames) != 1 || serverNames[0] != test.expected {
+			t.Errorf("TLS server name error: expected (%s), got (%v)", test.expected, serverNames)
+		}
+	}
+}
+
+func TestServerVersionProxyAndCompression(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// The proxy answers for the unreachable cluster server.
+	proxied := []string{}
+	acceptEncodings := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer proxy.Close()
+	proxyCluster := fmt.Sprintf("    server: http://cluster.invalid\n    proxy-url: %s\n", proxy.URL)
+
+	tests := []struct {
+		cluster        string
+		args           []string
+		acceptEncoding string
+	}{
+		{
+			cluster:        proxyCluster,
+			acceptEncoding: "gzip",
+		},
+		{
+			cluster: proxyCluster + "    disable-compression: true",
+		},
+		{
+			cluster: proxyCluster,
+			args:    []string{"--disable-compression"},
+		},
+	}
+	for _, test := range tests {
+		proxied, acceptEncodings = proxied[:0], acceptEncodings[:0]
+		c := newTestClient(t, tmp, writeKubeConfig(t, tmp, test.cluster), test.args...)
+		if _, err := c.ServerVersion(); err != nil {
+			t.Errorf("Unexpected error retrieving ServerVersion: %v", err)
+			continue
+		}
+		if len(proxied) != 1 || proxied[0] != "http://cluster.invalid/version?timeout=5s" {
+			t.Errorf("Proxied request error: expected (http://cluster.invalid/version?timeout=5s), got (%v)", proxied)
+			continue
+		}
+		if acceptEncodings[0] != test.acceptEncoding {
+			t.Errorf("Accept-Encoding error: expected (%s), got (%s)", test.acceptEncoding, acceptEncodings[0])
+		}
+	}
+}
+
+func TestServerVersionBadProxyURL(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cluster := "    server: http://cluster.invalid\n    proxy-url: ftp://proxy.invalid"
+	c := newTestClient(t, tmp, writeKubeConfig(t, tmp, cluster))
+	if _, err := c.ServerVersion(); err == nil {
+		t.Errorf("Expected error for unsupported proxy-url scheme did not occur")
+	}
+}
+
+func TestServerVersionClientForConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	authorizations := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer server.Close()
+
+	c := NewServerVersionClientForConfig(&restclient.Config{Host: server.URL, BearerToken: "token"})
+	*c.flags.CacheDir = tmp
+	host, err := c.Host()
+	if err != nil || host != server.URL {
+		t.Errorf("Host: expected (%s), got (%s, %v)", server.URL, host, err)
+	}
+	actual, err := c.ServerVersion()
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving ServerVersion: %v", err)
+	}
+	if actual.GitVersion != "v1.13.4" {
+		t.Errorf("Server version error: expected (v1.13.4), got (%s)", actual.GitVersion)
+	}
+	// The query is refused without credentials, then repeated with them.
+	if len(authorizations) != 2 || authorizations[1] != "Bearer token" {
+		t.Errorf("Authorization error: expected (, Bearer token), got (%v)", authorizations)
+	}
+}
+
+func TestServerVersionFreshConnections(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	queries, closed := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if r.Close {
+			closed++
+		}
+		// Cacheable, so that only fresh queries reach the server again.
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer server.Close()
+
+	kubeconfig := writeKubeConfig(t, tmp, "    server: "+server.URL)
+	c := newTestClient(t, tmp, kubeconfig)
+	c.SetCacheMaxAge(3600)
+	c.SetFreshConnections(true)
+	for i := 0; i < 3; i++ {
+		if _, err := c.ServerVersion(); err != nil {
+			t.Fatalf("Unexpected error retrieving ServerVersion: %v", err)
+		}
+	}
+	if queries != 3 || closed != 3 {
+		t.Errorf("Fresh connections: expected (3) queries on closed connections, got (%d, %d closed)", queries, closed)
+	}
+}
+
+func TestServerVersionAnonymousFirst(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The credential plugin is a shell script")
+	}
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// The credential plugin counts its runs.
+	runs := filepath.Join(tmp, "runs")
+	plugin := filepath.Join(tmp, "plugin.sh")
+	script := fmt.Sprintf(`#!/bin/sh
+echo run >> %s
+echo '{"apiVersion": "client.authentication.k8s.io/v1beta1", "kind": "ExecCredential", "status": {"token": "plugin-token"}}'
+`, runs)
+	if err := ioutil.WriteFile(plugin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pluginRuns := func() int {
+		contents, _ := ioutil.ReadFile(runs)
+		return strings.Count(string(contents), "run")
+	}
+
+	anonymousStatus := http.StatusOK
+	authorizations := []string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		authorizations = append(authorizations, authorization)
+		if authorization == "" && anonymousStatus != http.StatusOK {
+			w.WriteHeader(anonymousStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer server.Close()
+	ca := filepath.Join(tmp, "ca.crt")
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, caData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A CA which did not sign the server certificate.
+	otherCA := filepath.Join(tmp, "other-ca.crt")
+	if err := ioutil.WriteFile(otherCA, newCA(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Credential plugins are cached by their configuration within the
+	// process, so each query runs the plugin with other arguments.
+	writeConfig := func(ca string, pluginArg string) string {
+		kubeconfig := filepath.Join(tmp, "config")
+		data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: %s
+      args: [%q]
+`, server.URL, ca, plugin, pluginArg)
+		if err := ioutil.WriteFile(kubeconfig, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return kubeconfig
+	}
+
+	tests := []struct {
+		name                   string
+		ca                     string
+		anonymousStatus        int
+		expectedAuthorizations []string
+		expectedPluginRuns     int
+		expectError            bool
+	}{
+		{
+			name:                   "public",
+			ca:                     ca,
+			anonymousStatus:        http.StatusOK,
+			expectedAuthorizations: []string{""},
+			expectedPluginRuns:     0,
+		},
+		{
+			name:                   "unauthorized",
+			ca:                     ca,
+			anonymousStatus:        http.StatusUnauthorized,
+			expectedAuthorizations: []string{"", "Bearer plugin-token"},
+			expectedPluginRuns:     1,
+		},
+		{
+			name:                   "forbidden",
+			ca:                     ca,
+			anonymousStatus:        http.StatusForbidden,
+			expectedAuthorizations: []string{"", "Bearer plugin-token"},
+			expectedPluginRuns:     1,
+		},
+		// The server certificate is verified without credentials too, and
+		// a failure is not retried with them.
+		{
+			name:                   "unknown CA",
+			ca:                     otherCA,
+			anonymousStatus:        http.StatusOK,
+			expectedAuthorizations: []string{},
+			expectedPluginRuns:     0,
+			expectError:            true,
+		},
+	}
+	for _, test := range tests {
+		os.Remove(runs)
+		authorizations = authorizations[:0]
+		anonymousStatus = test.anonymousStatus
+		c := newTestClient(t, tmp, writeConfig(test.ca, test.name))
+		actual, err := c.ServerVersion()
+		if test.expectError != (err != nil) {
+			t.Errorf("ServerVersion (%s) error: expected error (%t), got (%v)", test.name, test.expectError, err)
+		}
+		if err == nil && actual.GitVersion != "v1.13.4" {
+			t.Errorf("ServerVersion (%s): expected (v1.13.4), got (%s)", test.name, actual.GitVersion)
+		}
+		if !isStringSliceEqual(test.expectedAuthorizations, authorizations) {
+			t.Errorf("ServerVersion (%s) authorizations: expected (%q), got (%q)", test.name, test.expectedAuthorizations, authorizations)
+		}
+		if actual := pluginRuns(); actual != test.expectedPluginRuns {
+			t.Errorf("ServerVersion (%s) credential plugin runs: expected (%d), got (%d)", test.name, test.expectedPluginRuns, actual)
+		}
+	}
+
+	// The cached refusal is replaced by the version queried with
+	// credentials, which answers the next anonymous query.
+	cacheDir := filepath.Join(tmp, "shared-http-cache")
+	anonymousStatus = http.StatusUnauthorized
+	for i, expected := range [][]string{{"", "Bearer plugin-token"}, {}} {
+		os.Remove(runs)
+		authorizations = authorizations[:0]
+		c := newTestClient(t, tmp, writeConfig(ca, fmt.Sprintf("cached %d", i)), "--cache-dir="+cacheDir)
+		c.SetCacheMaxAge(3600)
+		if _, err := c.ServerVersion(); err != nil {
+			t.Errorf("Cached ServerVersion (%d): unexpected error (%v)", i, err)
+		}
+		if !isStringSliceEqual(expected, authorizations) {
+			t.Errorf("Cached ServerVersion (%d) authorizations: expected (%q), got (%q)", i, expected, authorizations)
+		}
+	}
+	if pluginRuns() != 0 {
+		t.Errorf("Cached ServerVersion: expected no credential plugin runs, got (%d)", pluginRuns())
+	}
+
+	// Anonymous queries can be disabled.
+	os.Remove(runs)
+	authorizations = authorizations[:0]
+	anonymousStatus = http.StatusOK
+	c := newTestClient(t, tmp, writeConfig(ca, "authenticated"))
+	c.SetAnonymousFirst(false)
+	if _, err := c.ServerVersion(); err != nil {
+		t.Errorf("Authenticated ServerVersion: unexpected error (%v)", err)
+	}
+	if expected := []string{"Bearer plugin-token"}; !isStringSliceEqual(expected, authorizations) {
+		t.Errorf("Authenticated ServerVersion authorizations: expected (%q), got (%q)", expected, authorizations)
+	}
+}
+
+// Refusals other than 401 and 403 are retried with credentials too.
+func TestServerVersionAnonymousRefusals(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "connection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	certPEM, keyPEM, cert := newClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	writeFile := func(name string, contents []byte) string {
+		path := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	clientCert, clientKey := writeFile("client.crt", certPEM), writeFile("client.key", keyPEM)
+
+	tests := []struct {
+		name string
+		// handler answers queries without credentials.
+		handler    http.HandlerFunc
+		clientAuth tls.ClientAuthType
+	}{
+		{
+			name: "login redirect",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/login" {
+					fmt.Fprint(w, "<html><body>Sign in</body></html>")
+					return
+				}
+				http.Redirect(w, r, "/login", http.StatusFound)
+			},
+		},
+		{
+			name: "login page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, "<html><body>Sign in</body></html>")
+			},
+		},
+		{
+			name:       "client certificate required",
+			clientAuth: tls.RequireAndVerifyClientCert,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("Unexpected query without client certificate")
+			},
+		},
+	}
+	for _, test := range tests {
+		authenticated := 0
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+				test.handler(w, r)
+				return
+			}
+			authenticated++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, versionBody)
+		}))
+		server.TLS = &tls.Config{ClientAuth: test.clientAuth, ClientCAs: clientCAs}
+		server.StartTLS()
+		ca := writeFile("ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+		user := "    token: secret"
+		if test.clientAuth != tls.NoClientCert {
+			user = fmt.Sprintf("    client-certificate: %s\n    client-key: %s", clientCert, clientKey)
+		}
+		kubeconfig := writeFile("config", []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+%s
+`, server.URL, ca, user)))
+		actual, err := newTestClient(t, tmp, kubeconfig).ServerVersion()
+		if err != nil || actual.GitVersion != "v1.13.4" {
+			t.Errorf("ServerVersion (%s): expected (v1.13.4), got (%v, %v)", test.name, actual, err)
+		}
+		if authenticated != 1 {
+			t.Errorf("ServerVersion (%s): expected (1) query with credentials, got (%d)", test.name, authenticated)
+		}
+		server.Close()
+	}
+}
+
+func TestAnonymousRefusal(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		err           error
+		expectRefusal bool
+	}{
+		{name: "version", body: versionBody},
+		{name: "login page", body: "<html></html>", expectRefusal: true},
+		{name: "unauthorized", err: apierrors.NewUnauthorized("anonymous"), expectRefusal: true},
+		{name: "redirect", err: &apierrors.StatusError{ErrStatus: metav1.Status{Code: http.StatusFound}}, expectRefusal: true},
+		{name: "certificate required", err: &url.Error{Op: "Get", Err: &net.OpError{Op: "remote error", Err: fmt.Errorf("tls: certificate required")}}, expectRefusal: true},
+		{name: "server error", err: apierrors.NewInternalError(fmt.Errorf("down"))},
+		{name: "connection refused", err: &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}},
+	}
+	for _, test := range tests {
+		if refusal := anonymousRefusal([]byte(test.body), test.err); test.expectRefusal != (refusal != nil) {
+			t.Errorf("anonymousRefusal (%s): expected refusal (%t), got (%v)", test.name, test.expectRefusal, refusal)
+		}
+	}
+}
+
+// newClientCertificate returns a new self-signed client certificate, and
+// its key, PEM encoded.
+func newClientCertificate(t *testing.T) ([]byte, []byte, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), cert
+}
+
+// newCA returns a new self-signed CA certificate, PEM encoded.
+func newCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func isStringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	restclient "k8s.io/client-go/rest"
+)
+
+// ServiceAccountDir is where the kubelet mounts the credentials of the
+// service account of a pod.
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Files of the service account directory.
+const (
+	serviceAccountTokenFile = "token"
+	serviceAccountCAFile    = "ca.crt"
+)
+
+// Environment variables set by the kubelet in every pod (unless service
+// links are disabled), locating the API server.
+const (
+	ServiceHostEnv = "KUBERNETES_SERVICE_HOST"
+	ServicePortEnv = "KUBERNETES_SERVICE_PORT"
+)
+
+// InClusterConfig returns the config of the service account of the pod, as
+// rest.InClusterConfig does, but reading the passed environment (as returned
+// by os.Environ()) and service account directory. Outside a pod, the error
+// is rest.ErrNotInCluster.
+func InClusterConfig(env []string, serviceAccountDir string) (*restclient.Config, error) {
+	host, _ := config.LookupEnv(env, ServiceHostEnv)
+	port, _ := config.LookupEnv(env, ServicePortEnv)
+	if host == "" || port == "" {
+		return nil, restclient.ErrNotInCluster
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, serviceAccountTokenFile))
+	if err != nil {
+		return nil, err
+	}
+	// The token is kept out of anonymous queries, unlike the token source
+	// of rest.InClusterConfig.
+	inClusterConfig := &restclient.Config{
+		Host:        "https://" + net.JoinHostPort(host, port),
+		BearerToken: strings.TrimSpace(string(token)),
+	}
+	if caFile := filepath.Join(serviceAccountDir, serviceAccountCAFile); isFile(caFile) {
+		inClusterConfig.TLSClientConfig.CAFile = caFile
+	}
+	return inClusterConfig, nil
+}
+
+// inClusterConfig returns the config of the service account of the pod the
+// client runs in, if, as for kubectl, neither the kubeconfig (--kubeconfig,
+// $KUBECONFIG or ~/.kube/config) nor --server selects a cluster. Otherwise,
+// or outside a pod, it returns nil. As for kubectl, --token and
+// --certificate-authority override the service account's.
+func (c *ServerVersionClient) inClusterConfig() (*restclient.Config, error) {
+	if host, _ := config.LookupEnv(c.env, ServiceHostEnv); host == "" {
+		return nil, nil
+	}
+	if !isFile(filepath.Join(c.accountDir, serviceAccountTokenFile)) {
+		return nil, nil
+	}
+	if c.SelectsCluster() {
+		return nil, nil
+	}
+	inClusterConfig, err := InClusterConfig(c.env, c.accountDir)
+	if err != nil {
+		return nil, err
+	}
+	if c.flags.BearerToken != nil && *c.flags.BearerToken != "" {
+		inClusterConfig.BearerToken = *c.flags.BearerToken
+	}
+	if c.flags.CAFile != nil && *c.flags.CAFile != "" {
+		inClusterConfig.TLSClientConfig.CAFile = *c.flags.CAFile
+	}
+	return inClusterConfig, nil
+}
+
+// SelectsCluster returns true if the REST config, a kubeconfig context,
+// --cluster or --server selects the cluster, in which case, as for kubectl,
+// the service account of the pod is not used. A kubeconfig that does not
+// load selects a cluster too: the kube config flags report its error.
+func (c *ServerVersionClient) SelectsCluster() bool {
+	if c.config != nil {
+		return true
+	}
+	if c.flags.APIServer != nil && *c.flags.APIServer != "" {
+		return true
+	}
+	if c.flags.ClusterName != nil && *c.flags.ClusterName != "" {
+		return true
+	}
+	rawConfig, err := c.flags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return true
+	}
+	contextName := rawConfig.CurrentContext
+	if c.flags.Context != nil && *c.flags.Context != "" {
+		contextName = *c.flags.Context
+	}
+	_, ok := rawConfig.Contexts[contextName]
+	return ok
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInClusterConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "incluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// A fake API server, which refuses anonymous queries.
+	authorizations := []string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		authorizations = append(authorizations, authorization)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versionBody)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile := filepath.Join(tmp, "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The service account credentials mounted in the pod.
+	serviceAccountDir := filepath.Join(tmp, "serviceaccount")
+	if err := os.Mkdir(serviceAccountDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(serviceAccountDir, "token"), []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(serviceAccountDir, "ca.crt"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	podEnv := []string{ServiceHostEnv + "=" + serverURL.Hostname(), ServicePortEnv + "=" + serverURL.Port()}
+	emptyConfig := filepath.Join(tmp, "empty")
+	if err := ioutil.WriteFile(emptyConfig, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	clusterConfig := writeKubeConfig(t, tmp, fmt.Sprintf("    server: %s\n    certificate-authority: %s", server.URL, caFile))
+
+	tests := []struct {
+		name                   string
+		kubeconfig             string
+		args                   []string
+		env                    []string
+		serviceAccountDir      string
+		expectedInCluster      bool
+		expectedSelectsCluster bool
+		expectedAuthorization  string // Empty if the query fails
+		// The kubeconfig selects no server, so the query is not made.
+		noQuery bool
+	}{
+		{
+			name:                  "no kubeconfig cluster",
+			kubeconfig:            emptyConfig,
+			env:                   podEnv,
+			serviceAccountDir:     serviceAccountDir,
+			expectedInCluster:     true,
+			expectedAuthorization: "Bearer sa-token",
+		},
+		{
+			name:                  "token flag",
+			kubeconfig:            emptyConfig,
+			args:                  []string{"--token=flag-token"},
+			env:                   podEnv,
+			serviceAccountDir:     serviceAccountDir,
+			expectedInCluster:     true,
+			expectedAuthorization: "Bearer flag-token",
+		},
+		{
+			name:                   "kubeconfig cluster",
+			kubeconfig:             clusterConfig,
+			env:                    podEnv,
+			serviceAccountDir:      serviceAccountDir,
+			expectedSelectsCluster: true,
+			expectedAuthorization:  "Bearer secret",
+		},
+		{
+			name:                   "server flag",
+			kubeconfig:             emptyConfig,
+			args:                   []string{"--server=" + server.URL, "--certificate-authority=" + caFile},
+			env:                    podEnv,
+			serviceAccountDir:      serviceAccountDir,
+			expectedSelectsCluster: true,
+		},
+		{
+			name:              "no service account token",
+			kubeconfig:        emptyConfig,
+			env:               podEnv,
+			serviceAccountDir: filepath.Join(tmp, "nonexistent"),
+			noQuery:           true,
+		},
+		{
+			name:              "outside a pod",
+			kubeconfig:        emptyConfig,
+			env:               []string{},
+			serviceAccountDir: serviceAccountDir,
+			noQuery:           true,
+		},
+	}
+	for _, test := range tests {
+		authorizations = []string{}
+		c := newTestClient(t, tmp, test.kubeconfig, test.args...)
+		c.SetInClusterEnv(test.env, test.serviceAccountDir)
+		c.SetRequestTimeout("1s")
+		if actual := c.IsInCluster(); actual != test.expectedInCluster {
+			t.Errorf("IsInCluster(%s): expected (%t), got (%t)", test.name, test.expectedInCluster, actual)
+		}
+		if actual := c.SelectsCluster(); actual != test.expectedSelectsCluster {
+			t.Errorf("SelectsCluster(%s): expected (%t), got (%t)", test.name, test.expectedSelectsCluster, actual)
+		}
+		if test.expectedInCluster {
+			if host, err := c.Host(); err != nil || host != server.URL {
+				t.Errorf("Host(%s): expected (%s), got (%s, %v)", test.name, server.URL, host, err)
+			}
+		}
+		if test.noQuery {
+			continue
+		}
+		actual, err := c.ServerVersion()
+		if test.expectedAuthorization == "" {
+			if err == nil {
+				t.Errorf("ServerVersion(%s): expected error, got (%v)", test.name, actual)
+			}
+			if len(authorizations) != 0 {
+				t.Errorf("ServerVersion(%s) authorizations: expected none, got (%v)", test.name, authorizations)
+			}
+			continue
+		}
+		if err != nil || actual.GitVersion != "v1.13.4" {
+			t.Errorf("ServerVersion(%s): expected (v1.13.4), got (%v, %v)", test.name, actual, err)
+		}
+		if len(authorizations) != 1 || authorizations[0] != test.expectedAuthorization {
+			t.Errorf("ServerVersion(%s) authorizations: expected (%s), got (%v)", test.name, test.expectedAuthorization, authorizations)
+		}
+	}
+}
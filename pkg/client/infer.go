@@ -0,0 +1,257 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+const (
+	openAPIV3Path = "/openapi/v3"
+	apisPath      = "/apis"
+)
+
+// Preferred OpenAPI v3 documents to read the version from, smallest first.
+var openAPIDocuments = []string{"version", "apis", "api"}
+
+// apiGroupVersion is a group version of the Kubernetes API, with the minor
+// versions of Kubernetes 1.x which introduced it (enabled by default) and
+// removed it. Zero means before the table, or never removed.
+type apiGroupVersion struct {
+	groupVersion string
+	introduced   int
+	removed      int
+}
+
+// apiGroupVersions are the group versions whose presence (or absence) in
+// discovery bounds the server version. Beta group versions reintroduced
+// after their removal (e.g. storage.k8s.io/v1beta1) are left out.
+var apiGroupVersions = []apiGroupVersion{
+	{"apps/v1beta1", 0, 16},
+	{"apps/v1beta2", 8, 16},
+	{"apps/v1", 9, 0},
+	{"apiregistration.k8s.io/v1", 10, 0},
+	{"apiregistration.k8s.io/v1beta1", 0, 22},
+	{"coordination.k8s.io/v1", 14, 0},
+	{"scheduling.k8s.io/v1", 14, 0},
+	{"scheduling.k8s.io/v1beta1", 0, 22},
+	{"networking.k8s.io/v1beta1", 14, 22},
+	{"admissionregistration.k8s.io/v1", 16, 0},
+	{"apiextensions.k8s.io/v1", 16, 0},
+	{"apiextensions.k8s.io/v1beta1", 0, 22},
+	{"authorization.k8s.io/v1beta1", 0, 22},
+	{"extensions/v1beta1", 0, 22},
+	{"rbac.authorization.k8s.io/v1beta1", 0, 22},
+	{"certificates.k8s.io/v1", 19, 0},
+	{"events.k8s.io/v1", 19, 0},
+	{"events.k8s.io/v1beta1", 0, 25},
+	{"node.k8s.io/v1", 20, 0},
+	{"node.k8s.io/v1beta1", 0, 25},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", 20, 26},
+	{"discovery.k8s.io/v1", 21, 0},
+	{"discovery.k8s.io/v1beta1", 0, 25},
+	{"policy/v1", 21, 0},
+	{"policy/v1beta1", 0, 25},
+	{"batch/v1beta1", 0, 25},
+	{"autoscaling/v2", 23, 0},
+	{"autoscaling/v2beta1", 0, 25},
+	{"autoscaling/v2beta2", 0, 26},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", 23, 29},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", 26, 32},
+	{"flowcontrol.apiserver.k8s.io/v1", 29, 0},
+	{"resource.k8s.io/v1", 34, 0},
+}
+
+// IsVersionBlocked returns true if the server version query failed because
+// the server (or a proxy in front of it) refuses or hides /version, so that
+// inferring the version is worth trying.
+func IsVersionBlocked(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsNotFound(err)
+}
+
+// InferServerVersion infers the server version from other documents than
+// /version, for servers or proxies which refuse or hide it: the version of
+// the OpenAPI v3 documents, or else the minor version whose API group
+// versions match those in discovery. The explanation says how the version
+// was inferred. The API server sends no header with its version, so the
+// headers of the responses are not used.
+func (c *ServerVersionClient) InferServerVersion() (*version.Info, string, error) {
+	info, openAPIErr := c.openAPIVersion()
+	if openAPIErr == nil {
+		return info, "inferred from the OpenAPI info.version", nil
+	}
+	klog.V(3).Infof("Unable to infer the server version from OpenAPI: %v", openAPIErr)
+	info, explanation, err := c.discoveryVersion()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to infer the server version from OpenAPI (%v) or discovery (%v)", openAPIErr, err)
+	}
+	return info, explanation, nil
+}
+
+// openAPIVersion returns the version in the info of an OpenAPI v3 document,
+// which is the version of the server.
+func (c *ServerVersionClient) openAPIVersion() (*version.Info, error) {
+	body, err := c.get(openAPIV3Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Paths map[string]struct {
+			ServerRelativeURL string `json:"serverRelativeURL"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("got '%s': %w", string(body), err)
+	}
+	if len(index.Paths) == 0 {
+		return nil, fmt.Errorf("no OpenAPI v3 documents")
+	}
+	name := ""
+	for _, preferred := range openAPIDocuments {
+		if _, ok := index.Paths[preferred]; ok {
+			name = preferred
+			break
+		}
+	}
+	if name == "" {
+		names := make([]string, 0, len(index.Paths))
+		for n := range index.Paths {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		name = names[0]
+	}
+	// The URL holds the hash of the document as a query parameter.
+	u, err := url.Parse(index.Paths[name].ServerRelativeURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = openAPIV3Path + "/" + name
+	}
+	body, err = c.get(u.Path, u.Query())
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", u.Path, err)
+	}
+	info, err := util.ParseVersion(document.Info.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u.Path, err)
+	}
+	return &info, nil
+}
+
+// discoveryVersion returns the minor version whose API group versions match
+// those in discovery.
+func (c *ServerVersionClient) discoveryVersion() (*version.Info, string, error) {
+	body, err := c.get(apisPath, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var groups metav1.APIGroupList
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, "", fmt.Errorf("got '%s': %w", string(body), err)
+	}
+	present := map[string]bool{}
+	for _, group := range groups.Groups {
+		for _, v := range group.Versions {
+			present[v.GroupVersion] = true
+		}
+	}
+	return inferFromGroupVersions(present)
+}
+
+// maxInferredRange is the widest range of minor versions whose middle is
+// within the supported skew of kubectl (one minor version) of all of them.
+const maxInferredRange = 3
+
+// inferFromGroupVersions returns the minor version whose API group versions
+// match the present ones. If several minor versions match, the middle one
+// is returned, and the explanation gives the range. A range wider than
+// maxInferredRange, or with no upper bound, may put the server out of the
+// supported skew of the kubectl chosen for the middle version, so it is
+// flagged in the explanation and warned about.
+func inferFromGroupVersions(present map[string]bool) (*version.Info, string, error) {
+	low, high := 0, 0 // Zero is unbounded
+	for _, gv := range apiGroupVersions {
+		if present[gv.groupVersion] && gv.introduced > low {
+			low = gv.introduced
+		}
+	}
+	for _, gv := range apiGroupVersions {
+		switch {
+		case present[gv.groupVersion]:
+			if gv.removed > 0 && (high == 0 || gv.removed-1 < high) {
+				high = gv.removed - 1
+			}
+		// The absence of a group version introduced after the others, and
+		// never removed, means the server is older.
+		case gv.introduced > low && gv.removed == 0:
+			if high == 0 || gv.introduced-1 < high {
+				high = gv.introduced - 1
+			}
+		}
+	}
+	if low == 0 {
+		return nil, "", fmt.Errorf("no known API group versions")
+	}
+	if high != 0 && high < low {
+		return nil, "", fmt.Errorf("API group versions of 1.%d and later, and of 1.%d and earlier", low, high)
+	}
+	minor := low
+	var explanation string
+	switch {
+	case high == low:
+		explanation = fmt.Sprintf("inferred from discovery: the API group versions of 1.%d", low)
+	case high == 0:
+		explanation = fmt.Sprintf("inferred from discovery: the API group versions of 1.%d or later; using 1.%d, wider than the supported skew", low, minor)
+	default:
+		minor = (low + high) / 2
+		explanation = fmt.Sprintf("inferred from discovery: the API group versions of 1.%d to 1.%d; using 1.%d, the middle of the range", low, high, minor)
+		if high-low+1 > maxInferredRange {
+			explanation += ", wider than the supported skew"
+		}
+	}
+	if high != low && (high == 0 || high-low+1 > maxInferredRange) {
+		upper := "later"
+		if high != 0 {
+			upper = fmt.Sprintf("1.%d", high)
+		}
+		klog.Warningf("The server version, inferred from discovery, could be anything from 1.%d to %s; using 1.%d, which may be out of the supported skew of the server", low, upper, minor)
+	}
+	info, err := util.ParseVersion(fmt.Sprintf("v1.%d.0", minor))
+	if err != nil {
+		return nil, "", err
+	}
+	return &info, explanation, nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestInferFromGroupVersions(t *testing.T) {
+	tests := []struct {
+		name                string
+		groupVersions       []string
+		expected            string
+		expectedExplanation string
+		expectedErr         bool
+	}{
+		{
+			name:                "1.14 to 1.15",
+			groupVersions:       []string{"apps/v1", "apps/v1beta2", "extensions/v1beta1", "networking.k8s.io/v1beta1", "coordination.k8s.io/v1", "scheduling.k8s.io/v1"},
+			expected:            "v1.14.0",
+			expectedExplanation: "1.14 to 1.15; using 1.14, the middle of the range",
+		},
+		{
+			name:                "1.21",
+			groupVersions:       []string{"apps/v1", "discovery.k8s.io/v1", "policy/v1", "policy/v1beta1", "extensions/v1beta1", "flowcontrol.apiserver.k8s.io/v1beta1"},
+			expected:            "v1.21.0",
+			expectedExplanation: "the API group versions of 1.21",
+		},
+		{
+			name:                "1.26 to 1.28",
+			groupVersions:       []string{"apps/v1", "autoscaling/v2", "flowcontrol.apiserver.k8s.io/v1beta2", "flowcontrol.apiserver.k8s.io/v1beta3"},
+			expected:            "v1.27.0",
+			expectedExplanation: "1.26 to 1.28; using 1.27, the middle of the range",
+		},
+		{
+			name:                "1.29 to 1.33",
+			groupVersions:       []string{"apps/v1", "flowcontrol.apiserver.k8s.io/v1"},
+			expected:            "v1.31.0",
+			expectedExplanation: "1.29 to 1.33; using 1.31, the middle of the range, wider than the supported skew",
+		},
+		{
+			name:                "1.34 or later",
+			groupVersions:       []string{"apps/v1", "flowcontrol.apiserver.k8s.io/v1", "resource.k8s.io/v1"},
+			expected:            "v1.34.0",
+			expectedExplanation: "1.34 or later; using 1.34, wider than the supported skew",
+		},
+		{
+			name:          "unknown",
+			groupVersions: []string{"example.com/v1"},
+			expectedErr:   true,
+		},
+		{
+			name:          "inconsistent",
+			groupVersions: []string{"flowcontrol.apiserver.k8s.io/v1", "extensions/v1beta1"},
+			expectedErr:   true,
+		},
+	}
+	for _, test := range tests {
+		present := map[string]bool{}
+		for _, gv := range test.groupVersions {
+			present[gv] = true
+		}
+		info, explanation, err := inferFromGroupVersions(present)
+		if test.expectedErr != (err != nil) {
+			t.Errorf("inferFromGroupVersions(%s) error: expected error (%t), got (%v)", test.name, test.expectedErr, err)
+			continue
+		}
+		if err == nil && (info.GitVersion != test.expected || !strings.Contains(explanation, test.expectedExplanation)) {
+			t.Errorf("inferFromGroupVersions(%s): expected (%s, %s), got (%s, %s)", test.name, test.expected, test.expectedExplanation, info.GitVersion, explanation)
+		}
+		if err == nil && test.expected != "" && strings.Contains(explanation, "wider") != strings.Contains(test.expectedExplanation, "wider") {
+			t.Errorf("inferFromGroupVersions(%s): unexpected skew warning in (%s)", test.name, explanation)
+		}
+	}
+}
+
+const openAPIIndex = `{"paths": {
+  "api/v1": {"serverRelativeURL": "/openapi/v3/api/v1?hash=A"},
+  "version": {"serverRelativeURL": "/openapi/v3/version?hash=B"}
+}}`
+
+const apisBody = `{"kind": "APIGroupList", "groups": [
+  {"name": "apps", "versions": [{"groupVersion": "apps/v1", "version": "v1"}]},
+  {"name": "flowcontrol.apiserver.k8s.io", "versions": [
+    {"groupVersion": "flowcontrol.apiserver.k8s.io/v1beta3", "version": "v1beta3"},
+    {"groupVersion": "flowcontrol.apiserver.k8s.io/v1beta2", "version": "v1beta2"}
+  ]}
+]}`
+
+func TestInferServerVersion(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "infer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tests := []struct {
+		name                string
+		documents           map[string]string // Bodies by path and hash
+		expected            string
+		expectedExplanation string
+		expectedErr         bool
+	}{
+		{
+			name: "OpenAPI",
+			documents: map[string]string{
+				"/openapi/v3":                openAPIIndex,
+				"/openapi/v3/version?hash=B": `{"openapi": "3.0.0", "info": {"title": "Kubernetes", "version": "v1.27.3"}}`,
+				"/apis":                      apisBody,
+			},
+			expected:            "v1.27.3",
+			expectedExplanation: "OpenAPI",
+		},
+		{
+			name: "unversioned OpenAPI",
+			documents: map[string]string{
+				"/openapi/v3":                openAPIIndex,
+				"/openapi/v3/version?hash=B": `{"openapi": "3.0.0", "info": {"title": "Kubernetes", "version": "unversioned"}}`,
+				"/apis":                      apisBody,
+			},
+			expected:            "v1.27.0",
+			expectedExplanation: "1.26 to 1.28",
+		},
+		{
+			name: "discovery",
+			documents: map[string]string{
+				"/apis": apisBody,
+			},
+			expected:            "v1.27.0",
+			expectedExplanation: "1.26 to 1.28",
+		},
+		{
+			name:        "nothing",
+			documents:   map[string]string{},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.URL.Path
+			if hash := r.URL.Query().Get("hash"); hash != "" {
+				key += "?hash=" + hash
+			}
+			body, ok := test.documents[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, body)
+		}))
+		c := NewServerVersionClientForConfig(&restclient.Config{Host: server.URL})
+		*c.flags.CacheDir = tmp
+		info, explanation, err := c.InferServerVersion()
+		server.Close()
+		if test.expectedErr != (err != nil) {
+			t.Errorf("InferServerVersion(%s) error: expected error (%t), got (%v)", test.name, test.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if info.GitVersion != test.expected {
+			t.Errorf("InferServerVersion(%s): expected (%s), got (%s)", test.name, test.expected, info.GitVersion)
+		}
+		if !strings.Contains(explanation, test.expectedExplanation) {
+			t.Errorf("InferServerVersion(%s) explanation: expected (%s), got (%s)", test.name, test.expectedExplanation, explanation)
+		}
+	}
+}
+
+func TestIsVersionBlocked(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		expected   bool
+	}{
+		{statusCode: http.StatusForbidden, expected: true},
+		{statusCode: http.StatusNotFound, expected: true},
+		{statusCode: http.StatusUnauthorized, expected: false},
+		{statusCode: http.StatusInternalServerError, expected: false},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.statusCode)
+		}))
+		c := NewServerVersionClientForConfig(&restclient.Config{Host: server.URL})
+		c.sleep = func(time.Duration) {}
+		_, err := c.ServerVersion()
+		server.Close()
+		if actual := IsVersionBlocked(err); actual != test.expected {
+			t.Errorf("IsVersionBlocked(%d): expected (%t), got (%t: %v)", test.statusCode, test.expected, actual, err)
+		}
+	}
+}
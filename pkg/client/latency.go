@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	latencyFile        = "probe-latency.json"
+	maxLatencySamples  = 10
+	latencyMultiplier  = 3
+	minAdaptiveTimeout = 2 * time.Second
+	maxAdaptiveTimeout = 30 * time.Second
+)
+
+// LatencyHistory records the latest server version probe latencies of each
+// cluster, in milliseconds, to adapt the probe timeout to the cluster: a
+// few times the slowest recent probe, within bounds.
+type LatencyHistory struct {
+	path string
+}
+
+// NewLatencyHistory returns the latency history kept in the cache directory.
+func NewLatencyHistory(cacheDir string) *LatencyHistory {
+	return &LatencyHistory{path: filepath.Join(cacheDir, latencyFile)}
+}
+
+// Timeout returns the probe timeout for the cluster at the host, or the
+// default timeout if no probe of the cluster was recorded.
+func (h *LatencyHistory) Timeout(host string) time.Duration {
+	samples := h.load()[host]
+	if len(samples) == 0 {
+		return defaultRequestTimeout
+	}
+	slowest := int64(0)
+	for _, sample := range samples {
+		if sample > slowest {
+			slowest = sample
+		}
+	}
+	timeout := latencyMultiplier * time.Duration(slowest) * time.Millisecond
+	if timeout < minAdaptiveTimeout {
+		return minAdaptiveTimeout
+	}
+	if timeout > maxAdaptiveTimeout {
+		return maxAdaptiveTimeout
+	}
+	return timeout
+}
+
+// Record adds the latency of a probe of the cluster at the host, keeping
+// only the latest samples.
+func (h *LatencyHistory) Record(host string, latency time.Duration) error {
+	history := h.load()
+	samples := append(history[host], int64(latency/time.Millisecond))
+	if len(samples) > maxLatencySamples {
+		samples = samples[len(samples)-maxLatencySamples:]
+	}
+	history[host] = samples
+	contents, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	// Write and rename, so concurrent dispatchers never read a partial file.
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), latencyFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.path)
+}
+
+// load returns the recorded latencies by host; an unreadable history is
+// empty.
+func (h *LatencyHistory) load() map[string][]int64 {
+	history := map[string][]int64{}
+	if contents, err := ioutil.ReadFile(h.path); err == nil {
+		if err := json.Unmarshal(contents, &history); err != nil {
+			return map[string][]int64{}
+		}
+	}
+	return history
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistory(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const host = "https://cluster"
+
+	tests := []struct {
+		latencies []time.Duration
+		expected  time.Duration
+	}{
+		// No history.
+		{expected: defaultRequestTimeout},
+		// Fast clusters have the minimum timeout.
+		{latencies: []time.Duration{50 * time.Millisecond}, expected: minAdaptiveTimeout},
+		{latencies: []time.Duration{time.Second, 2 * time.Second}, expected: 6 * time.Second},
+		{latencies: []time.Duration{time.Minute}, expected: maxAdaptiveTimeout},
+		// The slow probe has been pushed out of the history.
+		{
+			latencies: []time.Duration{time.Minute, time.Second, time.Second, time.Second, time.Second,
+				time.Second, time.Second, time.Second, time.Second, time.Second, time.Second},
+			expected: 3 * time.Second,
+		},
+	}
+	for i, test := range tests {
+		history := NewLatencyHistory(filepath.Join(tmp, "cache", string(rune('a'+i))))
+		for _, latency := range test.latencies {
+			if err := history.Record(host, latency); err != nil {
+				t.Fatalf("Unexpected error recording latency: %v", err)
+			}
+		}
+		// The history persists, and is kept per cluster.
+		history = NewLatencyHistory(filepath.Join(tmp, "cache", string(rune('a'+i))))
+		if actual := history.Timeout(host); test.expected != actual {
+			t.Errorf("Adaptive timeout error for (%v): expected (%s), got (%s)", test.latencies, test.expected, actual)
+		}
+		if actual := history.Timeout("https://other"); actual != defaultRequestTimeout {
+			t.Errorf("Adaptive timeout error for other cluster: expected (%s), got (%s)", defaultRequestTimeout, actual)
+		}
+	}
+}
+
+func TestLatencyHistoryCorrupt(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ioutil.WriteFile(filepath.Join(tmp, latencyFile), []byte("{not json"), 0644)
+	history := NewLatencyHistory(tmp)
+	if actual := history.Timeout("https://cluster"); actual != defaultRequestTimeout {
+		t.Errorf("Adaptive timeout error: expected (%s), got (%s)", defaultRequestTimeout, actual)
+	}
+	if err := history.Record("https://cluster", 2*time.Second); err != nil {
+		t.Fatalf("Unexpected error recording latency: %v", err)
+	}
+	if actual := history.Timeout("https://cluster"); actual != 6*time.Second {
+		t.Errorf("Adaptive timeout error: expected (6s), got (%s)", actual)
+	}
+}
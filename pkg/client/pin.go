@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// PinExtension is the name of the kubeconfig cluster extension which pins
+// the kubectl version for the cluster, e.g.
+//
+//	clusters:
+//	- name: prod
+//	  cluster:
+//	    server: https://prod.example.com
+//	    extensions:
+//	    - name: kubectl-dispatcher
+//	      extension:
+//	        version: "1.13"
+const PinExtension = "kubectl-dispatcher"
+
+// pin is the value of the PinExtension.
+type pin struct {
+	Version string `json:"version"`
+}
+
+// PinnedVersion returns the kubectl version pinned in the kubeconfig for
+// the cluster selected by the kube config flags, or an empty string if the
+// cluster does not pin a version.
+func PinnedVersion(flags *genericclioptions.ConfigFlags) (string, error) {
+	settings, err := loadClusterSettings(flags)
+	if err != nil {
+		return "", err
+	}
+	for _, extension := range settings.Extensions {
+		if extension.Name != PinExtension {
+			continue
+		}
+		var p pin
+		if err := json.Unmarshal(extension.Extension, &p); err != nil {
+			return "", fmt.Errorf("invalid %s extension: %v", PinExtension, err)
+		}
+		return p.Version, nil
+	}
+	return "", nil
+}
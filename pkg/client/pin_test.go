@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPinnedVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name        string
+		cluster     string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "no extensions",
+			cluster:  "    server: https://127.0.0.1",
+			expected: "",
+		},
+		{
+			name: "other extension",
+			cluster: `    server: https://127.0.0.1
+    extensions:
+    - name: other
+      extension:
+        version: "1.11"`,
+			expected: "",
+		},
+		{
+			name: "pinned",
+			cluster: `    server: https://127.0.0.1
+    extensions:
+    - name: other
+      extension:
+        version: "1.11"
+    - name: kubectl-dispatcher
+      extension:
+        version: "1.13"`,
+			expected: "1.13",
+		},
+		{
+			name: "invalid extension",
+			cluster: `    server: https://127.0.0.1
+    extensions:
+    - name: kubectl-dispatcher
+      extension: "1.13"`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		kubeconfig := writeKubeConfig(t, dir, test.cluster)
+		c := newTestClient(t, dir, kubeconfig)
+		actual, err := PinnedVersion(c.flags)
+		if test.expectError != (err != nil) {
+			t.Errorf("PinnedVersion (%s) error: expected error (%t), got (%v)", test.name, test.expectError, err)
+		}
+		if actual != test.expected {
+			t.Errorf("PinnedVersion (%s): expected (%s), got (%s)", test.name, test.expected, actual)
+		}
+	}
+}
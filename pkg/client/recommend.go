@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// The ConfigMap in which a cluster publishes the kubectl version clients
+// should use, readable by them (e.g. by system:authenticated).
+const (
+	RecommendationNamespace = "kube-public"
+	RecommendationConfigMap = "kubectl-dispatcher"
+)
+
+// Keys of the recommendation ConfigMap.
+const (
+	// RecommendedVersionKey holds a version (e.g. "1.27"), or a range of
+	// versions (e.g. ">=1.26, <1.29").
+	RecommendedVersionKey = "version"
+	// MinDispatcherVersionKey holds the oldest dispatcher version which
+	// may follow the recommendation (e.g. "1.0").
+	MinDispatcherVersionKey = "minDispatcherVersion"
+)
+
+// Recommendation is the kubectl version a cluster recommends.
+type Recommendation struct {
+	Version              string `json:"version,omitempty"`
+	MinDispatcherVersion string `json:"minDispatcherVersion,omitempty"`
+}
+
+// Recommendation returns the kubectl version recommended by the cluster,
+// or nil if the cluster recommends none.
+func (c *ServerVersionClient) Recommendation() (*Recommendation, error) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", RecommendationNamespace, RecommendationConfigMap)
+	body, err := c.get(path, nil)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &configMap); err != nil {
+		return nil, fmt.Errorf("got '%s': %w", string(body), err)
+	}
+	if configMap.Data[RecommendedVersionKey] == "" {
+		return nil, nil
+	}
+	return &Recommendation{
+		Version:              configMap.Data[RecommendedVersionKey],
+		MinDispatcherVersion: configMap.Data[MinDispatcherVersionKey],
+	}, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestRecommendation(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "recommend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		expected    *Recommendation
+		expectedErr bool
+	}{
+		{
+			name:       "version",
+			statusCode: http.StatusOK,
+			body:       `{"kind": "ConfigMap", "data": {"version": ">=1.26, <1.29", "minDispatcherVersion": "1.0"}}`,
+			expected:   &Recommendation{Version: ">=1.26, <1.29", MinDispatcherVersion: "1.0"},
+		},
+		{
+			name:       "no version",
+			statusCode: http.StatusOK,
+			body:       `{"kind": "ConfigMap", "data": {"other": "1.27"}}`,
+		},
+		{
+			name:       "not found",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:        "forbidden",
+			statusCode:  http.StatusForbidden,
+			expectedErr: true,
+		},
+		{
+			name:        "not a ConfigMap",
+			statusCode:  http.StatusOK,
+			body:        `<html>`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		path := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.statusCode)
+			w.Write([]byte(test.body))
+		}))
+		c := NewServerVersionClientForConfig(&restclient.Config{Host: server.URL})
+		*c.flags.CacheDir = tmp
+		actual, err := c.Recommendation()
+		server.Close()
+		if expectedPath := "/api/v1/namespaces/kube-public/configmaps/kubectl-dispatcher"; path != expectedPath {
+			t.Errorf("Recommendation(%s) path: expected (%s), got (%s)", test.name, expectedPath, path)
+		}
+		if test.expectedErr != (err != nil) {
+			t.Errorf("Recommendation(%s) error: expected error (%t), got (%v)", test.name, test.expectedErr, err)
+			continue
+		}
+		if (actual == nil) != (test.expected == nil) || (actual != nil && *actual != *test.expected) {
+			t.Errorf("Recommendation(%s): expected (%v), got (%v)", test.name, test.expected, actual)
+		}
+	}
+}
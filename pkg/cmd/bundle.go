@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/bundle"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const bundleExample = `
+  # On a connected host, export kubectl 1.11 and 1.12 into a signed bundle.
+  kubectl dispatcher bundle export 1.11 1.12 -o kubectl-bundle.tar.gz --signing-key bundle.key
+
+  # On the air-gapped host, verify the bundle and install it next to the dispatcher.
+  kubectl dispatcher bundle import kubectl-bundle.tar.gz --public-key bundle.pub`
+
+// NewCmdBundle returns the "bundle" command, which exports and imports
+// offline bundles of versioned kubectl binaries.
+func NewCmdBundle(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "bundle",
+		Short:   "Export and import offline bundles of versioned kubectl binaries",
+		Example: bundleExample,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	cmd.AddCommand(newCmdBundleExport(streams))
+	cmd.AddCommand(newCmdBundleImport(streams))
+	return cmd
+}
+
+type bundleExportOptions struct {
+	dir        string
+	output     string
+	signingKey string
+	streams    genericclioptions.IOStreams
+}
+
+func newCmdBundleExport(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &bundleExportOptions{output: "-", streams: streams}
+	cmd := &cobra.Command{
+		Use:   "export VERSION...",
+		Short: "Export versioned kubectl binaries as a single bundle",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(args)
+		},
+	}
+	cmd.Flags().StringVar(&o.dir, "dir", o.dir, "Directory of the versioned kubectl binaries. Defaults to the directory of the dispatcher.")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Bundle file to write, or - for stdout.")
+	cmd.Flags().StringVar(&o.signingKey, "signing-key", o.signingKey, "PEM encoded ed25519 private key used to sign the bundle manifest.")
+	return cmd
+}
+
+func (o *bundleExportOptions) run(args []string) error {
+	versions := []version.Info{}
+	for _, arg := range args {
+		v, err := util.ParseVersion(arg)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, v)
+	}
+	var key ed25519.PrivateKey
+	if o.signingKey != "" {
+		var err error
+		if key, err = bundle.LoadPrivateKey(o.signingKey); err != nil {
+			return err
+		}
+	}
+	if o.output == "-" {
+		_, err := bundle.Export(o.streams.Out, builderForDir(o.dir), versions, key)
+		return err
+	}
+	// Write to a temporary file first, so a failed export does not leave
+	// a truncated bundle behind.
+	f, err := ioutil.TempFile(filepath.Dir(o.output), "."+filepath.Base(o.output)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	manifest, err := bundle.Export(f, builderForDir(o.dir), versions, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), o.output); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.ErrOut, "Exported %s to %s\n", manifest, o.output)
+	return nil
+}
+
+type bundleImportOptions struct {
+	dir       string
+	publicKey string
+	streams   genericclioptions.IOStreams
+}
+
+func newCmdBundleImport(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &bundleImportOptions{streams: streams}
+	cmd := &cobra.Command{
+		Use:   "import BUNDLE",
+		Short: "Verify a bundle and install its versioned kubectl binaries",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(args[0])
+		},
+	}
+	cmd.Flags().StringVar(&o.dir, "dir", o.dir, "Directory to install the versioned kubectl binaries into. Defaults to the directory of the dispatcher.")
+	cmd.Flags().StringVar(&o.publicKey, "public-key", o.publicKey, "PEM encoded ed25519 public key used to verify the bundle manifest signature, which the bundle must have.")
+	return cmd
+}
+
+func (o *bundleImportOptions) run(path string) error {
+	opts := bundle.ImportOptions{}
+	if o.publicKey != "" {
+		var err error
+		if opts.PublicKey, err = bundle.LoadPublicKey(o.publicKey); err != nil {
+			return err
+		}
+	}
+	var r io.Reader = o.streams.In
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	manifest, err := bundle.Import(r, builderForDir(o.dir), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.ErrOut, "Imported %s\n", manifest)
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestIsDispatcherCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected bool
+	}{
+		{args: []string{"kubectl", "dispatcher", "bundle"}, expected: true},
+		{args: []string{"kubectl", "dispatcher"}, expected: true},
+		{args: []string{"kubectl", "get", "dispatcher"}, expected: false},
+		{args: []string{"kubectl"}, expected: false},
+		{args: []string{}, expected: false},
+	}
+	for _, test := range tests {
+		if actual := IsDispatcherCommand(test.args); test.expected != actual {
+			t.Errorf("IsDispatcherCommand(%v): expected (%t), got (%t)", test.args, test.expected, actual)
+		}
+	}
+}
+
+// writeKeys writes a PEM encoded ed25519 key pair into the directory, and
+// returns the private and public key file paths.
+func writeKeys(t *testing.T, dir string) (string, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, "bundle.key")
+	publicPath := filepath.Join(dir, "bundle.pub")
+	if err := ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
+
+func TestBundleExportImportCommands(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cmd-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	os.Mkdir(src, 0755)
+	os.Mkdir(dst, 0755)
+	if err := ioutil.WriteFile(filepath.Join(src, "kubectl.1.12"), []byte("fake kubectl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeKeys(t, tmp)
+	bundlePath := filepath.Join(tmp, "bundle.tar.gz")
+
+	streams := genericclioptions.NewTestIOStreamsDiscard()
+	export := NewCmdDispatcher(streams, config.NewConfig(nil))
+	export.SetArgs([]string{"bundle", "export", "v1.12.3", "--dir", src, "-o", bundlePath, "--signing-key", privatePath, "-v=5"})
+	if err := export.Execute(); err != nil {
+		t.Fatalf("Unexpected error running bundle export: %v", err)
+	}
+
+	imp := NewCmdDispatcher(streams, config.NewConfig(nil))
+	imp.SetArgs([]string{"bundle", "import", bundlePath, "--dir", dst, "--public-key", publicPath})
+	if err := imp.Execute(); err != nil {
+		t.Fatalf("Unexpected error running bundle import: %v", err)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "kubectl.1.12"))
+	if err != nil {
+		t.Fatalf("Expected imported binary: %v", err)
+	}
+	if string(contents) != "fake kubectl" {
+		t.Errorf("Imported binary error: expected (fake kubectl), got (%s)", contents)
+	}
+}
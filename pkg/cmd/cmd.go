@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// DispatcherCommandName is the kubectl command reserved for the dispatcher
+// itself. "kubectl dispatcher ..." is never delegated to a versioned kubectl.
+const DispatcherCommandName = "dispatcher"
+
+// IsDispatcherCommand returns true if the command line arguments (including
+// the program name) invoke the dispatcher's own commands.
+func IsDispatcherCommand(args []string) bool {
+	return len(args) > 1 && args[1] == DispatcherCommandName
+}
+
+// NewCmdDispatcher returns the root of the dispatcher's own command tree,
+// for the dispatcher configuration.
+func NewCmdDispatcher(streams genericclioptions.IOStreams, cfg *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          DispatcherCommandName,
+		Short:        "Manage the versioned kubectl binaries used by the kubectl dispatcher",
+		SilenceUsage: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	cmd.SetOutput(streams.ErrOut)
+	cmd.AddCommand(NewCmdBundle(streams))
+	cmd.AddCommand(NewCmdInventory(streams, cfg))
+	allowUnknownFlags(cmd)
+	return cmd
+}
+
+// allowUnknownFlags ignores unknown flags on the command and all of its
+// subcommands, since the logging flags were already parsed by the dispatcher.
+func allowUnknownFlags(cmd *cobra.Command) {
+	cmd.FParseErrWhitelist = cobra.FParseErrWhitelist{UnknownFlags: true}
+	for _, c := range cmd.Commands() {
+		allowUnknownFlags(c)
+	}
+}
+
+// builderForDir returns a FilepathBuilder for the passed directory, or for
+// the directory of the dispatcher if it is empty.
+func builderForDir(dir string) *dfilepath.FilepathBuilder {
+	if dir == "" {
+		return dfilepath.NewFilepathBuilder(&dfilepath.ExeDirGetter{}, os.Stat)
+	}
+	return dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, os.Stat)
+}
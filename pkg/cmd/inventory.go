@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/dispatcher"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/inventory"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+)
+
+const inventoryExample = `
+  # List the versioned kubectl binaries the dispatcher can find.
+  kubectl dispatcher inventory
+
+  # List them as JSON, including the SHA-256 digest of each binary.
+  kubectl dispatcher inventory -o json`
+
+type inventoryOptions struct {
+	output  string
+	dirs    []string
+	cfg     *config.Config
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdInventory returns the "inventory" command, which lists every
+// versioned kubectl binary the dispatcher's locators hold, and the problems
+// which would keep the dispatcher from running it.
+func NewCmdInventory(streams genericclioptions.IOStreams, cfg *config.Config) *cobra.Command {
+	o := &inventoryOptions{output: "table", cfg: cfg, streams: streams}
+	cmd := &cobra.Command{
+		Use:     "inventory",
+		Short:   "List the versioned kubectl binaries the dispatcher can find",
+		Example: inventoryExample,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run()
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format. One of: table|json|yaml.")
+	cmd.Flags().StringSliceVar(&o.dirs, "dir", o.dirs, "Directories to search instead of the configured search paths, layouts and managed store.")
+	return cmd
+}
+
+func (o *inventoryOptions) run() error {
+	inv := inventory.NewInventory(o.cfg.CacheDir)
+	var entries []inventory.Entry
+	if len(o.dirs) > 0 {
+		var err error
+		if entries, err = inv.List(o.dirs); err != nil {
+			return err
+		}
+	} else {
+		binaries, err := dispatcher.New(dispatcher.WithEnv(os.Environ()), dispatcher.WithConfig(o.cfg)).Binaries()
+		if err != nil {
+			return err
+		}
+		entries = inv.ListBinaries(binaries)
+	}
+	return printInventory(o.streams.Out, o.output, entries)
+}
+
+func printInventory(w io.Writer, output string, entries []inventory.Entry) error {
+	switch output {
+	case "json":
+		contents, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(contents))
+		return err
+	case "yaml":
+		contents, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(contents)
+		return err
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSOURCE\tCLIENT VERSION\tPLATFORM\tPATH\tPROBLEMS")
+		for _, e := range entries {
+			clientVersion, platform, problems := e.ClientVersion, e.Platform, strings.Join(e.Problems, "; ")
+			if e.Compressed {
+				clientVersion, platform = "<compressed>", "<compressed>"
+			}
+			if e.Source == locator.SourceOCILayout {
+				clientVersion, platform = "<image>", "<image>"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Version, orNone(e.Source), orNone(clientVersion), orNone(platform), e.Path, orNone(problems))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q: must be one of table|json|yaml", output)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/inventory"
+	"sigs.k8s.io/yaml"
+)
+
+var testEntries = []inventory.Entry{
+	{
+		Version:       "1.12",
+		Path:          "/bin/kubectl.1.12",
+		ClientVersion: "v1.12.3",
+		Platform:      "linux/amd64",
+	},
+	{
+		Version:       "1.13",
+		Path:          "/bin/kubectl.1.13",
+		ClientVersion: "v1.12.9",
+		Platform:      "linux/amd64",
+		Problems:      []string{"version mismatch: named 1.13, reports v1.12.9"},
+	},
+}
+
+func TestPrintInventory(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printInventory(&buf, "table", testEntries); err != nil {
+		t.Fatalf("Unexpected error printing table: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "VERSION") {
+		t.Fatalf("Unexpected table output:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[1], "<none>") || !strings.Contains(lines[2], "version mismatch") {
+		t.Errorf("Unexpected table rows:\n%s", buf.String())
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		buf.Reset()
+		if err := printInventory(&buf, format, testEntries); err != nil {
+			t.Fatalf("Unexpected error printing %s: %v", format, err)
+		}
+		var actual []inventory.Entry
+		var err error
+		if format == "json" {
+			err = json.Unmarshal(buf.Bytes(), &actual)
+		} else {
+			err = yaml.Unmarshal(buf.Bytes(), &actual)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s output: %v", format, err)
+		}
+		if len(actual) != 2 || actual[1].Problems[0] != testEntries[1].Problems[0] {
+			t.Errorf("Unexpected %s output:\n%s", format, buf.String())
+		}
+	}
+
+	if err := printInventory(&buf, "wide", testEntries); err == nil {
+		t.Errorf("Expected error for unknown output format; received none")
+	}
+}
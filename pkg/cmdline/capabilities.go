@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmdline
+
+import "strings"
+
+// Capability is a kubectl command, or a flag of a command, and the first
+// kubectl minor version (of major version 1) to support it outside of
+// "kubectl alpha".
+type Capability struct {
+	Command []string
+	// Flag is the name of the command flag (e.g. "prune-allowlist"), or
+	// empty for the command itself.
+	Flag  string
+	Minor int
+}
+
+func (c Capability) String() string {
+	s := "kubectl " + strings.Join(c.Command, " ")
+	if c.Flag != "" {
+		s += " --" + c.Flag
+	}
+	return s
+}
+
+// Capabilities are the commands and flags added by recent kubectl versions,
+// which older binaries reject as unknown.
+var Capabilities = []Capability{
+	{Command: []string{"diff"}, Minor: 13},
+	{Command: []string{"kustomize"}, Minor: 14},
+	{Command: []string{"rollout", "restart"}, Minor: 15},
+	{Command: []string{"create", "ingress"}, Minor: 19},
+	{Command: []string{"debug"}, Minor: 20},
+	{Command: []string{"create", "token"}, Minor: 24},
+	{Command: []string{"events"}, Minor: 26},
+	{Command: []string{"apply"}, Flag: "prune-allowlist", Minor: 26},
+	{Command: []string{"auth", "whoami"}, Minor: 27},
+}
+
+// Requires returns the newest capability among those the command line uses,
+// which the kubectl minor version must support, or false if it uses none.
+// Plugins require no capability.
+func (cl *CommandLine) Requires(capabilities []Capability) (Capability, bool) {
+	required, found := Capability{}, false
+	if cl.Plugin {
+		return required, false
+	}
+	for _, c := range capabilities {
+		if !isCommand(cl.Command, c.Command) || (c.Flag != "" && !hasFlag(cl.Flags, c.Flag)) {
+			continue
+		}
+		if !found || c.Minor > required.Minor {
+			required, found = c, true
+		}
+	}
+	return required, found
+}
+
+func isCommand(command []string, expected []string) bool {
+	if len(command) != len(expected) {
+		return false
+	}
+	for i := range command {
+		if command[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasFlag(flags []string, name string) bool {
+	for _, flag := range flags {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
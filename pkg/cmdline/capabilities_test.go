@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmdline
+
+import "testing"
+
+func TestRequires(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		expected    string
+		minor       int
+	}{
+		{commandLine: "kubectl get pods"},
+		{commandLine: "kubectl"},
+		{commandLine: "kubectl debug node/worker -it --image=busybox -- chroot /host", expected: "kubectl debug", minor: 20},
+		{commandLine: "kubectl events --for pod/app --watch", expected: "kubectl events", minor: 26},
+		{commandLine: "kubectl --context=dev auth whoami -o yaml", expected: "kubectl auth whoami", minor: 27},
+		{commandLine: "kubectl auth can-i get pods"},
+		{commandLine: "kubectl rollout -n prod restart deploy/app", expected: "kubectl rollout restart", minor: 15},
+		{commandLine: "kubectl apply -f app.yaml --prune --prune-allowlist=core/v1/ConfigMap", expected: "kubectl apply --prune-allowlist", minor: 26},
+		{commandLine: "kubectl apply --prune-allowlist core/v1/ConfigMap -f app.yaml", expected: "kubectl apply --prune-allowlist", minor: 26},
+		{commandLine: "kubectl apply -f app.yaml --prune-whitelist=core/v1/ConfigMap"},
+		// The flag must belong to the command.
+		{commandLine: "kubectl exec pod -- apply --prune-allowlist=x"},
+		{commandLine: "kubectl create token default", expected: "kubectl create token", minor: 24},
+		{commandLine: "kubectl create deployment app --image=nginx"},
+		// Plugins are not kubectl commands.
+		{commandLine: "kubectl events-exporter run"},
+		{commandLine: "kubectl whoami --all"},
+	}
+	for _, test := range tests {
+		cl := Parse(splitCommandLine(test.commandLine), kubeConfigFlagSet())
+		actual, found := cl.Requires(Capabilities)
+		if found != (test.expected != "") || (found && (actual.String() != test.expected || actual.Minor != test.minor)) {
+			t.Errorf("Requires(%s) error: expected (%s 1.%d), got (%s 1.%d, %t)", test.commandLine, test.expected, test.minor, actual, actual.Minor, found)
+		}
+	}
+}
+
+// The newest capability used is required.
+func TestRequiresNewest(t *testing.T) {
+	capabilities := []Capability{
+		{Command: []string{"apply"}, Minor: 10},
+		{Command: []string{"apply"}, Flag: "server-side", Minor: 16},
+		{Command: []string{"apply"}, Flag: "prune-allowlist", Minor: 26},
+	}
+	cl := Parse(splitCommandLine("kubectl apply --prune-allowlist=core/v1/ConfigMap --server-side -f app.yaml"), kubeConfigFlagSet())
+	if actual, _ := cl.Requires(capabilities); actual.Minor != 26 {
+		t.Errorf("Requires error: expected (1.26), got (%s 1.%d)", actual, actual.Minor)
+	}
+	cl = Parse(splitCommandLine("kubectl apply --server-side -f app.yaml"), kubeConfigFlagSet())
+	if actual, _ := cl.Requires(capabilities); actual.Minor != 16 {
+		t.Errorf("Requires error: expected (1.16), got (%s 1.%d)", actual, actual.Minor)
+	}
+}
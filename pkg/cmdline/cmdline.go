@@ -0,0 +1,254 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cmdline parses kubectl command lines the way kubectl does, so the
+// dispatcher only reads the global flags which kubectl itself would read.
+package cmdline
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Builtin kubectl commands, with their subcommands. Any other first word
+// names a plugin (e.g. "kubectl krew" runs "kubectl-krew").
+var builtinCommands = map[string][]string{
+	"alpha":         nil,
+	"annotate":      nil,
+	"api-resources": nil,
+	"api-versions":  nil,
+	"apply":         {"edit-last-applied", "set-last-applied", "view-last-applied"},
+	"attach":        nil,
+	"auth":          {"can-i", "reconcile", "whoami"},
+	"autoscale":     nil,
+	"certificate":   {"approve", "deny"},
+	"cluster-info":  {"dump"},
+	"completion":    nil,
+	"config": {"current-context", "delete-cluster", "delete-context", "delete-user", "get-clusters",
+		"get-contexts", "get-users", "rename-context", "set", "set-cluster", "set-context",
+		"set-credentials", "unset", "use", "use-context", "view"},
+	"convert": nil,
+	"cordon":  nil,
+	"cp":      nil,
+	"create": {"clusterrole", "clusterrolebinding", "configmap", "cronjob", "deployment", "ingress",
+		"job", "namespace", "poddisruptionbudget", "priorityclass", "quota", "role", "rolebinding",
+		"secret", "service", "serviceaccount", "token"},
+	"debug":        nil,
+	"delete":       nil,
+	"describe":     nil,
+	"diff":         nil,
+	"drain":        nil,
+	"edit":         nil,
+	"events":       nil,
+	"exec":         nil,
+	"explain":      nil,
+	"expose":       nil,
+	"get":          nil,
+	"help":         nil,
+	"kustomize":    nil,
+	"label":        nil,
+	"logs":         nil,
+	"options":      nil,
+	"patch":        nil,
+	"plugin":       {"list"},
+	"port-forward": nil,
+	"proxy":        nil,
+	"replace":      nil,
+	"rollout":      {"history", "pause", "restart", "resume", "status", "undo"},
+	"run":          nil,
+	"scale":        nil,
+	"set":          {"env", "image", "resources", "selector", "serviceaccount", "subject"},
+	"taint":        nil,
+	"top":          {"node", "pod"},
+	"uncordon":     nil,
+	"version":      nil,
+	"wait":         nil,
+}
+
+// Global kubectl flags which take a value, other than those in the flag set
+// passed to Parse (e.g. the klog flags).
+var globalValueFlags = map[string]bool{
+	"v":                   true,
+	"vmodule":             true,
+	"log-dir":             true,
+	"log-file":            true,
+	"log-file-max-size":   true,
+	"log-flush-frequency": true,
+	"log-backtrace-at":    true,
+	"stderrthreshold":     true,
+	"profile":             true,
+	"profile-output":      true,
+	"kuberc":              true,
+}
+
+// CommandLine is a parsed kubectl command line.
+type CommandLine struct {
+	// Command is the path of the kubectl command (e.g. ["rollout",
+	// "status"]). For a plugin, it holds every word before the first flag,
+	// since kubectl runs the plugin with the longest matching name.
+	Command []string
+	// Plugin is true if the command is not builtin, so kubectl runs a
+	// plugin, and every argument belongs to the plugin.
+	Plugin bool
+	// Args are the remaining arguments of the command, up to "--". Since
+	// only the global flags are known, these may include the values of
+	// command flags (e.g. "json" in "-o json").
+	Args []string
+	// GlobalFlags are the arguments setting the flags of the flag set
+	// passed to Parse, in order, ready to be parsed by it.
+	GlobalFlags []string
+	// Flags are the names of the long command flags (e.g. "prune-allowlist"
+	// in "kubectl apply --prune-allowlist=core/v1/ConfigMap").
+	Flags []string
+	// Passthrough are the arguments after "--", for the command kubectl
+	// runs (e.g. in "kubectl exec pod -- sh").
+	Passthrough []string
+	// flagValues are the values given with "=" to the long command flags,
+	// by name; empty for a flag without one.
+	flagValues map[string]string
+}
+
+// IsBuiltin returns true if the command (e.g. "get") is a builtin kubectl
+// command, rather than a plugin.
+func IsBuiltin(command string) bool {
+	_, ok := builtinCommands[command]
+	return ok
+}
+
+// Parse parses the kubectl command line arguments (as in os.Args). As in
+// kubectl, global flags may come before or after the command, and nothing
+// after "--" is parsed. If the first argument is not a builtin command, it
+// names a plugin, and the rest of the command line belongs to the plugin.
+// The flag set holds the global flags to collect; the flags within it which
+// take a value (i.e. are not boolean) determine whether the argument after
+// a flag is its value.
+func Parse(args []string, globals *pflag.FlagSet) *CommandLine {
+	cl := &CommandLine{}
+	if len(args) < 2 {
+		return cl
+	}
+	args = args[1:]
+	if first := args[0]; !strings.HasPrefix(first, "-") {
+		if !IsBuiltin(first) {
+			cl.Plugin = true
+			for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+				cl.Command = append(cl.Command, args[0])
+				args = args[1:]
+			}
+			cl.Args = args
+			return cl
+		}
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			cl.Passthrough = args[i+1:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			cl.addPositional(arg)
+			continue
+		}
+		flag, _, known := lookupFlag(arg, globals)
+		consumed := []string{arg}
+		if TakesValue(arg, globals) && i+1 < len(args) {
+			i++
+			consumed = append(consumed, args[i])
+		}
+		if flag != nil {
+			cl.GlobalFlags = append(cl.GlobalFlags, consumed...)
+		} else if !known && strings.HasPrefix(arg, "--") {
+			nameValue := append(strings.SplitN(arg[2:], "=", 2), "")
+			cl.Flags = append(cl.Flags, nameValue[0])
+			if cl.flagValues == nil {
+				cl.flagValues = map[string]string{}
+			}
+			cl.flagValues[nameValue[0]] = nameValue[1]
+		}
+	}
+	return cl
+}
+
+// TakesValue returns true if the global flag argument (e.g. "--context" or
+// "-n") takes the next argument as its value, as kubectl would parse it.
+// Flags which include their value (e.g. "--context=prod"), boolean flags and
+// unknown flags do not.
+func TakesValue(arg string, globals *pflag.FlagSet) bool {
+	flag, inlineValue, known := lookupFlag(arg, globals)
+	takesValue := (flag != nil && flag.NoOptDefVal == "") || (flag == nil && known)
+	return takesValue && !inlineValue
+}
+
+// ClientOnly returns true if the command does not need the server:
+// "kubectl version --client", or a client dry run (--dry-run=client, or the
+// deprecated --dry-run and --dry-run=true).
+func (cl *CommandLine) ClientOnly() bool {
+	if cl.Plugin || len(cl.Command) == 0 {
+		return false
+	}
+	if client, ok := cl.flagValues["client"]; ok && cl.Command[0] == "version" && client != "false" {
+		return true
+	}
+	dryRun, ok := cl.flagValues["dry-run"]
+	return ok && (dryRun == "" || dryRun == "client" || dryRun == "true")
+}
+
+// addPositional adds a positional argument, which may name the command or
+// one of its subcommands.
+func (cl *CommandLine) addPositional(arg string) {
+	switch len(cl.Command) {
+	case 0:
+		if len(cl.Args) == 0 {
+			cl.Command = []string{arg}
+			return
+		}
+	case 1:
+		if len(cl.Args) == 0 {
+			for _, sub := range builtinCommands[cl.Command[0]] {
+				if arg == sub {
+					cl.Command = append(cl.Command, arg)
+					return
+				}
+			}
+		}
+	}
+	cl.Args = append(cl.Args, arg)
+}
+
+// lookupFlag returns the flag in the flag set for the flag argument (e.g.
+// "--namespace=foo", "-n", "-nfoo"), and whether the argument includes the
+// value. If the flag is not in the flag set, known is true for the other
+// global flags which take a value.
+func lookupFlag(arg string, globals *pflag.FlagSet) (flag *pflag.Flag, inlineValue bool, known bool) {
+	if strings.HasPrefix(arg, "--") {
+		name := strings.TrimPrefix(arg, "--")
+		if equals := strings.Index(name, "="); equals >= 0 {
+			name, inlineValue = name[:equals], true
+		}
+		if flag = globals.Lookup(name); flag != nil {
+			return flag, inlineValue, true
+		}
+		return nil, inlineValue, globalValueFlags[name]
+	}
+	// A shorthand flag, with the value attached ("-nfoo" or "-n=foo").
+	shorthand := arg[1:2]
+	inlineValue = len(arg) > 2
+	if flag = globals.ShorthandLookup(shorthand); flag != nil {
+		return flag, inlineValue, true
+	}
+	return nil, inlineValue, globalValueFlags[shorthand]
+}
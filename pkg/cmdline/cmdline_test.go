@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmdline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	utilflag "k8s.io/component-base/cli/flag"
+)
+
+func kubeConfigFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flagSet.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
+	genericclioptions.NewConfigFlags(true).AddFlags(flagSet)
+	return flagSet
+}
+
+// A corpus of real kubectl command lines. Command lines are split on
+// spaces, except within single quotes.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		command     string
+		plugin      bool
+		args        string
+		globalFlags string
+		passthrough string
+	}{
+		{commandLine: "kubectl"},
+		{commandLine: "kubectl --help", command: ""},
+		{commandLine: "kubectl get pods", command: "get", args: "pods"},
+		{commandLine: "kubectl get pods -o wide", command: "get", args: "pods wide"},
+		{commandLine: "kubectl -n kube-system get pods", command: "get", args: "pods", globalFlags: "-n kube-system"},
+		{commandLine: "kubectl -nkube-system get pods", command: "get", args: "pods", globalFlags: "-nkube-system"},
+		{commandLine: "kubectl --context=prod get pods --namespace default", command: "get", args: "pods", globalFlags: "--context=prod --namespace default"},
+		{commandLine: "kubectl get pods --context prod", command: "get", args: "pods", globalFlags: "--context prod"},
+		{commandLine: "kubectl --kubeconfig /tmp/config get nodes", command: "get", args: "nodes", globalFlags: "--kubeconfig /tmp/config"},
+		{commandLine: "kubectl -s https://10.0.0.1 --insecure-skip-tls-verify get ns", command: "get", args: "ns", globalFlags: "-s https://10.0.0.1 --insecure-skip-tls-verify"},
+		{commandLine: "kubectl --insecure-skip-tls-verify get ns", command: "get", args: "ns", globalFlags: "--insecure-skip-tls-verify"},
+		{commandLine: "kubectl --insecure_skip_tls_verify=false get ns", command: "get", args: "ns", globalFlags: "--insecure_skip_tls_verify=false"},
+		{commandLine: "kubectl -v 6 --context dev get pods", command: "get", args: "pods", globalFlags: "--context dev"},
+		{commandLine: "kubectl --v=6 --vmodule foo=3 get pods", command: "get", args: "pods"},
+		{commandLine: "kubectl --request-timeout 3s version --client -o json", command: "version", args: "json", globalFlags: "--request-timeout 3s"},
+		{commandLine: "kubectl logs -f pod --since=1h --context=dev", command: "logs", args: "pod", globalFlags: "--context=dev"},
+		{commandLine: "kubectl port-forward svc/app 8080:80 --address 0.0.0.0", command: "port-forward", args: "svc/app 8080:80 0.0.0.0"},
+		{commandLine: "kubectl apply -f - --server-side", command: "apply", args: "-"},
+		{commandLine: "kubectl apply view-last-applied deploy/app", command: "apply view-last-applied", args: "deploy/app"},
+		{commandLine: "kubectl rollout status deploy/app -n prod", command: "rollout status", args: "deploy/app", globalFlags: "-n prod"},
+		{commandLine: "kubectl rollout -n prod restart deploy/app", command: "rollout restart", args: "deploy/app", globalFlags: "-n prod"},
+		{commandLine: "kubectl config use-context prod", command: "config use-context", args: "prod"},
+		{commandLine: "kubectl config view --minify --raw", command: "config view"},
+		{commandLine: "kubectl create secret generic creds --from-literal=user=admin", command: "create secret", args: "generic creds"},
+		{commandLine: "kubectl create -f app.yaml", command: "create", args: "app.yaml"},
+		{commandLine: "kubectl top pod --all-namespaces", command: "top pod"},
+		{commandLine: "kubectl auth can-i get pods --as=jane --as-group=dev", command: "auth can-i", args: "get pods", globalFlags: "--as=jane --as-group=dev"},
+		{commandLine: "kubectl help get", command: "help", args: "get"},
+		// Nothing after "--" is parsed.
+		{commandLine: "kubectl exec pod -- sh -c 'curl --server=x'", command: "exec", args: "pod", passthrough: "sh -c 'curl --server=x'"},
+		{commandLine: "kubectl exec -it pod -c app --context=dev -- bash", command: "exec", args: "pod app", globalFlags: "--context=dev", passthrough: "bash"},
+		{commandLine: "kubectl run app --image=nginx --command -- app --context=foo", command: "run", args: "app", passthrough: "app --context=foo"},
+		{commandLine: "kubectl debug node/worker -it --image=busybox -- chroot /host", command: "debug", args: "node/worker", passthrough: "chroot /host"},
+		// Plugins own all of their arguments.
+		{commandLine: "kubectl krew install ctx", command: "krew install ctx", plugin: true},
+		{commandLine: "kubectl my-plugin --context=foo -n bar", command: "my-plugin", plugin: true, args: "--context=foo -n bar"},
+		{commandLine: "kubectl ns --server https://10.0.0.1", command: "ns", plugin: true, args: "--server https://10.0.0.1"},
+		// Flags before a plugin name make kubectl look for a builtin command.
+		{commandLine: "kubectl --context=foo krew list", command: "krew", args: "list", globalFlags: "--context=foo"},
+	}
+	for _, test := range tests {
+		cl := Parse(splitCommandLine(test.commandLine), kubeConfigFlagSet())
+		actual := []string{strings.Join(cl.Command, " "), strings.Join(cl.Args, " "), strings.Join(cl.GlobalFlags, " "), strings.Join(cl.Passthrough, " ")}
+		expected := []string{test.command, test.args, test.globalFlags, test.passthrough}
+		if !reflect.DeepEqual(expected, actual) || test.plugin != cl.Plugin {
+			t.Errorf("Parse(%s) error: expected (%q, plugin %t), got (%q, plugin %t)", test.commandLine, expected, test.plugin, actual, cl.Plugin)
+		}
+	}
+}
+
+// The global flags collected by Parse set the flag values.
+func TestParseGlobalFlags(t *testing.T) {
+	commandLine := "kubectl -n kube-system exec -it pod -c app --context=dev -- kubectl --context=prod -n default"
+	flagSet := kubeConfigFlagSet()
+	cl := Parse(splitCommandLine(commandLine), flagSet)
+	if err := flagSet.Parse(cl.GlobalFlags); err != nil {
+		t.Fatalf("Unexpected error parsing global flags: %v", err)
+	}
+	if context, _ := flagSet.GetString("context"); context != "dev" {
+		t.Errorf("Context error: expected (dev), got (%s)", context)
+	}
+	if namespace, _ := flagSet.GetString("namespace"); namespace != "kube-system" {
+		t.Errorf("Namespace error: expected (kube-system), got (%s)", namespace)
+	}
+}
+
+func TestTakesValue(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected bool
+	}{
+		{arg: "--context", expected: true},
+		{arg: "--context=dev"},
+		{arg: "-n", expected: true},
+		{arg: "-nkube-system"},
+		{arg: "--insecure-skip-tls-verify"},
+		{arg: "-v", expected: true},
+		{arg: "--v=5"},
+		{arg: "--output"},
+	}
+	flagSet := kubeConfigFlagSet()
+	for _, test := range tests {
+		if actual := TakesValue(test.arg, flagSet); test.expected != actual {
+			t.Errorf("TakesValue(%s): expected (%t), got (%t)", test.arg, test.expected, actual)
+		}
+	}
+}
+
+func TestClientOnly(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		expected    bool
+	}{
+		{commandLine: "kubectl version --client", expected: true},
+		{commandLine: "kubectl version --client=true -o json", expected: true},
+		{commandLine: "kubectl version --client=false"},
+		{commandLine: "kubectl version"},
+		{commandLine: "kubectl apply -f app.yaml --dry-run=client -o yaml", expected: true},
+		{commandLine: "kubectl create deploy app --image=nginx --dry-run", expected: true},
+		{commandLine: "kubectl run app --image=nginx --dry-run=true", expected: true},
+		{commandLine: "kubectl apply -f app.yaml --dry-run=server"},
+		{commandLine: "kubectl apply -f app.yaml --dry-run=none"},
+		{commandLine: "kubectl get pods --client"},
+		{commandLine: "kubectl exec pod -- kubectl version --client"},
+		{commandLine: "kubectl my-plugin --dry-run=client"},
+	}
+	for _, test := range tests {
+		cl := Parse(splitCommandLine(test.commandLine), kubeConfigFlagSet())
+		if actual := cl.ClientOnly(); test.expected != actual {
+			t.Errorf("ClientOnly(%s): expected (%t), got (%t)", test.commandLine, test.expected, actual)
+		}
+	}
+}
+
+// splitCommandLine splits the command line on spaces, except within single
+// quotes.
+func splitCommandLine(commandLine string) []string {
+	args := []string{}
+	quoted := false
+	current := ""
+	for _, r := range commandLine {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			current += string(r)
+		case r == ' ' && !quoted:
+			args = append(args, current)
+			current = ""
+		default:
+			current += string(r)
+		}
+	}
+	return append(args, current)
+}
@@ -0,0 +1,390 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// Environment variables which configure the dispatcher.
+const (
+	StoreDirEnv  = "KUBECTL_DISPATCHER_STORE"
+	CacheDirEnv  = "KUBECTL_DISPATCHER_CACHE_DIR"
+	OCILayoutEnv = "KUBECTL_DISPATCHER_OCI_LAYOUT"
+	// Version of the kubectl binary to fall back to, instead of the
+	// dispatcher's client version (e.g. "1.12").
+	DefaultVersionEnv = "KUBECTL_DISPATCHER_DEFAULT_VERSION"
+	// How to treat kubectl binaries in insecure locations: one of the
+	// secure modes below.
+	SecureModeEnv = "KUBECTL_DISPATCHER_SECURE_MODE"
+	// How to run the versioned kubectl binary: one of the exec modes below.
+	ExecModeEnv = "KUBECTL_DISPATCHER_EXEC_MODE"
+	// If true, fail instead of falling back to another kubectl version
+	// when the binary matching the server version is missing.
+	StrictEnv = "KUBECTL_DISPATCHER_STRICT"
+	// Server version to dispatch to, instead of asking the server
+	// (e.g. "1.13").
+	ServerVersionEnv = "KUBECTL_DISPATCHER_SERVER_VERSION"
+	// Layout templates of directories holding kubectl binaries, separated
+	// as in PATH (see Config.Layouts).
+	LayoutsEnv = "KUBECTL_DISPATCHER_LAYOUTS"
+	// Comma separated names of the version resolvers to consult, in order.
+	VersionResolversEnv = "KUBECTL_DISPATCHER_VERSION_RESOLVERS"
+	// How many times the server version is probed, to detect control
+	// planes of mixed versions (see Config.ProbeSamples).
+	ProbeSamplesEnv = "KUBECTL_DISPATCHER_PROBE_SAMPLES"
+	// Path of a YAML file holding the configuration. Environment
+	// variables override the settings in the file.
+	ConfigFileEnv = "KUBECTL_DISPATCHER_CONFIG"
+)
+
+// Secure modes, for kubectl binaries (or directories above them) which
+// someone other than the current user or root could write.
+const (
+	// SecureModeEnforce refuses to execute the binary.
+	SecureModeEnforce = "enforce"
+	// SecureModeWarn logs a warning, then executes the binary.
+	SecureModeWarn = "warn"
+	// SecureModeOff skips the check.
+	SecureModeOff = "off"
+)
+
+// Exec modes, for running the versioned kubectl binary.
+const (
+	// ExecModeExec replaces the dispatcher process with kubectl.
+	ExecModeExec = "exec"
+	// ExecModeChild runs kubectl as a child process of the dispatcher.
+	ExecModeChild = "child"
+)
+
+// Version resolvers, which each know one way to find the server version.
+const (
+	// ResolverEnv reads the version from the ServerVersionEnv variable.
+	ResolverEnv = "env"
+	// ResolverFile reads the version from a VersionFile in the current
+	// directory or one of its parents.
+	ResolverFile = "file"
+	// ResolverKubeconfig reads the version pinned in the kubeconfig cluster.
+	ResolverKubeconfig = "kubeconfig"
+	// ResolverRecommended reads the version (or range of versions) the
+	// cluster recommends, in a ConfigMap. It is not consulted by default.
+	ResolverRecommended = "recommended"
+	// ResolverCache reads the version last probed from the cluster.
+	ResolverCache = "cache"
+	// ResolverProbe asks the server for its version.
+	ResolverProbe = "probe"
+	// ResolverInfer infers the version from the server's OpenAPI documents
+	// or discovery, if the server refuses or hides its version.
+	ResolverInfer = "infer"
+	// ResolverInCluster asks the server for its version using the pod's
+	// service account.
+	ResolverInCluster = "in-cluster"
+)
+
+// MaxProbeSamples is the most times the server version may be probed.
+const MaxProbeSamples = 10
+
+// VersionFile is the name of the file read by the ResolverFile resolver.
+const VersionFile = ".kubectl-version"
+
+// DefaultVersionResolvers is the order in which the version resolvers are
+// consulted, unless configured otherwise.
+var DefaultVersionResolvers = []string{
+	ResolverEnv,
+	ResolverFile,
+	ResolverKubeconfig,
+	ResolverCache,
+	ResolverProbe,
+	ResolverInfer,
+	ResolverInCluster,
+}
+
+// VersionResolvers are the names of all the version resolvers.
+var VersionResolvers = append([]string{ResolverRecommended}, DefaultVersionResolvers...)
+
+// DefaultHomeDir is the directory for the dispatcher's own state.
+var DefaultHomeDir = filepath.Join(homedir.HomeDir(), ".kube", "kubectl-dispatcher")
+
+// Config holds the dispatcher settings which are not passed on the
+// kubectl command line.
+type Config struct {
+	// StoreDir is the managed store of versioned kubectl binaries which
+	// the dispatcher populates itself (e.g. extracted from OCI images).
+	StoreDir string `json:"storeDir,omitempty"`
+	// CacheDir holds data the dispatcher can recompute, such as the
+	// versions reported by inventoried binaries.
+	CacheDir string `json:"cacheDir,omitempty"`
+	// OCILayout is an optional OCI image layout directory holding kubectl
+	// images tagged by version.
+	OCILayout string `json:"ociLayout,omitempty"`
+	// DefaultVersion is the kubectl version to fall back to when the
+	// server version binary cannot be run. Empty means the client version.
+	DefaultVersion string `json:"defaultVersion,omitempty"`
+	// SecureMode is one of SecureModeEnforce, SecureModeWarn (the default)
+	// or SecureModeOff.
+	SecureMode string `json:"secureMode,omitempty"`
+	// ExecMode is either ExecModeExec (the default) or ExecModeChild.
+	ExecMode string `json:"execMode,omitempty"`
+	// Strict requires the kubectl binary matching the server version,
+	// instead of falling back to another version.
+	Strict bool `json:"strict,omitempty"`
+	// VersionResolvers names the resolvers consulted for the server
+	// version, in order. The first to answer wins.
+	VersionResolvers []string `json:"versionResolvers,omitempty"`
+	// ProbeSamples is how many times the server version is probed, each
+	// time on a new connection, so that the API servers of a control plane
+	// being upgraded may disagree. The lowest version is used. The default
+	// is 1.
+	ProbeSamples int `json:"probeSamples,omitempty"`
+	// Layouts are templates of the paths of versioned kubectl binaries,
+	// searched after the search paths, such as
+	// "/opt/kubernetes/{{.Version}}/bin/kubectl{{.Ext}}" (see
+	// locator.LayoutData for the fields).
+	Layouts []string `json:"layouts,omitempty"`
+}
+
+// NewConfig returns the default configuration, overridden by the config
+// file named in the passed environment (as returned by os.Environ()), and
+// then by the environment itself. A bad config file is ignored.
+func NewConfig(env []string) *Config {
+	c, err := Load(env)
+	if err != nil {
+		klog.Warningf("Ignoring dispatcher config file: %v", err)
+	}
+	return c
+}
+
+// Load returns the configuration as NewConfig does, and an error if the
+// config file can not be read, or holds unknown or invalid settings. The
+// configuration is usable either way: a bad file is ignored. Callers which
+// read the configuration often should load it once, as this reads the file.
+func Load(env []string) (*Config, error) {
+	c := defaultConfig()
+	var err error
+	if path, ok := LookupEnv(env, ConfigFileEnv); ok && path != "" {
+		err = c.loadFile(path)
+	}
+	c.applyEnv(env)
+	return c, err
+}
+
+// applyEnv overrides the configuration with the valid settings in the
+// environment.
+func (c *Config) applyEnv(env []string) {
+	if value, ok := LookupEnv(env, StoreDirEnv); ok && value != "" {
+		c.StoreDir = value
+	}
+	if value, ok := LookupEnv(env, CacheDirEnv); ok && value != "" {
+		c.CacheDir = value
+	}
+	if value, ok := LookupEnv(env, OCILayoutEnv); ok {
+		c.OCILayout = value
+	}
+	if value, ok := LookupEnv(env, DefaultVersionEnv); ok {
+		c.DefaultVersion = value
+	}
+	if value, ok := LookupEnv(env, SecureModeEnv); ok {
+		if err := validateSecureMode(value); err != nil {
+			klog.Warningf("Ignoring %s: %v", SecureModeEnv, err)
+		} else {
+			c.SecureMode = value
+		}
+	}
+	if value, ok := LookupEnv(env, ExecModeEnv); ok {
+		if err := validateExecMode(value); err != nil {
+			klog.Warningf("Ignoring %s: %v", ExecModeEnv, err)
+		} else {
+			c.ExecMode = value
+		}
+	}
+	if value, ok := LookupEnv(env, StrictEnv); ok && value != "" {
+		if strict, err := strconv.ParseBool(value); err != nil {
+			klog.Warningf("Ignoring %s: %v", StrictEnv, err)
+		} else {
+			c.Strict = strict
+		}
+	}
+	if value, ok := LookupEnv(env, LayoutsEnv); ok {
+		layouts := filepath.SplitList(value)
+		if err := validateLayouts(layouts); err != nil {
+			klog.Warningf("Ignoring %s: %v", LayoutsEnv, err)
+		} else {
+			c.Layouts = layouts
+		}
+	}
+	if value, ok := LookupEnv(env, ProbeSamplesEnv); ok && value != "" {
+		if samples, err := strconv.Atoi(value); err != nil {
+			klog.Warningf("Ignoring %s: %v", ProbeSamplesEnv, err)
+		} else if err := validateProbeSamples(samples); err != nil {
+			klog.Warningf("Ignoring %s: %v", ProbeSamplesEnv, err)
+		} else {
+			c.ProbeSamples = samples
+		}
+	}
+	if value, ok := LookupEnv(env, VersionResolversEnv); ok && value != "" {
+		resolvers := splitList(value)
+		if err := validateVersionResolvers(resolvers); err != nil {
+			klog.Warningf("Ignoring %s: %v", VersionResolversEnv, err)
+		} else {
+			c.VersionResolvers = resolvers
+		}
+	}
+}
+
+// LoadFile returns the default configuration, overridden by the YAML config
+// file. Unlike NewConfig, which ignores a bad config file, returns an error
+// if the file can not be read, or holds unknown or invalid settings.
+func LoadFile(path string) (*Config, error) {
+	c := defaultConfig()
+	if err := c.loadFile(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		StoreDir:   filepath.Join(DefaultHomeDir, "store"),
+		CacheDir:   filepath.Join(DefaultHomeDir, "cache"),
+		SecureMode: SecureModeWarn,
+		ExecMode:   ExecModeExec,
+		// Copied, so that callers can not change the defaults.
+		VersionResolvers: append([]string{}, DefaultVersionResolvers...),
+		ProbeSamples:     1,
+	}
+}
+
+// loadFile overrides the configuration with the settings in the YAML file.
+// The configuration is unchanged if there is an error.
+func (c *Config) loadFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file := *c
+	if err := yaml.UnmarshalStrict(contents, &file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateSecureMode(file.SecureMode); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateExecMode(file.ExecMode); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateVersionResolvers(file.VersionResolvers); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateLayouts(file.Layouts); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateProbeSamples(file.ProbeSamples); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	*c = file
+	return nil
+}
+
+func validateSecureMode(mode string) error {
+	switch mode {
+	case SecureModeEnforce, SecureModeWarn, SecureModeOff:
+		return nil
+	}
+	return fmt.Errorf("unknown secure mode %q (expected %s, %s or %s)", mode, SecureModeEnforce, SecureModeWarn, SecureModeOff)
+}
+
+func validateExecMode(mode string) error {
+	switch mode {
+	case ExecModeExec, ExecModeChild:
+		return nil
+	}
+	return fmt.Errorf("unknown exec mode %q (expected %s or %s)", mode, ExecModeExec, ExecModeChild)
+}
+
+func validateVersionResolvers(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no version resolvers (expected some of %s)", strings.Join(VersionResolvers, ", "))
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		known := false
+		for _, resolver := range VersionResolvers {
+			if name == resolver {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown version resolver %q (expected one of %s)", name, strings.Join(VersionResolvers, ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("version resolver %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func validateLayouts(layouts []string) error {
+	for _, layout := range layouts {
+		if layout == "" {
+			return fmt.Errorf("empty layout")
+		}
+		if _, err := template.New("layout").Parse(layout); err != nil {
+			return fmt.Errorf("invalid layout %q: %v", layout, err)
+		}
+	}
+	return nil
+}
+
+func validateProbeSamples(samples int) error {
+	if samples < 1 || samples > MaxProbeSamples {
+		return fmt.Errorf("probe samples %d out of range (expected 1 to %d)", samples, MaxProbeSamples)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping blank entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// LookupEnv returns the value of the environment variable "key" within
+// "env". As with the process environment, the last definition wins.
+func LookupEnv(env []string, key string) (string, bool) {
+	prefix := key + "="
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], prefix) {
+			return strings.TrimPrefix(env[i], prefix), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupEnv(t *testing.T) {
+	tests := []struct {
+		env      []string
+		key      string
+		value    string
+		expectOk bool
+	}{
+		{env: []string{}, key: "FOO", value: "", expectOk: false},
+		{env: []string{"FOO=bar"}, key: "FOO", value: "bar", expectOk: true},
+		{env: []string{"FOO="}, key: "FOO", value: "", expectOk: true},
+		{env: []string{"FOOBAR=baz"}, key: "FOO", value: "", expectOk: false},
+		{env: []string{"FOO=bar", "FOO=baz"}, key: "FOO", value: "baz", expectOk: true},
+		{env: []string{"FOO=a=b"}, key: "FOO", value: "a=b", expectOk: true},
+	}
+	for _, test := range tests {
+		value, ok := LookupEnv(test.env, test.key)
+		if test.value != value || test.expectOk != ok {
+			t.Errorf("LookupEnv(%v, %s): expected (%q, %t), got (%q, %t)", test.env, test.key, test.value, test.expectOk, value, ok)
+		}
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	c := NewConfig([]string{})
+	if expected := filepath.Join(DefaultHomeDir, "store"); c.StoreDir != expected {
+		t.Errorf("Default store dir: expected (%s), got (%s)", expected, c.StoreDir)
+	}
+	if expected := filepath.Join(DefaultHomeDir, "cache"); c.CacheDir != expected {
+		t.Errorf("Default cache dir: expected (%s), got (%s)", expected, c.CacheDir)
+	}
+	if c.OCILayout != "" {
+		t.Errorf("Default OCI layout: expected empty, got (%s)", c.OCILayout)
+	}
+	if c.SecureMode != SecureModeWarn {
+		t.Errorf("Default secure mode: expected (%s), got (%s)", SecureModeWarn, c.SecureMode)
+	}
+	if c.ExecMode != ExecModeExec {
+		t.Errorf("Default exec mode: expected (%s), got (%s)", ExecModeExec, c.ExecMode)
+	}
+	if c.DefaultVersion != "" {
+		t.Errorf("Default version: expected empty, got (%s)", c.DefaultVersion)
+	}
+	c = NewConfig([]string{StoreDirEnv + "=/tmp/store", CacheDirEnv + "=/tmp/cache", OCILayoutEnv + "=/mirror/kubectl", DefaultVersionEnv + "=1.12", SecureModeEnv + "=enforce", ExecModeEnv + "=child"})
+	if c.StoreDir != "/tmp/store" {
+		t.Errorf("Store dir: expected (/tmp/store), got (%s)", c.StoreDir)
+	}
+	if c.CacheDir != "/tmp/cache" {
+		t.Errorf("Cache dir: expected (/tmp/cache), got (%s)", c.CacheDir)
+	}
+	if c.OCILayout != "/mirror/kubectl" {
+		t.Errorf("OCI layout: expected (/mirror/kubectl), got (%s)", c.OCILayout)
+	}
+	if c.DefaultVersion != "1.12" {
+		t.Errorf("Default version: expected (1.12), got (%s)", c.DefaultVersion)
+	}
+	if c.SecureMode != SecureModeEnforce {
+		t.Errorf("Secure mode: expected (%s), got (%s)", SecureModeEnforce, c.SecureMode)
+	}
+	if c.ExecMode != ExecModeChild {
+		t.Errorf("Exec mode: expected (%s), got (%s)", ExecModeChild, c.ExecMode)
+	}
+	if c = NewConfig([]string{SecureModeEnv + "=yes"}); c.SecureMode != SecureModeWarn {
+		t.Errorf("Unknown secure mode: expected (%s), got (%s)", SecureModeWarn, c.SecureMode)
+	}
+	if c.Strict {
+		t.Errorf("Default strict: expected (false), got (true)")
+	}
+	if c = NewConfig([]string{StrictEnv + "=true"}); !c.Strict {
+		t.Errorf("Strict: expected (true), got (false)")
+	}
+	if c = NewConfig([]string{StrictEnv + "=sometimes"}); c.Strict {
+		t.Errorf("Bad strict value: expected (false), got (true)")
+	}
+}
+
+func TestNewConfigVersionResolvers(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{value: "", expected: DefaultVersionResolvers},
+		{value: "probe", expected: []string{ResolverProbe}},
+		{value: " cache, probe ,", expected: []string{ResolverCache, ResolverProbe}},
+		{value: "recommended,cache,probe", expected: []string{ResolverRecommended, ResolverCache, ResolverProbe}},
+		// Invalid lists are ignored.
+		{value: "probe,dns", expected: DefaultVersionResolvers},
+		{value: "probe,probe", expected: DefaultVersionResolvers},
+		{value: ",", expected: DefaultVersionResolvers},
+	}
+	for _, test := range tests {
+		c := NewConfig([]string{VersionResolversEnv + "=" + test.value})
+		if !isStringSliceEqual(test.expected, c.VersionResolvers) {
+			t.Errorf("NewConfig(%s=%q) resolvers: expected (%v), got (%v)", VersionResolversEnv, test.value, test.expected, c.VersionResolvers)
+		}
+	}
+}
+
+func TestNewConfigProbeSamples(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{value: "", expected: 1},
+		{value: "3", expected: 3},
+		// Invalid values are ignored.
+		{value: "0", expected: 1},
+		{value: "100", expected: 1},
+		{value: "many", expected: 1},
+	}
+	for _, test := range tests {
+		c := NewConfig([]string{ProbeSamplesEnv + "=" + test.value})
+		if c.ProbeSamples != test.expected {
+			t.Errorf("NewConfig(%s=%q) probe samples: expected (%d), got (%d)", ProbeSamplesEnv, test.value, test.expected, c.ProbeSamples)
+		}
+	}
+}
+
+func TestNewConfigLayouts(t *testing.T) {
+	layouts := "/opt/kubernetes/{{.Version}}/kubectl" + string(filepath.ListSeparator) + "/usr/lib/kubectl-{{.Major}}.{{.Minor}}"
+	c := NewConfig([]string{LayoutsEnv + "=" + layouts})
+	if expected := filepath.SplitList(layouts); !isStringSliceEqual(expected, c.Layouts) {
+		t.Errorf("Layouts: expected (%v), got (%v)", expected, c.Layouts)
+	}
+	if c = NewConfig([]string{LayoutsEnv + "=/opt/kubernetes/{{.Version}/kubectl"}); len(c.Layouts) != 0 {
+		t.Errorf("Bad layout: expected none, got (%v)", c.Layouts)
+	}
+}
+
+func isStringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"valid.yaml":     "storeDir: /mirror/store\nexecMode: child\nsecureMode: enforce\nstrict: true\nversionResolvers: [cache, probe]\n",
+		"unknown.yaml":   "storeDir: /mirror/store\nstore: /mirror/store\n",
+		"invalid.yaml":   "execMode: fork\n",
+		"garbage.yaml":   "- storeDir\n",
+		"resolver.yaml":  "versionResolvers: [cache, dns]\n",
+		"resolvers.yaml": "versionResolvers: []\n",
+		"layout.yaml":    "layouts: [\"/opt/{{.Version\"]\n",
+		"samples.yaml":   "probeSamples: 0\n",
+	}
+	for name, contents := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+	}
+	tests := []struct {
+		name        string
+		expectError bool
+	}{
+		{name: "valid.yaml"},
+		{name: "unknown.yaml", expectError: true},
+		{name: "invalid.yaml", expectError: true},
+		{name: "garbage.yaml", expectError: true},
+		{name: "resolver.yaml", expectError: true},
+		{name: "resolvers.yaml", expectError: true},
+		{name: "layout.yaml", expectError: true},
+		{name: "samples.yaml", expectError: true},
+		{name: "missing.yaml", expectError: true},
+	}
+	for _, test := range tests {
+		_, err := LoadFile(filepath.Join(dir, test.name))
+		if test.expectError != (err != nil) {
+			t.Errorf("LoadFile(%s) error: expected error (%t), got (%v)", test.name, test.expectError, err)
+		}
+	}
+
+	c, _ := LoadFile(filepath.Join(dir, "valid.yaml"))
+	if c.StoreDir != "/mirror/store" || c.ExecMode != ExecModeChild || c.SecureMode != SecureModeEnforce || !c.Strict {
+		t.Errorf("LoadFile settings error: got (%+v)", c)
+	}
+	if expected := []string{ResolverCache, ResolverProbe}; !isStringSliceEqual(expected, c.VersionResolvers) {
+		t.Errorf("LoadFile version resolvers: expected (%v), got (%v)", expected, c.VersionResolvers)
+	}
+	if expected := filepath.Join(DefaultHomeDir, "cache"); c.CacheDir != expected {
+		t.Errorf("LoadFile default cache dir: expected (%s), got (%s)", expected, c.CacheDir)
+	}
+	// The environment overrides the config file.
+	c = NewConfig([]string{ConfigFileEnv + "=" + filepath.Join(dir, "valid.yaml"), ExecModeEnv + "=exec"})
+	if c.StoreDir != "/mirror/store" || c.ExecMode != ExecModeExec {
+		t.Errorf("NewConfig with config file error: got (%+v)", c)
+	}
+	// NewConfig ignores a bad config file.
+	c = NewConfig([]string{ConfigFileEnv + "=" + filepath.Join(dir, "unknown.yaml")})
+	if expected := filepath.Join(DefaultHomeDir, "store"); c.StoreDir != expected {
+		t.Errorf("NewConfig with bad config file: expected store dir (%s), got (%s)", expected, c.StoreDir)
+	}
+	// Load reports a bad config file, with a usable configuration.
+	c, err = Load([]string{ConfigFileEnv + "=" + filepath.Join(dir, "unknown.yaml"), ExecModeEnv + "=child"})
+	if err == nil || c == nil || c.ExecMode != ExecModeChild {
+		t.Errorf("Load with bad config file: expected error and environment settings, got (%+v, %v)", c, err)
+	}
+	if c, err = Load([]string{ConfigFileEnv + "=" + filepath.Join(dir, "valid.yaml")}); err != nil || c.StoreDir != "/mirror/store" {
+		t.Errorf("Load with config file error: got (%+v, %v)", c, err)
+	}
+}
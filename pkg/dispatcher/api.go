@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/resolver"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+)
+
+// FileSystem is the file system versioned and PATH kubectl binaries are
+// looked up in. Stat returns an error for a file which is missing, or can
+// not be dispatched to.
+type FileSystem interface {
+	Stat(path string) (os.FileInfo, error)
+}
+
+// Option configures a Dispatcher created by New.
+type Option func(*Dispatcher)
+
+// WithArgs sets the kubectl command line (as in os.Args), which is passed
+// to the kubectl binary, and read for kube config flags such as --context.
+// The default is "kubectl" with no arguments.
+func WithArgs(args []string) Option {
+	return func(d *Dispatcher) {
+		d.args = args
+	}
+}
+
+// WithEnv sets the environment (as in os.Environ()), which is passed to
+// the kubectl binary, and holds the dispatcher settings (see
+// config.NewConfig). The default is the environment of the process.
+func WithEnv(env []string) Option {
+	return func(d *Dispatcher) {
+		d.env = env
+	}
+}
+
+// WithConfig sets the dispatcher configuration, instead of reading it from
+// the environment (see config.NewConfig) on first use.
+func WithConfig(cfg *config.Config) Option {
+	return func(d *Dispatcher) {
+		d.cfg = cfg
+	}
+}
+
+// WithClientVersion sets the default kubectl version, used when the server
+// version is unknown (unless overridden by the configuration).
+func WithClientVersion(clientVersion version.Info) Option {
+	return func(d *Dispatcher) {
+		d.clientVersion = clientVersion
+	}
+}
+
+// WithKubeConfigFlags sets the kube config flags used to query the server
+// version, instead of the flags on the command line.
+func WithKubeConfigFlags(kubeConfigFlags *genericclioptions.ConfigFlags) Option {
+	return func(d *Dispatcher) {
+		d.kubeConfigFlags = kubeConfigFlags
+	}
+}
+
+// WithSearchPaths sets the directories holding versioned kubectl binaries
+// (e.g. "kubectl.1.12"), in order of precedence. The default is the
+// directory of the running executable.
+func WithSearchPaths(dirs ...string) Option {
+	return func(d *Dispatcher) {
+		d.searchPaths = dirs
+	}
+}
+
+// WithVersionSource sets the source of the server version, instead of
+// consulting the version resolvers.
+func WithVersionSource(source discovery.ServerVersionInterface) Option {
+	return func(d *Dispatcher) {
+		d.versionFunc = func(uint64) (*version.Info, error) {
+			return source.ServerVersion()
+		}
+	}
+}
+
+// WithVersionResolvers sets the resolvers consulted in order for the
+// server version, instead of those named by the configuration (see
+// config.DefaultVersionResolvers). Tests can pass fake resolvers.
+func WithVersionResolvers(resolvers ...resolver.VersionResolver) Option {
+	return func(d *Dispatcher) {
+		d.resolvers = append(resolver.Chain{}, resolvers...)
+		d.versionFunc = d.resolveServerVersion
+	}
+}
+
+// WithBinaryLocators sets the locators of versioned kubectl binaries, in
+// order of precedence, instead of the search paths, the configured layouts
+// and the managed store.
+func WithBinaryLocators(locators ...locator.BinaryLocator) Option {
+	return func(d *Dispatcher) {
+		d.locators = append([]locator.BinaryLocator{}, locators...)
+	}
+}
+
+// WithClock sets the function returning the current time.
+func WithClock(now func() time.Time) Option {
+	return func(d *Dispatcher) {
+		d.now = now
+	}
+}
+
+// WithFileSystem sets the file system binaries are looked up in. The
+// default is the host file system, where versioned binaries must be
+// executable, and built for this host.
+func WithFileSystem(fs FileSystem) Option {
+	return func(d *Dispatcher) {
+		d.fs = fs
+	}
+}
+
+// New returns a Dispatcher configured by the options. Its Decide method
+// returns the kubectl binary to run, leaving the caller to run it, while
+// Dispatch runs it, as the kubectl dispatcher does.
+func New(options ...Option) *Dispatcher {
+	d := newDispatcher([]string{"kubectl"}, os.Environ(), version.Info{})
+	for _, option := range options {
+		option(d)
+	}
+	stat := filepath.StatExecutable
+	if d.fs != nil {
+		stat, d.stat = d.fs.Stat, d.fs.Stat
+	}
+	for _, dir := range d.searchPaths {
+		d.filepathBuilders = append(d.filepathBuilders, filepath.NewFilepathBuilder(&filepath.FixedDirGetter{Dir: dir}, stat))
+	}
+	if len(d.filepathBuilders) == 0 {
+		d.filepathBuilders = []*filepath.FilepathBuilder{filepath.NewFilepathBuilder(&filepath.ExeDirGetter{}, stat)}
+	}
+	d.initExecFunc()
+	return d
+}
+
+// Resolve returns the kubectl binary a Dispatcher configured by the options
+// would run, with its version, and why it was chosen; or an error if there
+// is none (see Dispatcher.Decide). The binary is not run.
+func Resolve(options ...Option) (*Decision, error) {
+	return New(options...).Decide()
+}
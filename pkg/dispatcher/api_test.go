@@ -0,0 +1,305 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// fakeFileInfo is the FileInfo of an executable in a fakeFileSystem.
+type fakeFileInfo struct {
+	name string
+}
+
+func (f *fakeFileInfo) Name() string       { return f.name }
+func (f *fakeFileInfo) Size() int64        { return 0 }
+func (f *fakeFileInfo) Mode() os.FileMode  { return 0755 }
+func (f *fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (f *fakeFileInfo) IsDir() bool        { return false }
+func (f *fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeFileSystem holds executables at the paths set to true.
+type fakeFileSystem map[string]bool
+
+func (fs fakeFileSystem) Stat(path string) (os.FileInfo, error) {
+	if !fs[path] {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return &fakeFileInfo{name: path}, nil
+}
+
+// fakeVersionSource returns a fixed server version, or error.
+type fakeVersionSource struct {
+	serverVersion *version.Info
+	err           error
+}
+
+func (f *fakeVersionSource) ServerVersion() (*version.Info, error) {
+	return f.serverVersion, f.err
+}
+
+func TestResolve(t *testing.T) {
+	fs := fakeFileSystem{
+		"/opt/kubectl/kubectl.1.13":     true,
+		"/usr/lib/kubectl/kubectl.1.12": true,
+		"/usr/lib/kubectl/kubectl.1.13": true,
+		"/usr/bin/kubectl":              true,
+	}
+	env := []string{"PATH=/usr/bin", config.StoreDirEnv + "=/nonexistent/store"}
+	v113 := &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}
+
+	tests := []struct {
+		source         *fakeVersionSource
+		env            []string
+		expectedPath   string
+		expectedReason string
+		expectedSource string
+		expectedError  func(error) bool
+	}{
+		// The first search path takes precedence.
+		{
+			source:         &fakeVersionSource{serverVersion: v113},
+			expectedPath:   "/opt/kubectl/kubectl.1.13",
+			expectedReason: ReasonExactMatch,
+			expectedSource: SourceDirectory,
+		},
+		{
+			source:         &fakeVersionSource{serverVersion: &version.Info{Major: "1", Minor: "11", GitVersion: "v1.11.2"}},
+			expectedPath:   "/usr/lib/kubectl/kubectl.1.12",
+			expectedReason: ReasonNearestInSkew,
+			expectedSource: SourceDirectory,
+		},
+		// The default 1.11 is missing.
+		{
+			source:         &fakeVersionSource{err: fmt.Errorf("connection refused")},
+			expectedPath:   "/usr/bin/kubectl",
+			expectedReason: ReasonPath,
+			expectedSource: SourcePath,
+		},
+		{
+			source:        &fakeVersionSource{serverVersion: &version.Info{Major: "1", Minor: "15", GitVersion: "v1.15.0"}},
+			env:           []string{config.StrictEnv + "=true"},
+			expectedError: IsMissingBinary,
+		},
+	}
+	for _, test := range tests {
+		decision, err := Resolve(
+			WithArgs([]string{"kubectl", "get", "pods"}),
+			WithEnv(append(env, test.env...)),
+			WithClientVersion(clientVersion),
+			WithSearchPaths("/opt/kubectl", "/usr/lib/kubectl"),
+			WithVersionSource(test.source),
+			WithFileSystem(fs),
+		)
+		if test.expectedError != nil {
+			if !test.expectedError(err) {
+				t.Errorf("Resolve error: got (%v)", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error in Resolve(): %v", err)
+			continue
+		}
+		if test.expectedPath != decision.Path || test.expectedReason != decision.Candidate.Reason || test.expectedSource != decision.Source {
+			t.Errorf("Resolve error: expected (%s, %s, %s), got (%s)", test.expectedPath, test.expectedReason, test.expectedSource, decision)
+		}
+		if (test.source.err == nil) != (decision.ServerVersion != nil) {
+			t.Errorf("Resolve server version error: got (%v, %v)", decision.ServerVersion, decision.ServerVersionErr)
+		}
+	}
+}
+
+// fakeLocator returns a fixed binary for each version.
+type fakeLocator map[string]locator.Binary
+
+func (l fakeLocator) Locate(v version.Info) ([]locator.Binary, error) {
+	majorMinor, _ := util.MajorMinor(v)
+	if b, ok := l[majorMinor]; ok {
+		return []locator.Binary{b}, nil
+	}
+	return nil, fmt.Errorf("no kubectl %s", majorMinor)
+}
+
+func TestResolveWithBinaryLocators(t *testing.T) {
+	mirror := fakeLocator{"1.12": {Version: "1.12", Path: "/mirror/1.12/kubectl", Digest: "abc", Source: "mirror"}}
+	// The search paths are ignored.
+	fs := fakeFileSystem{"/opt/kubectl/kubectl.1.13": true}
+	decision, err := Resolve(
+		WithEnv([]string{"PATH=", config.StoreDirEnv + "=/nonexistent/store"}),
+		WithSearchPaths("/opt/kubectl"),
+		WithFileSystem(fs),
+		WithVersionSource(&fakeVersionSource{serverVersion: &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}}),
+		WithBinaryLocators(mirror),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error in Resolve(): %v", err)
+	}
+	if decision.Path != "/mirror/1.12/kubectl" || decision.Digest != "abc" || decision.Source != "mirror" || decision.Candidate.Reason != ReasonNearestInSkew {
+		t.Errorf("Resolve with binary locators: got (%s, sha256:%s)", decision, decision.Digest)
+	}
+}
+
+func TestBinaries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "binaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	files := []string{
+		gofilepath.Join(tmp, "bin", "kubectl.1.13"),
+		gofilepath.Join(tmp, "layout", "1.12", "kubectl"),
+	}
+	for _, f := range files {
+		os.MkdirAll(gofilepath.Dir(f), 0755)
+		ioutil.WriteFile(f, []byte("kubectl"), 0755)
+	}
+	stored, _, err := store.NewStore(gofilepath.Join(tmp, "store")).PutKeyed(version.Info{Major: "1", Minor: "12"}, store.Key("stored"), func(w io.Writer) error {
+		_, err := io.WriteString(w, "stored kubectl")
+		return err
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, stored)
+	// Only the index of the OCI image layout is read.
+	ociLayout := gofilepath.Join(tmp, "oci")
+	os.MkdirAll(ociLayout, 0755)
+	ioutil.WriteFile(gofilepath.Join(ociLayout, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	index := `{"schemaVersion": 2, "manifests": [
+		{"digest": "sha256:a", "annotations": {"org.opencontainers.image.ref.name": "v1.14.2"}},
+		{"digest": "sha256:b", "annotations": {"org.opencontainers.image.ref.name": "v1.11.0"}},
+		{"digest": "sha256:c", "annotations": {"org.opencontainers.image.ref.name": "v1.14.5"}},
+		{"digest": "sha256:d", "annotations": {"org.opencontainers.image.ref.name": "latest"}}
+	]}`
+	ioutil.WriteFile(gofilepath.Join(ociLayout, "index.json"), []byte(index), 0644)
+	env := []string{
+		"PATH=" + gofilepath.Join(tmp, "bin"),
+		config.StoreDirEnv + "=" + gofilepath.Join(tmp, "store"),
+		config.LayoutsEnv + "=" + gofilepath.Join(tmp, "layout", "{{.Version}}", "kubectl"),
+		config.OCILayoutEnv + "=" + ociLayout,
+	}
+	binaries, err := New(WithEnv(env), WithSearchPaths(gofilepath.Join(tmp, "bin"))).Binaries()
+	if err != nil {
+		t.Fatalf("Unexpected error in Binaries(): %v", err)
+	}
+	expected := []locator.Binary{
+		{Version: "1.13", Path: files[0], Source: SourceDirectory},
+		{Version: "1.12", Path: files[1], Source: SourceTemplate},
+		{Version: "1.12", Path: files[2], Source: SourceStore},
+		{Version: "1.11", Path: ociLayout + ":v1.11.0", Source: SourceOCILayout},
+		{Version: "1.14", Path: ociLayout + ":v1.14.5", Source: SourceOCILayout},
+	}
+	if len(binaries) != len(expected) {
+		t.Fatalf("Binaries: expected (%v), got (%v)", expected, binaries)
+	}
+	for i, b := range binaries {
+		if b.Version != expected[i].Version || b.Path != expected[i].Path || b.Source != expected[i].Source {
+			t.Errorf("Binaries (%d): expected (%s), got (%s)", i, expected[i], b)
+		}
+	}
+
+	env[len(env)-1] = config.OCILayoutEnv + "=" + gofilepath.Join(tmp, "missing")
+	if _, err := New(WithEnv(env), WithSearchPaths(gofilepath.Join(tmp, "bin"))).Binaries(); err == nil {
+		t.Errorf("Binaries with missing OCI layout: expected error, got none")
+	}
+}
+
+// The configuration is read once, and may be passed in.
+func TestNewWithConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configFile := gofilepath.Join(tmp, "config.yaml")
+	ioutil.WriteFile(configFile, []byte("execMode: child\nstrict: true\n"), 0644)
+	d := New(WithEnv([]string{config.ConfigFileEnv + "=" + configFile}))
+	os.Remove(configFile)
+	if cfg := d.configuration(); cfg.ExecMode != config.ExecModeChild || !cfg.Strict {
+		t.Errorf("Expected the config file read on creation, got (%+v)", cfg)
+	}
+	cfg := &config.Config{ExecMode: config.ExecModeChild, StoreDir: "/mirror/store"}
+	d = New(WithEnv([]string{config.ExecModeEnv + "=" + config.ExecModeExec}), WithConfig(cfg))
+	if d.configuration() != cfg || fmt.Sprintf("%p", d.execFunc) == fmt.Sprintf("%p", execFile) {
+		t.Errorf("Expected the passed configuration, got (%+v)", d.configuration())
+	}
+}
+
+func TestNewWithKubeConfigFlags(t *testing.T) {
+	kubeConfigFlags := genericclioptions.NewConfigFlags(true)
+	timeout := "soon"
+	kubeConfigFlags.Timeout = &timeout
+	// The flags replace those on the command line.
+	d := New(WithArgs([]string{"kubectl", "--request-timeout=5s", "get", "pods"}), WithKubeConfigFlags(kubeConfigFlags))
+	if _, err := d.serverVersion(cacheMaxAge); err == nil || !strings.Contains(err.Error(), "Invalid timeout value") {
+		t.Errorf("Expected invalid timeout error, got (%v)", err)
+	}
+}
+
+// Flags left unset by library callers are not read.
+func TestResolvePartialKubeConfigFlags(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "partial-flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	kubeConfig := gofilepath.Join(tmp, "config")
+	env := []string{
+		"PATH=",
+		config.StoreDirEnv + "=/nonexistent/store",
+		config.CacheDirEnv + "=" + gofilepath.Join(tmp, "cache"),
+	}
+	options := []Option{
+		WithEnv(env),
+		WithSearchPaths("/opt/kubectl"),
+		WithFileSystem(fakeFileSystem{"/opt/kubectl/kubectl.1.11": true}),
+		WithClientVersion(version.Info{Major: "1", Minor: "11"}),
+		WithKubeConfigFlags(&genericclioptions.ConfigFlags{KubeConfig: &kubeConfig}),
+	}
+	decision, err := Resolve(options...)
+	if err != nil {
+		t.Fatalf("Unexpected error in Resolve(): %v", err)
+	}
+	if decision.Candidate.Reason != ReasonDefault || decision.ServerVersionErr == nil {
+		t.Errorf("Resolve with partial flags: expected default for unknown server version, got (%s, %v)", decision, decision.ServerVersionErr)
+	}
+	if _, err := New(options...).Decide(); err != nil {
+		t.Errorf("Unexpected error in Decide(): %v", err)
+	}
+}
+
+func TestNewExecMode(t *testing.T) {
+	d := New(WithEnv([]string{config.ExecModeEnv + "=" + config.ExecModeChild}))
+	if d.execFunc == nil || fmt.Sprintf("%p", d.execFunc) == fmt.Sprintf("%p", execFile) {
+		t.Errorf("Expected child exec mode from the configured environment")
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/cmdline"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+// Reasons a kubectl binary is a dispatch candidate, in order of preference.
+const (
+	ReasonCommandSupport = "command support"
+	ReasonExactMatch     = "exact match"
+	ReasonNearestInSkew  = "nearest in skew"
+	ReasonDefault        = "default"
+	ReasonPath           = "PATH"
+)
+
+// Candidate is a kubectl binary the dispatcher may delegate to. Versioned
+// candidates are located when they are tried; PATH candidates have a path.
+type Candidate struct {
+	Reason  string
+	Version *version.Info
+	Path    string
+	// OutOfSkew is true if the server version is known, and the binary is
+	// not known to be within its supported skew (as for PATH binaries).
+	OutOfSkew bool
+}
+
+func (c Candidate) String() string {
+	if c.Version != nil {
+		majorMinor, _ := util.MajorMinor(*c.Version)
+		return fmt.Sprintf("%s (kubectl %s)", c.Reason, majorMinor)
+	}
+	return fmt.Sprintf("%s (%s)", c.Reason, c.Path)
+}
+
+// Attempt records why delegating to a candidate failed.
+type Attempt struct {
+	Candidate Candidate
+	Path      string
+	Err       error
+}
+
+// DispatchError is returned when no candidate could be executed. It
+// explains every attempt, in order.
+type DispatchError struct {
+	Attempts []Attempt
+}
+
+func (e *DispatchError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "no kubectl binary to dispatch to"
+	}
+	lines := []string{"unable to execute any kubectl binary:"}
+	for i, a := range e.Attempts {
+		line := fmt.Sprintf("  %d. %s", i+1, a.Candidate)
+		if a.Path != "" {
+			line += ": " + a.Path
+		}
+		lines = append(lines, fmt.Sprintf("%s: %v", line, a.Err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Candidates returns the ordered list of kubectl binaries to try for the
+// server version: the exact match, the versions within the supported skew
+// (newer first), the configured default version, and finally every kubectl
+// on the PATH which is not the dispatcher itself. If the server version is
+// unknown (nil), only the default and PATH candidates are returned. In
+// strict mode, only the exact match is returned for a known server version.
+// If the command (or one of its flags) is too new for the exact match, but
+// supported by the newer version within the skew, that version comes first.
+func (d *Dispatcher) Candidates(serverVersion *version.Info) []Candidate {
+	candidates := []Candidate{}
+	seen := map[string]bool{}
+	addVersion := func(reason string, v version.Info) {
+		majorMinor, err := util.MajorMinor(v)
+		if err != nil || seen[majorMinor] {
+			return
+		}
+		seen[majorMinor] = true
+		outOfSkew := serverVersion != nil && !inSkew(*serverVersion, v)
+		candidates = append(candidates, Candidate{Reason: reason, Version: &v, OutOfSkew: outOfSkew})
+	}
+	if serverVersion != nil {
+		strict := d.configuration().Strict
+		major, _ := util.GetMajorVersion(*serverVersion)
+		minor, _ := util.GetMinorVersion(*serverVersion)
+		if capability, ok := d.requiredCapability(); ok && !strict && major == 1 && capability.Minor == minor+1 {
+			klog.V(2).Infof("kubectl 1.%d does not support %s; dispatching to kubectl 1.%d", minor, capability, capability.Minor)
+			addVersion(ReasonCommandSupport, version.Info{Major: "1", Minor: strconv.Itoa(capability.Minor)})
+		}
+		addVersion(ReasonExactMatch, *serverVersion)
+		if strict {
+			return candidates
+		}
+		for _, skew := range []int{minor + 1, minor - 1} {
+			if skew > 0 {
+				addVersion(ReasonNearestInSkew, version.Info{Major: strconv.Itoa(major), Minor: strconv.Itoa(skew)})
+			}
+		}
+	}
+	addVersion(ReasonDefault, d.defaultVersion())
+	for _, path := range d.pathCandidates() {
+		candidates = append(candidates, Candidate{Reason: ReasonPath, Path: path, OutOfSkew: serverVersion != nil})
+	}
+	return candidates
+}
+
+// requiredCapability returns the newest kubectl capability the command line
+// uses (see cmdline.Capabilities), or false if it uses none.
+func (d *Dispatcher) requiredCapability() (cmdline.Capability, bool) {
+	_, _, commandLine, err := d.parseKubeConfigFlags()
+	if err != nil {
+		return cmdline.Capability{}, false
+	}
+	return commandLine.Requires(cmdline.Capabilities)
+}
+
+// inSkew returns true if kubectl version "v" supports the server version:
+// the same major version, and at most one minor version apart.
+func inSkew(serverVersion version.Info, v version.Info) bool {
+	serverMajor, err := util.GetMajorVersion(serverVersion)
+	if err != nil {
+		return false
+	}
+	serverMinor, err := util.GetMinorVersion(serverVersion)
+	if err != nil {
+		return false
+	}
+	major, err := util.GetMajorVersion(v)
+	if err != nil {
+		return false
+	}
+	minor, err := util.GetMinorVersion(v)
+	if err != nil {
+		return false
+	}
+	return major == serverMajor && minor >= serverMinor-1 && minor <= serverMinor+1
+}
+
+// defaultVersion returns the configured default kubectl version, which is
+// the client version unless overridden in the configuration.
+func (d *Dispatcher) defaultVersion() version.Info {
+	cfg := d.configuration()
+	if cfg.DefaultVersion == "" {
+		return d.GetClientVersion()
+	}
+	v, err := util.ParseVersion(cfg.DefaultVersion)
+	if err != nil {
+		klog.Warningf("Ignoring bad default version %q: %v", cfg.DefaultVersion, err)
+		return d.GetClientVersion()
+	}
+	return v
+}
+
+// pathCandidates returns every kubectl binary on the PATH of the dispatched
+// environment, except for the dispatcher itself.
+func (d *Dispatcher) pathCandidates() []string {
+	self, err := d.executable()
+	if err != nil {
+		klog.V(3).Infof("Unable to find dispatcher executable: %v", err)
+		return nil
+	}
+	// The dispatcher is on the host file system, even if binaries are
+	// looked up in another one.
+	selfInfo, err := os.Stat(self)
+	if err != nil {
+		klog.V(3).Infof("Unable to stat dispatcher executable: %v", err)
+		return nil
+	}
+	pathEnv, _ := config.LookupEnv(d.GetEnv(), "PATH")
+	binaries, _ := (&locator.PathLocator{Path: pathEnv, Stat: d.stat, Exclude: selfInfo}).Locate(version.Info{})
+	paths := []string{}
+	for _, b := range binaries {
+		paths = append(paths, b.Path)
+	}
+	return paths
+}
+
+// dispatchTo tries each candidate in order. Locating a versioned candidate
+// validates it, and every binary must be in a secure location, so only
+// usable binaries are executed. On success, this does
+// not return, since the current process is overwritten (see execve(2)).
+// Otherwise, returns a DispatchError explaining every attempt, with a
+// BinaryNotFoundError or ExecError for each.
+func (d *Dispatcher) dispatchTo(candidates []Candidate) error {
+	dispatchErr := &DispatchError{}
+	tried := map[string]bool{}
+	for _, c := range candidates {
+		b, err := d.locate(c)
+		path := b.Path
+		if err != nil {
+			dispatchErr.Attempts = append(dispatchErr.Attempts, Attempt{Candidate: c, Err: err})
+			continue
+		}
+		if tried[path] {
+			continue
+		}
+		tried[path] = true
+		klog.V(3).Infof("kubectl dispatching (%s): %s\n", c, path)
+		if c.OutOfSkew {
+			fmt.Fprintf(d.stderr, "kubectl dispatcher: warning: no kubectl within the supported skew of the server version; using %s\n", c)
+		}
+		err = d.execKubectl(path)
+		if _, ok := err.(*ExitError); ok || err == nil {
+			// Only returns after running kubectl as a child process.
+			return err
+		}
+		klog.V(3).Infof("Exec of %s failed: %v", path, err)
+		dispatchErr.Attempts = append(dispatchErr.Attempts, Attempt{Candidate: c, Path: path, Err: &ExecError{Path: path, Err: err}})
+	}
+	return dispatchErr
+}
+
+func kubectlName() string {
+	if runtime.GOOS == "windows" {
+		return "kubectl.exe"
+	}
+	return "kubectl"
+}
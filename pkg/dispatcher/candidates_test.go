@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// setupCandidates creates a dispatcher directory with the passed versioned
+// binaries, and two PATH directories each holding a "kubectl".
+func setupCandidates(t *testing.T, versions ...string) (tmp string, builder *filepath.FilepathBuilder, env []string) {
+	tmp, err := ioutil.TempDir("", "candidates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exeDir := gofilepath.Join(tmp, "bin")
+	pathDirs := []string{gofilepath.Join(tmp, "path1"), gofilepath.Join(tmp, "path2")}
+	for _, dir := range append([]string{exeDir}, pathDirs...) {
+		os.Mkdir(dir, 0755)
+	}
+	for _, v := range versions {
+		ioutil.WriteFile(gofilepath.Join(exeDir, "kubectl."+v), []byte("#!/bin/sh\n"), 0755)
+	}
+	for _, dir := range pathDirs {
+		ioutil.WriteFile(gofilepath.Join(dir, kubectlName()), []byte("#!/bin/sh\n"), 0755)
+	}
+	builder = filepath.NewFilepathBuilder(&filepath.FixedDirGetter{Dir: exeDir}, os.Stat)
+	env = []string{
+		config.StoreDirEnv + "=" + gofilepath.Join(tmp, "store"),
+		"PATH=" + strings.Join(append(pathDirs, pathDirs[0]), string(os.PathListSeparator)),
+	}
+	return tmp, builder, env
+}
+
+func candidateStrings(candidates []Candidate) []string {
+	actual := []string{}
+	for _, c := range candidates {
+		actual = append(actual, c.String())
+	}
+	return actual
+}
+
+func TestCandidates(t *testing.T) {
+	tmp, builder, env := setupCandidates(t)
+	defer os.RemoveAll(tmp)
+	path1 := "PATH (" + gofilepath.Join(tmp, "path1", kubectlName()) + ")"
+	path2 := "PATH (" + gofilepath.Join(tmp, "path2", kubectlName()) + ")"
+
+	tests := []struct {
+		serverVersion *version.Info
+		env           []string
+		expected      []string
+	}{
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "13"},
+			expected:      []string{"exact match (kubectl 1.13)", "nearest in skew (kubectl 1.14)", "nearest in skew (kubectl 1.12)", "default (kubectl 1.11)", path1, path2},
+		},
+		// The default version is not repeated.
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "12"},
+			expected:      []string{"exact match (kubectl 1.12)", "nearest in skew (kubectl 1.13)", "nearest in skew (kubectl 1.11)", path1, path2},
+		},
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "1"},
+			expected:      []string{"exact match (kubectl 1.1)", "nearest in skew (kubectl 1.2)", "default (kubectl 1.11)", path1, path2},
+		},
+		// Unknown server version starts with the default.
+		{
+			expected: []string{"default (kubectl 1.11)", path1, path2},
+		},
+		{
+			env:      []string{config.DefaultVersionEnv + "=v1.15.2"},
+			expected: []string{"default (kubectl 1.15)", path1, path2},
+		},
+		// A bad default version falls back to the client version.
+		{
+			env:      []string{config.DefaultVersionEnv + "=latest"},
+			expected: []string{"default (kubectl 1.11)", path1, path2},
+		},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher([]string{"kubectl"}, append(env, test.env...), clientVersion, builder)
+		actual := candidateStrings(dispatcher.Candidates(test.serverVersion))
+		if !isStringSliceEqual(test.expected, actual) {
+			t.Errorf("Candidates error: expected (%v), got (%v)", test.expected, actual)
+		}
+	}
+}
+
+// Commands too new for the exact match go to the newer version in skew.
+func TestCandidatesCommandSupport(t *testing.T) {
+	tmp, builder, env := setupCandidates(t)
+	defer os.RemoveAll(tmp)
+	serverVersion := &version.Info{Major: "1", Minor: "26"}
+
+	tests := []struct {
+		args     []string
+		env      []string
+		expected []string
+	}{
+		{
+			args:     []string{"kubectl", "auth", "whoami"},
+			expected: []string{"command support (kubectl 1.27)", "exact match (kubectl 1.26)", "nearest in skew (kubectl 1.25)", "default (kubectl 1.11)"},
+		},
+		{
+			args:     []string{"kubectl", "get", "pods"},
+			expected: []string{"exact match (kubectl 1.26)", "nearest in skew (kubectl 1.27)", "nearest in skew (kubectl 1.25)", "default (kubectl 1.11)"},
+		},
+		// The exact match supports the command.
+		{
+			args:     []string{"kubectl", "--context=dev", "events", "--for", "pod/app"},
+			expected: []string{"exact match (kubectl 1.26)", "nearest in skew (kubectl 1.27)", "nearest in skew (kubectl 1.25)", "default (kubectl 1.11)"},
+		},
+		// Strict mode only runs the exact match.
+		{
+			args:     []string{"kubectl", "auth", "whoami"},
+			env:      []string{config.StrictEnv + "=true"},
+			expected: []string{"exact match (kubectl 1.26)"},
+		},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher(test.args, append(env, test.env...), clientVersion, builder)
+		actual := candidateStrings(dispatcher.Candidates(serverVersion))
+		if len(actual) > len(test.expected) {
+			actual = actual[:len(test.expected)]
+		}
+		if !isStringSliceEqual(test.expected, actual) {
+			t.Errorf("Candidates(%v) error: expected (%v), got (%v)", test.args, test.expected, actual)
+		}
+	}
+
+	// No kubectl within the skew of 1.25 supports "auth whoami".
+	dispatcher := NewDispatcher([]string{"kubectl", "auth", "whoami"}, env, clientVersion, builder)
+	actual := candidateStrings(dispatcher.Candidates(&version.Info{Major: "1", Minor: "25"}))
+	if actual[0] != "exact match (kubectl 1.25)" {
+		t.Errorf("Candidates error: expected (exact match (kubectl 1.25)) first, got (%v)", actual)
+	}
+}
+
+func TestDispatchTo(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12", "1.13")
+	defer os.RemoveAll(tmp)
+	exe112 := gofilepath.Join(tmp, "bin", "kubectl.1.12")
+	exe113 := gofilepath.Join(tmp, "bin", "kubectl.1.13")
+	path1 := gofilepath.Join(tmp, "path1", kubectlName())
+	path2 := gofilepath.Join(tmp, "path2", kubectlName())
+	serverVersion := &version.Info{Major: "1", Minor: "13"}
+
+	tests := []struct {
+		failing  map[string]bool
+		expected []string
+	}{
+		// The first binary found is executed.
+		{
+			expected: []string{exe113},
+		},
+		// The nearest in skew after the exact match; 1.14 does not exist.
+		{
+			failing:  map[string]bool{exe113: true},
+			expected: []string{exe113, exe112},
+		},
+		// PATH binaries are tried last, each one once.
+		{
+			failing:  map[string]bool{exe113: true, exe112: true, path1: true},
+			expected: []string{exe113, exe112, path1, path2},
+		},
+	}
+	for _, test := range tests {
+		executed := []string{}
+		dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			executed = append(executed, path)
+			if test.failing[path] {
+				return fmt.Errorf("exec format error")
+			}
+			return nil
+		}
+		dispatcher.dispatchTo(dispatcher.Candidates(serverVersion))
+		if !isStringSliceEqual(test.expected, executed) {
+			t.Errorf("Dispatch order error: expected (%v), got (%v)", test.expected, executed)
+		}
+	}
+}
+
+func TestDispatchError(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.13")
+	defer os.RemoveAll(tmp)
+	dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+	dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+		return fmt.Errorf("exec format error")
+	}
+	err := dispatcher.dispatchTo(dispatcher.Candidates(&version.Info{Major: "1", Minor: "13"}))
+	dispatchErr, ok := err.(*DispatchError)
+	if !ok {
+		t.Fatalf("Expected DispatchError, got (%v)", err)
+	}
+	// 1.13, 1.14, 1.12, 1.11, and both PATH binaries.
+	if len(dispatchErr.Attempts) != 6 {
+		t.Errorf("Expected (6) attempts, got (%d): %v", len(dispatchErr.Attempts), err)
+	}
+	for _, expected := range []string{
+		"1. exact match (kubectl 1.13): " + gofilepath.Join(tmp, "bin", "kubectl.1.13") + ": exec format error",
+		"2. nearest in skew (kubectl 1.14): ",
+		"4. default (kubectl 1.11): ",
+		"6. PATH (" + gofilepath.Join(tmp, "path2", kubectlName()) + ")",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain (%s), got (%s)", expected, err)
+		}
+	}
+}
+
+func TestDispatchToStopsOnExit(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12", "1.13")
+	defer os.RemoveAll(tmp)
+	executed := []string{}
+	dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+	dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+		executed = append(executed, path)
+		return &ExitError{Path: path, Code: 1}
+	}
+	// kubectl ran as a child process and failed; no other binary is tried.
+	err := dispatcher.dispatchTo(dispatcher.Candidates(&version.Info{Major: "1", Minor: "13"}))
+	if _, ok := err.(*ExitError); !ok || len(executed) != 1 {
+		t.Errorf("Expected a single run ending in ExitError, got (%v) after (%v)", err, executed)
+	}
+}
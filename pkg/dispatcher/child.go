@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ExitError is returned when kubectl, run as a child process, exits with a
+// non-zero code or is killed by a signal. Exit terminates the dispatcher the
+// same way, so callers see the same status as if kubectl had been exec'd.
+type ExitError struct {
+	Path string
+	// Code is the exit code, or -1 if the child was killed by a signal.
+	Code int
+	// Signal is the signal which killed the child, or zero.
+	Signal syscall.Signal
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("%s killed by signal: %v", e.Path, e.Signal)
+	}
+	return fmt.Sprintf("%s exited with code %d", e.Path, e.Code)
+}
+
+// runChild runs the opened kubectl binary as a child process with the
+// dispatcher's stdio, instead of replacing the dispatcher. The child stays
+// in the dispatcher's process group, so it remains in the terminal's
+// foreground group, and TTY handling and job control (e.g. "kubectl exec
+// -it", "kubectl port-forward") work as they do after an exec. Signals sent
+// to the dispatcher are forwarded to the child, except those the terminal
+// sends to the whole group, which the child already receives (e.g. Ctrl-C
+// must interrupt kubectl once). Returns an error without
+// running anything if the child can not be started, so the next candidate
+// can be tried; otherwise returns nil or an ExitError once the child exits.
+// The child's standard error is written to "stderr".
+func runChild(f *os.File, path string, argv []string, envv []string, stderr io.Writer) error {
+	childPath, err := execPath(f, path)
+	if err != nil {
+		return err
+	}
+	cmd := &exec.Cmd{
+		Path:   childPath,
+		Args:   argv,
+		Env:    envv,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: stderr,
+	}
+	// Catch signals before the child starts, so none of them kill the
+	// dispatcher while the child runs.
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	delivered := map[os.Signal]bool{}
+	for _, sig := range groupSignals() {
+		delivered[sig] = true
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if delivered[sig] {
+					klog.V(4).Infof("Not forwarding %v to %s, which received it from the terminal", sig, path)
+					continue
+				}
+				klog.V(4).Infof("Forwarding %v to %s", sig, path)
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = cmd.Wait()
+	klog.V(3).Infof("%s ran for %s: %v", path, time.Since(start), cmd.ProcessState)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return newExitError(path, exitErr.ProcessState)
+	}
+	return err
+}
+
+// runChild runs the kubectl child process, keeping the end of its standard
+// error if it may have to be dispatched again.
+func (d *Dispatcher) runChild(f *os.File, path string, argv []string, envv []string) error {
+	if d.stderrTail == nil {
+		return runChild(f, path, argv, envv, os.Stderr)
+	}
+	d.stderrTail.Reset()
+	return runChild(f, path, argv, envv, io.MultiWriter(os.Stderr, d.stderrTail))
+}
+
+// newExitError returns the ExitError for the exited child process state.
+func newExitError(path string, state *os.ProcessState) *ExitError {
+	e := &ExitError{Path: path, Code: state.ExitCode()}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		e.Signal = status.Signal()
+	}
+	return e
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Signals forwarded to the kubectl child process.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGWINCH, syscall.SIGHUP}
+
+// Signals the terminal sends to its whole foreground process group (on
+// Ctrl-C, a window resize, or a hangup).
+var terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGWINCH, syscall.SIGHUP}
+
+// groupSignals returns the signals which the child, sharing the
+// dispatcher's process group, already receives from the terminal: none,
+// unless the dispatcher is in the foreground group of its terminal.
+var groupSignals = func() []os.Signal {
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	if err != nil || pgrp != syscall.Getpgrp() {
+		return nil
+	}
+	return terminalSignals
+}
+
+// Exit terminates the dispatcher with the exit status of the child. If the
+// child was killed by a signal which terminates quietly by default, the
+// dispatcher kills itself with it. Otherwise, as for other signals, it exits
+// with 128 plus the signal number, as shells report them.
+func (e *ExitError) Exit() {
+	if e.Signal == 0 {
+		os.Exit(e.Code)
+	}
+	switch e.Signal {
+	case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGPIPE:
+		signal.Reset(e.Signal)
+		syscall.Kill(os.Getpid(), e.Signal)
+	}
+	os.Exit(128 + int(e.Signal))
+}
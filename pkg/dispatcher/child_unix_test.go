@@ -0,0 +1,174 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runScript runs the shell script as a kubectl child process.
+func runScript(t *testing.T, dir string, script string) error {
+	path := gofilepath.Join(dir, "kubectl.1.12")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return runChild(f, path, []string{"kubectl", "get", "pods"}, []string{}, os.Stderr)
+}
+
+func TestRunChild(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		script       string
+		expectCode   int
+		expectSignal syscall.Signal
+	}{
+		{script: "exit 0\n"},
+		{script: "exit 3\n", expectCode: 3},
+		{script: "test \"$1 $2\" = \"get pods\" || exit 1\n"},
+		{script: "kill -TERM $$\n", expectCode: -1, expectSignal: syscall.SIGTERM},
+	}
+	for _, test := range tests {
+		err := runScript(t, tmp, test.script)
+		if test.expectCode == 0 {
+			if err != nil {
+				t.Errorf("Unexpected error running (%q): %v", test.script, err)
+			}
+			continue
+		}
+		exitErr, ok := err.(*ExitError)
+		if !ok {
+			t.Errorf("Expected ExitError running (%q), got (%v)", test.script, err)
+			continue
+		}
+		if test.expectCode != exitErr.Code || test.expectSignal != exitErr.Signal {
+			t.Errorf("Exit status of (%q): expected (%d, %v), got (%d, %v)", test.script, test.expectCode, test.expectSignal, exitErr.Code, exitErr.Signal)
+		}
+	}
+}
+
+func TestRunChildForwardsSignals(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ready := gofilepath.Join(tmp, "ready")
+	received := gofilepath.Join(tmp, "received")
+	// The signal is sent to the dispatcher only, not by a terminal.
+	defer func(f func() []os.Signal) { groupSignals = f }(groupSignals)
+	groupSignals = func() []os.Signal { return nil }
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(ready); err == nil {
+				syscall.Kill(os.Getpid(), syscall.SIGHUP)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	err = runScript(t, tmp, `trap 'echo hup > `+received+`; exit 7' HUP
+touch `+ready+`
+for i in $(seq 100); do sleep 0.05; done
+`)
+	if exitErr, ok := err.(*ExitError); !ok || exitErr.Code != 7 {
+		t.Errorf("Expected exit code (7) after forwarded signal, got (%v)", err)
+	}
+	if contents, _ := ioutil.ReadFile(received); strings.TrimSpace(string(contents)) != "hup" {
+		t.Errorf("Expected child to receive SIGHUP, got (%s)", contents)
+	}
+}
+
+// The child receives each signal once, whether sent to the dispatcher alone,
+// or by the terminal to both processes in its foreground group.
+func TestRunChildSignalsOnce(t *testing.T) {
+	defer func(f func() []os.Signal) { groupSignals = f }(groupSignals)
+	for _, foreground := range []bool{false, true} {
+		tmp, err := ioutil.TempDir("", "child")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		ready := gofilepath.Join(tmp, "ready")
+		received := gofilepath.Join(tmp, "received")
+		groupSignals = func() []os.Signal { return nil }
+		if foreground {
+			groupSignals = func() []os.Signal { return terminalSignals }
+		}
+		go func() {
+			for i := 0; i < 100; i++ {
+				if contents, err := ioutil.ReadFile(ready); err == nil {
+					syscall.Kill(os.Getpid(), syscall.SIGINT)
+					if foreground {
+						pid, _ := strconv.Atoi(strings.TrimSpace(string(contents)))
+						syscall.Kill(pid, syscall.SIGINT)
+					}
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+		err = runScript(t, tmp, `trap 'echo int >> `+received+`' INT
+echo $$ > `+ready+`.tmp && mv `+ready+`.tmp `+ready+`
+for i in $(seq 20); do sleep 0.05; done
+`)
+		if err != nil {
+			t.Errorf("Unexpected error (foreground %t): %v", foreground, err)
+		}
+		if contents, _ := ioutil.ReadFile(received); strings.Count(string(contents), "int") != 1 {
+			t.Errorf("Expected child to receive SIGINT once (foreground %t), got (%q)", foreground, contents)
+		}
+	}
+}
+
+func TestRunChildStartError(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := gofilepath.Join(tmp, "kubectl.1.12")
+	ioutil.WriteFile(path, []byte("garbage"), 0755)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = runChild(f, path, []string{"kubectl"}, []string{}, os.Stderr)
+	if _, ok := err.(*ExitError); ok || err == nil {
+		t.Errorf("Expected start error for garbage binary, got (%v)", err)
+	}
+}
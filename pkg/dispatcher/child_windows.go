@@ -0,0 +1,35 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"os"
+)
+
+// Signals forwarded to the kubectl child process.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// groupSignals returns the signals which the child already receives: the
+// console sends Ctrl-C to every process attached to it.
+var groupSignals = func() []os.Signal {
+	return forwardedSignals
+}
+
+// Exit terminates the dispatcher with the exit code of the child.
+func (e *ExitError) Exit() {
+	os.Exit(e.Code)
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/resolver"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+// Exit codes of the dispatcher when no kubectl binary could be run, as
+// used by shells for commands which are missing or cannot be executed.
+const (
+	ExitCodeExecFailure    = 126
+	ExitCodeBinaryNotFound = 127
+)
+
+// Decision is the kubectl binary chosen by the dispatcher, and why.
+type Decision struct {
+	// ServerVersion is nil if the server version is unknown, for the
+	// reason in ServerVersionErr.
+	ServerVersion    *version.Info
+	ServerVersionErr error
+	// Resolutions report how each version resolver consulted answered.
+	Resolutions []resolver.Report
+	Candidate   Candidate
+	Path        string
+	// Digest is the SHA-256 digest recorded for the binary, if any.
+	Digest string
+	// Source is where the binary was found (e.g. SourceStore).
+	Source string
+	// Attempts are the preferred candidates which could not be used.
+	Attempts []Attempt
+}
+
+// Match is how the chosen kubectl binary relates to the server version.
+type Match string
+
+const (
+	// MatchExact is a binary matching the server version.
+	MatchExact Match = "version match"
+	// MatchInSkew is a binary within the supported skew of the server
+	// version.
+	MatchInSkew Match = "in skew"
+	// MatchOutOfSkew is a binary not known to support the server version.
+	MatchOutOfSkew Match = "out of skew"
+	// MatchUnknown is any binary, when the server version is unknown, for
+	// the reason in ServerVersionErr.
+	MatchUnknown Match = "unknown server version"
+)
+
+// Match returns how the binary relates to the server version.
+func (d *Decision) Match() Match {
+	switch {
+	case d.ServerVersion == nil:
+		return MatchUnknown
+	case d.Candidate.Reason == ReasonExactMatch:
+		return MatchExact
+	case d.Candidate.OutOfSkew:
+		return MatchOutOfSkew
+	}
+	return MatchInSkew
+}
+
+// VersionMatch returns true if the binary matches the server version.
+func (d *Decision) VersionMatch() bool {
+	return d.Match() == MatchExact
+}
+
+func (d *Decision) String() string {
+	server := "unknown server version"
+	if d.ServerVersion != nil {
+		server = "server " + d.ServerVersion.GitVersion
+	}
+	return fmt.Sprintf("%s for %s: %s (%s)", d.Candidate, server, d.Path, d.Source)
+}
+
+// BinaryNotFoundError is returned for a kubectl version with no usable
+// binary in the dispatcher directory or the store.
+type BinaryNotFoundError struct {
+	Version string
+	Err     error
+}
+
+func (e *BinaryNotFoundError) Error() string {
+	return e.Err.Error()
+}
+
+// ExecError is returned for a kubectl binary which was found, but could not
+// be executed (e.g. it is insecure, or not a binary for this platform).
+type ExecError struct {
+	Path string
+	Err  error
+}
+
+func (e *ExecError) Error() string {
+	return e.Err.Error()
+}
+
+// ServerUnreachableError is returned when the server version could not be
+// retrieved, other than for lack of authorization.
+type ServerUnreachableError struct {
+	Err error
+}
+
+func (e *ServerUnreachableError) Error() string {
+	return fmt.Sprintf("server unreachable: %v", e.Err)
+}
+
+// UnauthorizedError is returned when the server refused the server version
+// query (401 or 403).
+type UnauthorizedError struct {
+	Err error
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("unauthorized: %v", e.Err)
+}
+
+// InvalidVersionError is returned for a server version which is not a
+// Kubernetes version.
+type InvalidVersionError struct {
+	Version string
+	Err     error
+}
+
+func (e *InvalidVersionError) Error() string {
+	return fmt.Sprintf("invalid server version %q: %v", e.Version, e.Err)
+}
+
+// IsBinaryNotFound returns true if the error is a BinaryNotFoundError.
+func IsBinaryNotFound(err error) bool {
+	_, ok := err.(*BinaryNotFoundError)
+	return ok
+}
+
+// IsExecFailure returns true if the error is an ExecError.
+func IsExecFailure(err error) bool {
+	_, ok := err.(*ExecError)
+	return ok
+}
+
+// IsServerUnreachable returns true if the error is a ServerUnreachableError.
+func IsServerUnreachable(err error) bool {
+	_, ok := err.(*ServerUnreachableError)
+	return ok
+}
+
+// IsUnauthorized returns true if the error is an UnauthorizedError.
+func IsUnauthorized(err error) bool {
+	_, ok := err.(*UnauthorizedError)
+	return ok
+}
+
+// IsInvalidVersion returns true if the error is an InvalidVersionError.
+func IsInvalidVersion(err error) bool {
+	_, ok := err.(*InvalidVersionError)
+	return ok
+}
+
+// ExitCode returns the exit code for the failed dispatch: 127 if no
+// candidate binary was found, or 126 if one was found but failed to
+// execute.
+func (e *DispatchError) ExitCode() int {
+	for _, a := range e.Attempts {
+		if !IsBinaryNotFound(a.Err) {
+			return ExitCodeExecFailure
+		}
+	}
+	return ExitCodeBinaryNotFound
+}
+
+// Decide returns the kubectl binary the dispatcher would run first for the
+// server version, without running it, or a DispatchError if there is none.
+func (d *Dispatcher) Decide() (*Decision, error) {
+	serverVersion, err := d.probeServerVersion(cacheMaxAge)
+	decision := &Decision{ServerVersion: serverVersion, ServerVersionErr: err, Resolutions: d.resolutions}
+	for _, c := range d.Candidates(serverVersion) {
+		b, err := d.locate(c)
+		if err != nil {
+			decision.Attempts = append(decision.Attempts, Attempt{Candidate: c, Err: err})
+			continue
+		}
+		decision.Candidate, decision.Path, decision.Digest, decision.Source = c, b.Path, b.Digest, b.Source
+		return decision, nil
+	}
+	return nil, d.strictError(serverVersion, &DispatchError{Attempts: decision.Attempts})
+}
+
+// probeServerVersion returns the server version, accepting a cached version
+// up to the passed age in seconds. Failures are returned as a
+// ServerUnreachableError, UnauthorizedError or InvalidVersionError, or as a
+// resolver.NoAnswerError if no version resolver answered.
+func (d *Dispatcher) probeServerVersion(cacheMaxAge uint64) (*version.Info, error) {
+	serverVersion, err := d.versionFunc(cacheMaxAge)
+	if err == nil {
+		if _, versionErr := util.MajorMinor(*serverVersion); versionErr != nil {
+			err = &InvalidVersionError{Version: serverVersion.GitVersion, Err: versionErr}
+		}
+	}
+	if err != nil {
+		err = classifyServerVersionError(err)
+		klog.V(3).Infof("Unable to get server version; dispatching to default: %v", err)
+		return nil, err
+	}
+	klog.V(4).Infof("Server Version: %s", serverVersion.GitVersion)
+	return serverVersion, nil
+}
+
+// classifyServerVersionError returns the typed error for a failed server
+// version query. No answer from the version resolvers is returned as is.
+func classifyServerVersionError(err error) error {
+	switch err.(type) {
+	case *ServerUnreachableError, *UnauthorizedError, *InvalidVersionError, *resolver.NoAnswerError:
+		return err
+	}
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return &UnauthorizedError{Err: err}
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return &InvalidVersionError{Err: err}
+	}
+	// Connection failures, server errors, and a bad kubeconfig.
+	return &ServerUnreachableError{Err: err}
+}
+
+// locate returns the candidate binary, locating versioned candidates (see
+// locateKubectl).
+func (d *Dispatcher) locate(c Candidate) (locator.Binary, error) {
+	if c.Version == nil {
+		return locator.Binary{Path: c.Path, Source: SourcePath}, nil
+	}
+	b, err := d.locateKubectl(*c.Version)
+	if err != nil {
+		majorMinor, _ := util.MajorMinor(*c.Version)
+		return locator.Binary{}, &BinaryNotFoundError{Version: majorMinor, Err: err}
+	}
+	return b, nil
+}
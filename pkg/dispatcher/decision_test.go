@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	gofilepath "path/filepath"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestDecide(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12")
+	defer os.RemoveAll(tmp)
+	exe112 := gofilepath.Join(tmp, "bin", "kubectl.1.12")
+	path1 := gofilepath.Join(tmp, "path1", kubectlName())
+
+	tests := []struct {
+		serverVersion    *version.Info
+		versionErr       error
+		expectedReason   string
+		expectedPath     string
+		expectedAttempts int
+		match            Match
+		serverErr        func(error) bool
+	}{
+		{
+			serverVersion:  &version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.3"},
+			expectedReason: ReasonExactMatch,
+			expectedPath:   exe112,
+			match:          MatchExact,
+		},
+		// 1.13 and 1.14 are missing.
+		{
+			serverVersion:    &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.1"},
+			expectedReason:   ReasonNearestInSkew,
+			expectedPath:     exe112,
+			expectedAttempts: 2,
+			match:            MatchInSkew,
+		},
+		// 1.15 and 1.16 are missing, and the default 1.11 is out of skew.
+		{
+			serverVersion:    &version.Info{Major: "1", Minor: "15", GitVersion: "v1.15.0"},
+			expectedReason:   ReasonPath,
+			expectedPath:     path1,
+			expectedAttempts: 4,
+			match:            MatchOutOfSkew,
+		},
+		// The default 1.11 is missing.
+		{
+			versionErr:       apierrors.NewUnauthorized("no token"),
+			expectedReason:   ReasonPath,
+			expectedPath:     path1,
+			expectedAttempts: 1,
+			match:            MatchUnknown,
+			serverErr:        IsUnauthorized,
+		},
+		{
+			serverVersion:    &version.Info{Major: "one", Minor: "12", GitVersion: "one.12"},
+			expectedReason:   ReasonPath,
+			expectedPath:     path1,
+			expectedAttempts: 1,
+			match:            MatchUnknown,
+			serverErr:        IsInvalidVersion,
+		},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+		dispatcher.versionFunc = func(uint64) (*version.Info, error) {
+			return test.serverVersion, test.versionErr
+		}
+		decision, err := dispatcher.Decide()
+		if err != nil {
+			t.Errorf("Unexpected error in Decide(): %v", err)
+			continue
+		}
+		if test.expectedReason != decision.Candidate.Reason || test.expectedPath != decision.Path {
+			t.Errorf("Decide error: expected (%s: %s), got (%s)", test.expectedReason, test.expectedPath, decision)
+		}
+		if test.expectedAttempts != len(decision.Attempts) {
+			t.Errorf("Decide attempts error: expected (%d), got (%v)", test.expectedAttempts, decision.Attempts)
+		}
+		for _, a := range decision.Attempts {
+			if !IsBinaryNotFound(a.Err) {
+				t.Errorf("Expected BinaryNotFoundError, got (%v)", a.Err)
+			}
+		}
+		if test.match != decision.Match() || (test.match == MatchExact) != decision.VersionMatch() {
+			t.Errorf("Version match error: expected (%s), got (%s)", test.match, decision.Match())
+		}
+		if test.serverErr == nil && decision.ServerVersionErr != nil {
+			t.Errorf("Unexpected server version error: %v", decision.ServerVersionErr)
+		}
+		if test.serverErr != nil && !test.serverErr(decision.ServerVersionErr) {
+			t.Errorf("Unexpected type of server version error: %v", decision.ServerVersionErr)
+		}
+	}
+}
+
+func TestClassifyServerVersionError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected func(error) bool
+	}{
+		{err: apierrors.NewUnauthorized("no token"), expected: IsUnauthorized},
+		{err: apierrors.NewForbidden(schema.GroupResource{}, "", fmt.Errorf("denied")), expected: IsUnauthorized},
+		{err: apierrors.NewInternalError(fmt.Errorf("etcd")), expected: IsServerUnreachable},
+		{err: &url.Error{Op: "Get", URL: "https://cluster/version", Err: fmt.Errorf("connection refused")}, expected: IsServerUnreachable},
+		{err: fmt.Errorf("got 'oops': %w", &json.SyntaxError{}), expected: IsInvalidVersion},
+		{err: fmt.Errorf("invalid configuration: no configuration has been provided"), expected: IsServerUnreachable},
+		{err: &UnauthorizedError{Err: fmt.Errorf("no token")}, expected: IsUnauthorized},
+	}
+	for _, test := range tests {
+		if actual := classifyServerVersionError(test.err); !test.expected(actual) {
+			t.Errorf("classifyServerVersionError(%v) error: got (%T)", test.err, actual)
+		}
+	}
+}
+
+func TestDispatchErrorExitCode(t *testing.T) {
+	notFound := Attempt{Err: &BinaryNotFoundError{Version: "1.13", Err: fmt.Errorf("missing")}}
+	execFailure := Attempt{Err: &ExecError{Path: "kubectl", Err: fmt.Errorf("exec format error")}}
+	tests := []struct {
+		attempts []Attempt
+		expected int
+	}{
+		{attempts: []Attempt{}, expected: ExitCodeBinaryNotFound},
+		{attempts: []Attempt{notFound, notFound}, expected: ExitCodeBinaryNotFound},
+		{attempts: []Attempt{notFound, execFailure}, expected: ExitCodeExecFailure},
+	}
+	for _, test := range tests {
+		err := &DispatchError{Attempts: test.attempts}
+		if actual := err.ExitCode(); test.expected != actual {
+			t.Errorf("ExitCode error: expected (%d), got (%d)", test.expected, actual)
+		}
+	}
+}
+
+func TestDispatchToTypedErrors(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.13")
+	defer os.RemoveAll(tmp)
+	dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+	dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+		return fmt.Errorf("exec format error")
+	}
+	err := dispatcher.dispatchTo(dispatcher.Candidates(&version.Info{Major: "1", Minor: "13"}))
+	dispatchErr, ok := err.(*DispatchError)
+	if !ok {
+		t.Fatalf("Expected DispatchError, got (%v)", err)
+	}
+	// 1.13 and both PATH binaries fail to execute; the others are missing.
+	expected := []bool{true, false, false, false, true, true}
+	for i, a := range dispatchErr.Attempts {
+		if IsExecFailure(a.Err) != expected[i] || IsBinaryNotFound(a.Err) == expected[i] {
+			t.Errorf("Attempt (%d) error type: expected exec failure (%t), got (%T)", i, expected[i], a.Err)
+		}
+	}
+}
+
+// Dispatch tells the user why the server version is unknown, unless the
+// command does not need the server.
+func TestDispatchWarnsUnknownServerVersion(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.11")
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		args       []string
+		versionErr error
+		expected   string
+	}{
+		{
+			args:       []string{"kubectl", "get", "pods"},
+			versionErr: apierrors.NewUnauthorized("no token"),
+			expected:   "kubectl dispatcher: warning: unknown server version (unauthorized: no token); using the default kubectl\n",
+		},
+		{
+			args:       []string{"kubectl", "--context=dev", "logs", "app"},
+			versionErr: &url.Error{Op: "Get", URL: "https://10.0.0.1/version", Err: fmt.Errorf("connection refused")},
+			expected:   "kubectl dispatcher: warning: unknown server version (server unreachable: Get \"https://10.0.0.1/version\": connection refused); using the default kubectl\n",
+		},
+		{
+			args:       []string{"kubectl", "config", "use-context", "dev"},
+			versionErr: apierrors.NewUnauthorized("no token"),
+		},
+		{
+			args:       []string{"kubectl", "krew", "list"},
+			versionErr: apierrors.NewUnauthorized("no token"),
+		},
+		{
+			args:       []string{"kubectl", "version", "--client"},
+			versionErr: apierrors.NewUnauthorized("no token"),
+		},
+		{
+			args:       []string{"kubectl", "create", "deploy", "app", "--image=nginx", "--dry-run=client", "-o", "yaml"},
+			versionErr: apierrors.NewUnauthorized("no token"),
+		},
+		{
+			args: []string{"kubectl", "get", "pods"},
+		},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		dispatcher := NewDispatcher(test.args, env, clientVersion, builder)
+		dispatcher.stderr = &stderr
+		dispatcher.versionFunc = func(uint64) (*version.Info, error) {
+			if test.versionErr != nil {
+				return nil, test.versionErr
+			}
+			return &version.Info{Major: "1", Minor: "11", GitVersion: "v1.11.7"}, nil
+		}
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			return nil
+		}
+		if err := dispatcher.Dispatch(); err != nil {
+			t.Errorf("Unexpected error in Dispatch(%v): %v", test.args, err)
+		}
+		if actual := stderr.String(); test.expected != actual {
+			t.Errorf("Dispatch(%v) warning: expected (%q), got (%q)", test.args, test.expected, actual)
+		}
+	}
+}
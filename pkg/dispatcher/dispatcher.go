@@ -18,11 +18,19 @@ package dispatcher
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"syscall"
+	"sort"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/cmdline"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
 	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/oci"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/resolver"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
 	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/version"
@@ -34,17 +42,58 @@ import (
 )
 
 const (
-	requestTimeout = "5s"        // Timeout for server version query
-	cacheMaxAge    = 2 * 60 * 60 // 2 hours in seconds
+	cacheMaxAge = 2 * 60 * 60 // 2 hours in seconds
 )
 
 var HelpFlags = []string{"-h", "--help"}
 
 type Dispatcher struct {
-	args            []string
-	env             []string
-	clientVersion   version.Info
-	filepathBuilder *filepath.FilepathBuilder
+	args          []string
+	env           []string
+	clientVersion version.Info
+	// filepathBuilders build the versioned binary paths in each search
+	// path, in order; the dispatcher directory unless configured.
+	filepathBuilders []*filepath.FilepathBuilder
+	// kubeConfigFlags, if set, are used for the server version query
+	// instead of the kube config flags on the command line.
+	kubeConfigFlags *genericclioptions.ConfigFlags
+	// cfg is the dispatcher configuration, read from the environment on
+	// first use unless set by WithConfig (see configuration).
+	cfg *config.Config
+	// locators, if set, find versioned binaries instead of the search
+	// paths, layouts and store.
+	locators []locator.BinaryLocator
+	// searchPaths and fs are the options of New for filepathBuilders.
+	searchPaths []string
+	fs          FileSystem
+	// stat returns the FileInfo of PATH binaries; os.Stat unless another
+	// file system is configured.
+	stat func(string) (os.FileInfo, error)
+	// now returns the current time; time.Now unless configured.
+	now func() time.Time
+	// serviceAccountDir holds the credentials of the pod the dispatcher
+	// runs in; client.ServiceAccountDir except in tests.
+	serviceAccountDir string
+	// execFunc replaces the current process with the opened binary at the
+	// path (execFile), or runs it as a child process (runChild).
+	execFunc func(f *os.File, path string, argv []string, envv []string) error
+	// executable returns the path of the dispatcher; os.Executable except
+	// in tests.
+	executable func() (string, error)
+	// versionFunc returns the server version, accepting a cached version
+	// up to the passed age in seconds; resolveServerVersion unless
+	// configured.
+	versionFunc func(cacheMaxAge uint64) (*version.Info, error)
+	// resolvers, if set, are consulted for the server version instead of
+	// the configured resolvers.
+	resolvers resolver.Chain
+	// resolutions report how the server version was last resolved.
+	resolutions []resolver.Report
+	// stderr receives the warnings for the user.
+	stderr io.Writer
+	// stderrTail keeps the end of the standard error of a kubectl child
+	// process, if it may have to be dispatched again.
+	stderrTail *tailWriter
 }
 
 // NewDispatcher returns a new pointer to a Dispatcher struct.
@@ -52,11 +101,43 @@ func NewDispatcher(args []string, env []string,
 	clientVersion version.Info,
 	filepathBuilder *filepath.FilepathBuilder) *Dispatcher {
 
-	return &Dispatcher{
-		args:            args,
-		env:             env,
-		clientVersion:   clientVersion,
-		filepathBuilder: filepathBuilder,
+	d := newDispatcher(args, env, clientVersion)
+	d.filepathBuilders = []*filepath.FilepathBuilder{filepathBuilder}
+	d.initExecFunc()
+	return d
+}
+
+// newDispatcher returns a Dispatcher with the default settings, and no
+// search paths.
+func newDispatcher(args []string, env []string, clientVersion version.Info) *Dispatcher {
+	d := &Dispatcher{
+		args:              args,
+		env:               env,
+		clientVersion:     clientVersion,
+		stat:              os.Stat,
+		now:               time.Now,
+		serviceAccountDir: client.ServiceAccountDir,
+		executable:        os.Executable,
+		stderr:            os.Stderr,
+	}
+	d.versionFunc = d.resolveServerVersion
+	return d
+}
+
+// configuration returns the dispatcher configuration. It is only read
+// once, since reading it reads the config file.
+func (d *Dispatcher) configuration() *config.Config {
+	if d.cfg == nil {
+		d.cfg = config.NewConfig(d.GetEnv())
+	}
+	return d.cfg
+}
+
+// initExecFunc sets the exec function for the configured exec mode.
+func (d *Dispatcher) initExecFunc() {
+	d.execFunc = execFile
+	if d.configuration().ExecMode == config.ExecModeChild {
+		d.execFunc = d.runChild
 	}
 }
 
@@ -81,82 +162,352 @@ const kubeConfigFlagSetName = "dispatcher-kube-config"
 // affect the server version query. Therefore, the set of kubeConfigFlags MUST
 // match the set used in the regular kubectl binary.
 func (d *Dispatcher) InitKubeConfigFlags() (*genericclioptions.ConfigFlags, error) {
+	kubeConfigFlags, _, _, err := d.parseKubeConfigFlags()
+	return kubeConfigFlags, err
+}
 
-	// IMPORTANT: If there is an error parsing flags--continue.
-	kubeConfigFlagSet := pflag.NewFlagSet("dispatcher-kube-config", pflag.ContinueOnError)
-	kubeConfigFlagSet.ParseErrorsWhitelist.UnknownFlags = true
-	kubeConfigFlagSet.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
+// parseKubeConfigFlags parses the kube config flags from the command line
+// arguments, returning the connection flags missing from the kube config
+// flags, and the parsed command line as well. Other unknown flags are
+// ignored. Only global flags
+// are parsed, following kubectl's grammar: arguments after "--", and the
+// arguments of plugins, are never read as kube config flags.
+func (d *Dispatcher) parseKubeConfigFlags() (*genericclioptions.ConfigFlags, *client.ConnectionFlags, *cmdline.CommandLine, error) {
 
-	unusedParameter := true // Could be either true or false
-	kubeConfigFlags := genericclioptions.NewConfigFlags(unusedParameter)
-	kubeConfigFlags.AddFlags(kubeConfigFlagSet)
+	kubeConfigFlagSet, kubeConfigFlags, connFlags := newKubeConfigFlagSet()
 
 	// Remove help flags, since these are special-cased in pflag.Parse,
 	// and handled in the dispatcher instead of passed to versioned binary.
 	args := util.FilterList(d.GetArgs(), HelpFlags)
-	if err := kubeConfigFlagSet.Parse(args[1:]); err != nil {
-		return nil, err
+	commandLine := cmdline.Parse(args, kubeConfigFlagSet)
+	if err := kubeConfigFlagSet.Parse(commandLine.GlobalFlags); err != nil {
+		return nil, nil, nil, err
 	}
 	kubeConfigFlagSet.VisitAll(func(flag *pflag.Flag) {
 		klog.V(4).Infof("KubeConfig Flag: --%s=%q", flag.Name, flag.Value)
 	})
+	return kubeConfigFlags, connFlags, commandLine, nil
+}
 
-	return kubeConfigFlags, nil
+// newKubeConfigFlagSet returns the flag set of the global kubectl flags the
+// dispatcher reads, with the kube config and connection flags it sets.
+func newKubeConfigFlagSet() (*pflag.FlagSet, *genericclioptions.ConfigFlags, *client.ConnectionFlags) {
+	// IMPORTANT: If there is an error parsing flags--continue.
+	kubeConfigFlagSet := pflag.NewFlagSet("dispatcher-kube-config", pflag.ContinueOnError)
+	kubeConfigFlagSet.ParseErrorsWhitelist.UnknownFlags = true
+	kubeConfigFlagSet.SetNormalizeFunc(utilflag.WordSepNormalizeFunc)
+
+	unusedParameter := true // Could be either true or false
+	kubeConfigFlags := genericclioptions.NewConfigFlags(unusedParameter)
+	kubeConfigFlags.AddFlags(kubeConfigFlagSet)
+	connFlags := client.NewConnectionFlags()
+	connFlags.AddFlags(kubeConfigFlagSet)
+	return kubeConfigFlagSet, kubeConfigFlags, connFlags
 }
 
 // Dispatch attempts to execute a matching version of kubectl based on the
-// version of the APIServer. If successful, this method will not return, since
-// current process will be overwritten (see execve(2)). Otherwise, this method
-// returns an error describing why the execution could not happen.
+// version of the APIServer. If that binary is missing or cannot be executed,
+// the next candidate is tried: the nearest version within the supported skew,
+// the default version, and finally any other kubectl on the PATH. If the
+// server version is unknown, dispatch starts with the default version. If
+// successful, this method will not return, since current process will be
+// overwritten (see execve(2)). In child exec mode, it returns once kubectl has
+// exited, with an ExitError if kubectl failed. Otherwise, this method returns
+// a DispatchError explaining every attempt, or a LoopError if the dispatcher
+// is running itself. In strict mode, a missing binary for the server version
+// is not replaced by another version; a MissingBinaryError is returned. Why
+// the server version is unknown is printed as a warning (see
+// warnUnknownServerVersion).
 func (d *Dispatcher) Dispatch() error {
+	// Stop if a versioned binary has run the dispatcher again.
+	if err := d.checkDepth(); err != nil {
+		return err
+	}
 	// Fetch the server version and generate the kubectl binary full file path
 	// from this version.
 	// Example:
 	//   serverVersion=1.11 -> /home/seans/go/bin/kubectl.1.11
-	kubeConfigFlags, err := d.InitKubeConfigFlags()
+	serverVersion, serverErr := d.probeServerVersion(cacheMaxAge)
+	klog.V(4).Infof("Client Version: %s", d.GetClientVersion().GitVersion)
+	if serverErr != nil {
+		d.warnUnknownServerVersion(serverErr)
+	}
+
+	// Delegate to the versioned kubectl binary. This overwrites the current process
+	// (by calling execve(2) system call), and it does not return on success.
+	redispatch := d.prepareRedispatch()
+	err := d.strictError(serverVersion, d.dispatchTo(d.Candidates(serverVersion)))
+	if redispatch {
+		return d.redispatchIfStale(serverVersion, err)
+	}
+	return err
+}
+
+// Commands which do not need the server, so the server version being
+// unknown is not worth a warning.
+var localCommands = map[string]bool{
+	"completion": true,
+	"config":     true,
+	"help":       true,
+	"kustomize":  true,
+	"options":    true,
+	"plugin":     true,
+}
+
+// warnUnknownServerVersion tells the user why the default kubectl runs
+// instead of one matching the server version (e.g. the server is
+// unreachable, or refused the query), unless the command does not need
+// the server (e.g. "kubectl config", "kubectl version --client" or a client
+// dry run), or is a plugin.
+func (d *Dispatcher) warnUnknownServerVersion(err error) {
+	_, _, commandLine, parseErr := d.parseKubeConfigFlags()
+	if parseErr != nil || commandLine.Plugin || len(commandLine.Command) == 0 {
+		return
+	}
+	if localCommands[commandLine.Command[0]] || commandLine.ClientOnly() {
+		return
+	}
+	fmt.Fprintf(d.stderr, "kubectl dispatcher: warning: unknown server version (%v); using the default kubectl\n", err)
+}
+
+// serverVersion queries (or reads from the cache, if no older than
+// cacheMaxAge seconds) the APIServer version for the kube config given on
+// the command line. The query timeout is the --request-timeout given on the
+// command line, if any; otherwise it adapts to the latency of the cluster.
+func (d *Dispatcher) serverVersion(cacheMaxAge uint64) (*version.Info, error) {
+	svclient, _, err := d.newServerVersionClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	svclient := client.NewServerVersionClient(kubeConfigFlags)
-	svclient.SetRequestTimeout(requestTimeout)
 	svclient.SetCacheMaxAge(cacheMaxAge)
-	serverVersion, err := svclient.ServerVersion()
+	return svclient.ServerVersion()
+}
+
+// freshServerVersion queries the server version on a new connection,
+// bypassing the HTTP cache.
+func (d *Dispatcher) freshServerVersion() (*version.Info, error) {
+	svclient, _, err := d.newServerVersionClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	klog.V(4).Infof("Server Version: %s", serverVersion.GitVersion)
-	klog.V(4).Infof("Client Version: %s", d.GetClientVersion().GitVersion)
-	if util.VersionMatch(d.GetClientVersion(), *serverVersion) {
-		// TODO(seans): Consider changing to return a bool as well as error, since
-		// this isn't really an error.
-		return fmt.Errorf("Client/Server version match--fall through to default")
+	svclient.SetFreshConnections(true)
+	return svclient.ServerVersion()
+}
+
+// newServerVersionClient returns the client querying the server version for
+// the kube config flags, and the flags.
+func (d *Dispatcher) newServerVersionClient() (*client.ServerVersionClient, *genericclioptions.ConfigFlags, error) {
+	kubeConfigFlags, connFlags := d.kubeConfigFlags, client.NewConnectionFlags()
+	if kubeConfigFlags == nil {
+		var err error
+		if kubeConfigFlags, connFlags, _, err = d.parseKubeConfigFlags(); err != nil {
+			return nil, nil, err
+		}
+	}
+	svclient := client.NewServerVersionClient(kubeConfigFlags)
+	svclient.SetConnectionFlags(connFlags)
+	svclient.SetClock(d.now)
+	svclient.SetInClusterEnv(d.GetEnv(), d.serviceAccountDir)
+	// Library callers may leave flags unset (nil).
+	if timeout := kubeConfigFlags.Timeout; timeout != nil && *timeout != "" && *timeout != "0" {
+		if err := svclient.SetRequestTimeout(*timeout); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		cfg := d.configuration()
+		svclient.SetLatencyHistory(client.NewLatencyHistory(cfg.CacheDir))
+	}
+	return svclient, kubeConfigFlags, nil
+}
+
+// Sources of kubectl binaries.
+const (
+	SourceDirectory  = locator.SourceDirectory
+	SourceCompressed = locator.SourceCompressed
+	SourceTemplate   = locator.SourceTemplate
+	SourceStore      = locator.SourceStore
+	SourceOCILayout  = locator.SourceOCILayout
+	SourcePath       = locator.SourcePath
+)
+
+// locateKubectl returns the kubectl binary for the version, with its source.
+// The binaries found by the locators (see binaryLocators) are tried in order
+// of rank. A compressed binary (e.g. "kubectl.1.12.xz") is decompressed into
+// the managed store on first use. If no locator finds a binary, it is
+// extracted from the configured OCI image layout into the store.
+func (d *Dispatcher) locateKubectl(v version.Info) (locator.Binary, error) {
+	cfg := d.configuration()
+	s := store.NewStore(cfg.StoreDir)
+	binaries, err := locator.Locate(d.binaryLocators(cfg, s), v)
+	for _, b := range binaries {
+		if !b.Compressed {
+			return b, nil
+		}
+		storeFilepath, decompressErr := s.Decompressed(v, b.Path)
+		if decompressErr != nil {
+			klog.V(3).Infof("Unable to decompress %s: %v", b.Path, decompressErr)
+			if err == nil {
+				err = decompressErr
+			}
+			continue
+		}
+		if _, statErr := d.filepathBuilders[0].Stat(storeFilepath); statErr != nil {
+			return locator.Binary{}, statErr
+		}
+		b.Path, b.Compressed = storeFilepath, false
+		if digest, digestErr := store.ReadDigestFile(storeFilepath + store.DigestSuffix); digestErr == nil {
+			b.Digest = digest
+		}
+		return b, nil
 	}
+	b, ociErr := d.extractKubectl(v, s, cfg.OCILayout)
+	if ociErr != nil {
+		klog.V(3).Infof("No kubectl %v in OCI layout: %v", v, ociErr)
+		return locator.Binary{}, err
+	}
+	if _, statErr := d.filepathBuilders[0].Stat(b.Path); statErr != nil {
+		return locator.Binary{}, statErr
+	}
+	return b, nil
+}
+
+// binaryLocators returns the locators of versioned binaries, in order of
+// precedence: each search path (the dispatcher directory unless
+// configured), each configured layout template, and the managed store.
+// Binaries outside the search paths are validated as in the first one.
+func (d *Dispatcher) binaryLocators(cfg *config.Config, s *store.Store) []locator.BinaryLocator {
+	if d.locators != nil {
+		return d.locators
+	}
+	locators := []locator.BinaryLocator{}
+	for _, builder := range d.filepathBuilders {
+		locators = append(locators, &locator.DirLocator{Builder: builder})
+	}
+	stat := d.filepathBuilders[0].Stat
+	for _, layout := range cfg.Layouts {
+		l, err := locator.NewTemplateLocator(layout, stat)
+		if err != nil {
+			klog.Warningf("Ignoring layout: %v", err)
+			continue
+		}
+		locators = append(locators, l)
+	}
+	return append(locators, locator.NewStoreLocator(s, stat))
+}
 
-	kubectlFilepath, err := d.filepathBuilder.VersionedFilePath(*serverVersion)
+// Binaries returns every versioned kubectl binary the locators hold, in
+// order of precedence, whether usable or not (see locator.List), followed
+// by the images in the configured OCI image layout. Locators which can not
+// tell the versions of their binaries are skipped.
+func (d *Dispatcher) Binaries() ([]locator.Binary, error) {
+	cfg := d.configuration()
+	binaries, err := locator.List(d.binaryLocators(cfg, store.NewStore(cfg.StoreDir)))
+	if err != nil || cfg.OCILayout == "" {
+		return binaries, err
+	}
+	images, err := listOCILayout(cfg.OCILayout)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := d.filepathBuilder.ValidateFilepath(kubectlFilepath); err != nil {
-		return err
+	return append(binaries, images...), nil
+}
+
+// listOCILayout returns the image the dispatcher would extract kubectl
+// from for each version in the OCI image layout, ordered by version. The
+// path of each is "<layout>:<tag>", since it is only extracted on use.
+func listOCILayout(ociLayout string) ([]locator.Binary, error) {
+	layout, err := oci.NewLayout(ociLayout)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := layout.Tags()
+	if err != nil {
+		return nil, err
+	}
+	versions := []version.Info{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		v, err := util.ParseVersion(tag)
+		if err != nil {
+			continue
+		}
+		if majorMinor, _ := util.MajorMinor(v); !seen[majorMinor] {
+			seen[majorMinor] = true
+			versions = append(versions, v)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		majorI, _ := util.GetMajorVersion(versions[i])
+		majorJ, _ := util.GetMajorVersion(versions[j])
+		if majorI != majorJ {
+			return majorI < majorJ
+		}
+		minorI, _ := util.GetMinorVersion(versions[i])
+		minorJ, _ := util.GetMinorVersion(versions[j])
+		return minorI < minorJ
+	})
+	images := []locator.Binary{}
+	for _, v := range versions {
+		tag, err := layout.FindTag(v)
+		if err != nil {
+			return nil, err
+		}
+		majorMinor, _ := util.MajorMinor(v)
+		images = append(images, locator.Binary{Version: majorMinor, Path: ociLayout + ":" + tag, Source: SourceOCILayout})
 	}
+	return images, nil
+}
 
-	// Delegate to the versioned kubectl binary. This overwrites the current process
-	// (by calling execve(2) system call), and it does not return on success.
-	klog.V(3).Infof("kubectl dispatching: %s\n", kubectlFilepath)
-	return syscall.Exec(kubectlFilepath, d.GetArgs(), d.GetEnv())
+// extractKubectl extracts the kubectl binary for the version from the OCI
+// image layout into the store, under a key for the image digest, unless
+// the store already holds an intact copy from the same image.
+func (d *Dispatcher) extractKubectl(v version.Info, s *store.Store, ociLayout string) (locator.Binary, error) {
+	if ociLayout == "" {
+		return locator.Binary{}, fmt.Errorf("no OCI image layout configured")
+	}
+	layout, err := oci.NewLayout(ociLayout)
+	if err != nil {
+		return locator.Binary{}, err
+	}
+	tag, err := layout.FindTag(v)
+	if err != nil {
+		return locator.Binary{}, err
+	}
+	imageDigest, err := layout.Digest(tag)
+	if err != nil {
+		return locator.Binary{}, err
+	}
+	majorMinor, _ := util.MajorMinor(v)
+	key := store.Key("oci:" + imageDigest)
+	if storeFilepath, err := s.LookupKeyed(v, key); err == nil {
+		digest, _ := store.ReadDigestFile(storeFilepath + store.DigestSuffix)
+		return locator.Binary{Version: majorMinor, Path: storeFilepath, Digest: digest, Source: SourceOCILayout}, nil
+	}
+	storeFilepath, digest, err := s.PutKeyed(v, key, func(w io.Writer) error {
+		return layout.ExtractKubectl(tag, w)
+	}, "")
+	if err != nil {
+		return locator.Binary{}, err
+	}
+	klog.V(3).Infof("Extracted kubectl %s (sha256:%s) from OCI layout: %s", tag, digest, storeFilepath)
+	return locator.Binary{Version: majorMinor, Path: storeFilepath, Digest: digest, Source: SourceOCILayout}, nil
 }
 
 // Execute is the entry point to the dispatcher. It passes in the current client
-// version, which is used to determine if a delegation is necessary. If this function
-// successfully delegates, then it will NOT return, since the current process will be
-// overwritten (see execve(2)). If this function does not delegate, it merely falls
-// through. This function assumes logging has been initialized before it is run;
-// otherwise, log statements will not work.
-func Execute(clientVersion version.Info) {
+// version, which is the default version dispatched to when the server version
+// is unknown, the command line arguments without the dispatcher's own flags,
+// these flags (see ParseFlags), and the configuration they apply to (see
+// Flags.Env). If this function successfully delegates,
+// then it will NOT return, since the current process will be overwritten (see
+// execve(2)); in child exec mode, it returns once kubectl exits, with an
+// ExitError if kubectl failed. Otherwise, it returns an error explaining every
+// binary it tried. This function assumes logging has been initialized before
+// it is run; otherwise, log statements will not work.
+func Execute(clientVersion version.Info, args []string, flags *Flags, cfg *config.Config) error {
 	klog.V(4).Info("Starting dispatcher")
-	filepathBuilder := filepath.NewFilepathBuilder(&filepath.ExeDirGetter{}, os.Stat)
-	dispatcher := NewDispatcher(os.Args, os.Environ(), clientVersion, filepathBuilder)
-	if err := dispatcher.Dispatch(); err != nil {
-		klog.V(3).Infof("Dispatch error: %v", err)
+	dispatcher := New(WithArgs(args), WithEnv(flags.Env(os.Environ())), WithConfig(cfg), WithClientVersion(clientVersion))
+	if flags.DryRun {
+		dispatcher.execFunc = printExec(os.Stdout)
 	}
+	return dispatcher.Dispatch()
 }
@@ -17,8 +17,21 @@ limitations under the License.
 package dispatcher
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
@@ -141,6 +154,68 @@ func TestInitKubeConfigFlags(t *testing.T) {
 	}
 }
 
+func TestInitKubeConfigFlagsGrammar(t *testing.T) {
+	tests := []struct {
+		args    []string
+		context string
+	}{
+		{args: []string{"kubectl", "get", "pods", "--context", "dev"}, context: "dev"},
+		{args: []string{"kubectl", "--context=dev", "exec", "pod", "--", "kubectl", "--context=prod"}, context: "dev"},
+		{args: []string{"kubectl", "run", "app", "--image=app", "--command", "--", "app", "--context=foo"}},
+		{args: []string{"kubectl", "my-plugin", "--context=foo"}},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher(test.args, []string{}, clientVersion, nil)
+		flags, err := dispatcher.InitKubeConfigFlags()
+		if err != nil {
+			t.Errorf("Unexpected error in InitKubeConfigFlags(): %v", err)
+			continue
+		}
+		if *flags.Context != test.context {
+			t.Errorf("InitKubeConfigFlags(%v) context error: expected (%s), got (%s)", test.args, test.context, *flags.Context)
+		}
+	}
+}
+
+func TestParseConnectionFlags(t *testing.T) {
+	tests := []struct {
+		args               []string
+		tlsServerName      string
+		disableCompression bool
+	}{
+		{args: []string{"kubectl", "get", "pods"}},
+		{
+			args:               []string{"kubectl", "--disable-compression", "get", "pods", "--tls-server-name", "example.com"},
+			tlsServerName:      "example.com",
+			disableCompression: true,
+		},
+		// Connection flags unknown to the dispatcher are ignored.
+		{
+			args:          []string{"kubectl", "--tls-server-name=example.com", "--unknown-flag=foo", "get", "pods"},
+			tlsServerName: "example.com",
+		},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher(test.args, []string{}, clientVersion, nil)
+		_, connFlags, _, err := dispatcher.parseKubeConfigFlags()
+		if err != nil {
+			t.Errorf("Unexpected error in parseKubeConfigFlags(): %v", err)
+			continue
+		}
+		if *connFlags.TLSServerName != test.tlsServerName || *connFlags.DisableCompression != test.disableCompression {
+			t.Errorf("parseKubeConfigFlags(%v) error: expected (%s, %t), got (%s, %t)", test.args,
+				test.tlsServerName, test.disableCompression, *connFlags.TLSServerName, *connFlags.DisableCompression)
+		}
+	}
+}
+
+func TestServerVersionBadRequestTimeout(t *testing.T) {
+	dispatcher := NewDispatcher([]string{"kubectl", "--request-timeout=soon", "get", "pods"}, []string{}, clientVersion, nil)
+	if _, err := dispatcher.serverVersion(cacheMaxAge); err == nil || !strings.Contains(err.Error(), "Invalid timeout value") {
+		t.Errorf("Expected invalid timeout error, got (%v)", err)
+	}
+}
+
 // argsListFromMap creates a list of arguments from a map,
 // prepending the "kubectl" command to the beginning of the list.
 func argsListFromMap(args map[string]string) []string {
@@ -202,3 +277,86 @@ func compareConfigFlags(t *testing.T,
 
 	return isEqual
 }
+
+func TestLocateKubectl(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "dispatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exeDir := gofilepath.Join(tmp, "bin")
+	storeDir := gofilepath.Join(tmp, "store")
+	os.Mkdir(exeDir, 0755)
+	builder := filepath.NewFilepathBuilder(&filepath.FixedDirGetter{Dir: exeDir}, os.Stat)
+	layout := gofilepath.Join(tmp, "kubernetes", "{{.Version}}", "kubectl{{.Ext}}")
+	env := []string{config.StoreDirEnv + "=" + storeDir, config.LayoutsEnv + "=" + layout}
+
+	v112 := version.Info{Major: "1", Minor: "12"}
+	v113 := version.Info{Major: "1", Minor: "13"}
+	v114 := version.Info{Major: "1", Minor: "14"}
+	v115 := version.Info{Major: "1", Minor: "15"}
+	exe112, _ := builder.VersionedFilePath(v112)
+	exe115, _ := builder.VersionedFilePath(v115)
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	gzw.Write([]byte("compressed kubectl"))
+	gzw.Close()
+	ioutil.WriteFile(exe115+".gz", compressed.Bytes(), 0644)
+	ioutil.WriteFile(exe112, []byte("kubectl"), 0755)
+	s := store.NewStore(storeDir)
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, "stored kubectl")
+		return err
+	}
+	if _, _, err := s.PutKeyed(v112, store.Key("stored"), write, ""); err != nil {
+		t.Fatal(err)
+	}
+	stored113, digest113, err := s.PutKeyed(v113, store.Key("stored"), write, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored115, _ := s.DecompressedPath(v115, exe115+".gz")
+	v116 := version.Info{Major: "1", Minor: "16"}
+	templated116 := gofilepath.Join(tmp, "kubernetes", "1.16", "kubectl"+filepath.ExeSuffix(runtime.GOOS))
+	os.MkdirAll(gofilepath.Dir(templated116), 0755)
+	ioutil.WriteFile(templated116, []byte("kubectl"), 0755)
+
+	tests := []struct {
+		version        version.Info
+		expected       string
+		expectedSource string
+		expectedDigest string
+		expectError    bool
+	}{
+		// The dispatcher directory takes precedence over the store.
+		{version: v112, expected: exe112, expectedSource: SourceDirectory},
+		{version: v113, expected: stored113, expectedSource: SourceStore, expectedDigest: digest113},
+		{version: v114, expectError: true},
+		// Compressed binaries are decompressed into the store.
+		{version: v115, expected: stored115, expectedSource: SourceCompressed, expectedDigest: fmt.Sprintf("%x", sha256.Sum256([]byte("compressed kubectl")))},
+		{version: v116, expected: templated116, expectedSource: SourceTemplate},
+	}
+	dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+	for _, test := range tests {
+		b, err := dispatcher.locateKubectl(test.version)
+		actual, source := b.Path, b.Source
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error locating kubectl (%v); received none", test.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error locating kubectl (%v): %v", test.version, err)
+		}
+		if test.expected != actual {
+			t.Errorf("locateKubectl error: expected (%s), got (%s)", test.expected, actual)
+		}
+		if test.expectedSource != source {
+			t.Errorf("locateKubectl source error: expected (%s), got (%s)", test.expectedSource, source)
+		}
+		if test.expectedDigest != b.Digest {
+			t.Errorf("locateKubectl digest error: expected (%s), got (%s)", test.expectedDigest, b.Digest)
+		}
+	}
+}
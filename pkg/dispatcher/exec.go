@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"k8s.io/klog"
+)
+
+// execKubectl replaces the current process with the kubectl binary at
+// "path" (or runs it as a child process, in child exec mode), after checking
+// that the binary is in a secure location (see filepath.CheckSecure), and
+// that it is not the dispatcher itself. The binary is opened before it is
+// checked, and the opened file is what gets executed where the platform
+// allows it, so the binary can not be swapped between the check and the exec.
+func (d *Dispatcher) execKubectl(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := d.checkSecure(path, fi); err != nil {
+		return err
+	}
+	if err := d.checkNotSelf(path, f, fi); err != nil {
+		return err
+	}
+	return d.execFunc(f, path, d.GetArgs(), d.dispatchEnv())
+}
+
+// checkSecure applies the configured secure mode to the opened binary.
+func (d *Dispatcher) checkSecure(path string, fi os.FileInfo) error {
+	mode := d.configuration().SecureMode
+	if mode == config.SecureModeOff {
+		return nil
+	}
+	err := filepath.CheckSecure(path, fi)
+	if err != nil && mode == config.SecureModeWarn {
+		klog.Warningf("%v", err)
+		return nil
+	}
+	return err
+}
+
+// printExec returns an exec function which prints the binary and arguments
+// it would execute to "out", for --dispatcher-dry-run.
+func printExec(out io.Writer) func(f *os.File, path string, argv []string, envv []string) error {
+	return func(f *os.File, path string, argv []string, envv []string) error {
+		_, err := fmt.Fprintln(out, strings.Join(append([]string{path}, argv[1:]...), " "))
+		return err
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog"
+)
+
+// execFile executes the opened file through /proc/self/fd (as fexecve(3)
+// does), so the file which runs is the one which was checked, even if
+// "path" has been replaced since. syscall.Exec holds the fork lock and
+// restores the process state (e.g. the file descriptor limit) as kubectl
+// expects. Falls back to executing "path" if /proc is not mounted.
+func execFile(f *os.File, path string, argv []string, envv []string) error {
+	fdPath, err := execPath(f, path)
+	if err != nil {
+		return err
+	}
+	err = syscall.Exec(fdPath, argv, envv)
+	if err == syscall.ENOENT {
+		klog.V(3).Infof("%s is missing; executing %s by path", fdPath, path)
+		return syscall.Exec(path, argv, envv)
+	}
+	return err
+}
+
+// execPath returns a path naming the opened file, for starting it as a
+// child process.
+func execPath(f *os.File, path string) (string, error) {
+	if err := keepScriptOpen(f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd()), nil
+}
+
+// keepScriptOpen keeps the opened file open across exec if it is a script,
+// since the interpreter opens the script through /dev/fd.
+func keepScriptOpen(f *os.File) error {
+	if !isScript(f) {
+		return nil
+	}
+	_, err := unix.FcntlInt(f.Fd(), unix.F_SETFD, 0)
+	return err
+}
+
+// isScript returns true if the file starts with "#!".
+func isScript(f *os.File) bool {
+	magic := make([]byte, 2)
+	n, _ := f.ReadAt(magic, 0)
+	return n == 2 && string(magic) == "#!"
+}
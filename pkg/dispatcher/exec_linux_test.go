@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	gofilepath "path/filepath"
+	"strings"
+	"testing"
+)
+
+const execFileHelperEnv = "DISPATCHER_TEST_EXEC_FILE"
+
+// TestExecFileHelper is not a real test: it replaces the test process
+// started by TestExecFile with the file named by execFileHelperEnv.
+func TestExecFileHelper(t *testing.T) {
+	path := os.Getenv(execFileHelperEnv)
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The path no longer names the opened file when it is executed.
+	os.Remove(path)
+	err = execFile(f, path, []string{"kubectl", "version"}, []string{"GREETING=hello"})
+	t.Fatalf("execFile returned: %v", err)
+}
+
+func TestExecFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := gofilepath.Join(tmp, "kubectl.1.12")
+	ioutil.WriteFile(path, []byte("#!/bin/sh\necho \"$GREETING $1 $(ulimit -n)\"\n"), 0755)
+	// The file descriptor limit raised by the Go runtime is restored.
+	limit, err := exec.Command("/bin/sh", "-c", "ulimit -n").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "hello version " + strings.TrimSpace(string(limit))
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExecFileHelper$")
+	cmd.Env = append(os.Environ(), execFileHelperEnv+"="+path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Unexpected error executing file: %v: %s", err, out)
+	}
+	if actual := strings.TrimSpace(string(out)); actual != expected {
+		t.Errorf("execFile output error: expected (%s), got (%s)", expected, actual)
+	}
+}
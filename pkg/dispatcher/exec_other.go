@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// execFile executes the binary at "path". Only Linux can execute an opened
+// file, so the binary could be replaced after it was checked.
+func execFile(f *os.File, path string, argv []string, envv []string) error {
+	return syscall.Exec(path, argv, envv)
+}
+
+// execPath returns the path of the binary, for starting it as a child
+// process.
+func execPath(f *os.File, path string) (string, error) {
+	return path, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+)
+
+func TestExecKubectlSecureMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("owner and mode checks are unix specific")
+	}
+	tmp, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	secure := gofilepath.Join(tmp, "kubectl.1.12")
+	insecure := gofilepath.Join(tmp, "kubectl.1.13")
+	ioutil.WriteFile(secure, []byte("#!/bin/sh\n"), 0755)
+	ioutil.WriteFile(insecure, []byte("#!/bin/sh\n"), 0755)
+	os.Chmod(insecure, 0777)
+
+	tests := []struct {
+		path           string
+		mode           string
+		expectExecuted bool
+	}{
+		{path: secure, mode: config.SecureModeEnforce, expectExecuted: true},
+		{path: insecure, mode: config.SecureModeEnforce, expectExecuted: false},
+		{path: insecure, mode: config.SecureModeWarn, expectExecuted: true},
+		{path: insecure, mode: config.SecureModeOff, expectExecuted: true},
+	}
+	for _, test := range tests {
+		executed := false
+		env := []string{config.SecureModeEnv + "=" + test.mode}
+		dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, nil)
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			executed = true
+			return fmt.Errorf("exec failed")
+		}
+		err := dispatcher.execKubectl(test.path)
+		if test.expectExecuted != executed {
+			t.Errorf("execKubectl(%s) in mode %s: expected executed (%t), got (%t)", test.path, test.mode, test.expectExecuted, executed)
+		}
+		if !test.expectExecuted && !filepath.IsInsecure(err) {
+			t.Errorf("execKubectl(%s) in mode %s: expected insecure error, got (%v)", test.path, test.mode, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/cmdline"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+)
+
+// FlagPrefix is the prefix reserved for the dispatcher's own flags, which
+// are removed from the command line before kubectl runs.
+const FlagPrefix = "--dispatcher-"
+
+// Flags holds the dispatcher's own command line flags.
+type Flags struct {
+	// Verbosity is the log level of the dispatcher (--dispatcher-v). It
+	// does not change the log level of kubectl, which is set by -v.
+	Verbosity string
+	// Version prints the dispatcher version (--dispatcher-version).
+	Version bool
+	// DryRun prints the kubectl binary the dispatcher would run, instead
+	// of running it (--dispatcher-dry-run).
+	DryRun bool
+	// ConfigFile is the dispatcher config file (--dispatcher-config).
+	ConfigFile string
+	// Strict fails if the kubectl binary matching the server version is
+	// missing (--dispatcher-strict).
+	Strict bool
+}
+
+// ParseFlags returns the dispatcher's own flags within the command line
+// arguments (as in os.Args), and the arguments with these flags removed.
+// Both "--dispatcher-v=5" and "--dispatcher-v 5" forms are accepted. As
+// kubectl flags, dispatcher flags may come before or after a builtin
+// command, up to "--", so the arguments of the command kubectl runs (e.g.
+// in "kubectl exec pod -- sh") are never parsed. Neither are those of a
+// plugin, which own every argument after the plugin name: dispatcher flags
+// must come before it. The values of global kubectl flags (e.g. "prod" in
+// "--context prod") are skipped. Returns an error for an unknown flag with
+// the dispatcher prefix.
+func ParseFlags(args []string) (*Flags, []string, error) {
+	flags := &Flags{}
+	if len(args) == 0 {
+		return flags, args, nil
+	}
+	globals, _, _ := newKubeConfigFlagSet()
+	remaining := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		// As for kubectl, only the first argument left to it may name a
+		// plugin.
+		if arg == "--" || (len(remaining) == 1 && !strings.HasPrefix(arg, "-") && !cmdline.IsBuiltin(arg)) {
+			remaining = append(remaining, args[i:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			remaining = append(remaining, arg)
+			continue
+		}
+		if !strings.HasPrefix(arg, FlagPrefix) {
+			remaining = append(remaining, arg)
+			if cmdline.TakesValue(arg, globals) && i+1 < len(args) {
+				i++
+				remaining = append(remaining, args[i])
+			}
+			continue
+		}
+		name, value := arg, ""
+		hasValue := false
+		if equals := strings.Index(arg, "="); equals >= 0 {
+			name, value, hasValue = arg[:equals], arg[equals+1:], true
+		}
+		switch name {
+		case FlagPrefix + "v", FlagPrefix + "config":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("flag needs an argument: %s", name)
+				}
+				i++
+				value = args[i]
+			}
+			if name == FlagPrefix+"v" {
+				if _, err := strconv.Atoi(value); err != nil {
+					return nil, nil, fmt.Errorf("invalid argument %q for %s: must be a number", value, name)
+				}
+				flags.Verbosity = value
+			} else {
+				flags.ConfigFile = value
+			}
+		case FlagPrefix + "version", FlagPrefix + "dry-run", FlagPrefix + "strict":
+			enabled := true
+			if hasValue {
+				var err error
+				if enabled, err = strconv.ParseBool(value); err != nil {
+					return nil, nil, fmt.Errorf("invalid argument %q for %s: %v", value, name, err)
+				}
+			}
+			switch name {
+			case FlagPrefix + "version":
+				flags.Version = enabled
+			case FlagPrefix + "dry-run":
+				flags.DryRun = enabled
+			default:
+				flags.Strict = enabled
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown dispatcher flag: %s", name)
+		}
+	}
+	return flags, remaining, nil
+}
+
+// Env returns the environment with the settings given by the flags, which
+// apply to the dispatcher configuration (see config.NewConfig).
+func (f *Flags) Env(env []string) []string {
+	if f.ConfigFile != "" {
+		env = append(env, config.ConfigFileEnv+"="+f.ConfigFile)
+	}
+	if f.Strict {
+		env = append(env, config.StrictEnv+"=true")
+	}
+	return env
+}
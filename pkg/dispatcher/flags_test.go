@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"os"
+	gofilepath "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args        []string
+		expected    Flags
+		remaining   []string
+		expectError bool
+	}{
+		{
+			args:      []string{"kubectl", "get", "pods", "-v=6"},
+			remaining: []string{"kubectl", "get", "pods", "-v=6"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-v=5", "get", "pods", "-v=6"},
+			expected:  Flags{Verbosity: "5"},
+			remaining: []string{"kubectl", "get", "pods", "-v=6"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-v", "5", "--context", "prod", "--dispatcher-config", "/etc/dispatcher.yaml", "get", "pods"},
+			expected:  Flags{Verbosity: "5", ConfigFile: "/etc/dispatcher.yaml"},
+			remaining: []string{"kubectl", "--context", "prod", "get", "pods"},
+		},
+		{
+			args:      []string{"kubectl", "-n", "kube-system", "--insecure-skip-tls-verify", "--dispatcher-strict", "get", "pods"},
+			expected:  Flags{Strict: true},
+			remaining: []string{"kubectl", "-n", "kube-system", "--insecure-skip-tls-verify", "get", "pods"},
+		},
+		// Dispatcher flags may follow a builtin command, up to "--".
+		{
+			args:      []string{"kubectl", "get", "pods", "--dispatcher-dry-run", "-o", "wide"},
+			expected:  Flags{DryRun: true},
+			remaining: []string{"kubectl", "get", "pods", "-o", "wide"},
+		},
+		{
+			args:      []string{"kubectl", "--context", "prod", "apply", "-f", "-", "--dispatcher-v", "5"},
+			expected:  Flags{Verbosity: "5"},
+			remaining: []string{"kubectl", "--context", "prod", "apply", "-f", "-"},
+		},
+		{
+			args:      []string{"kubectl", "exec", "pod", "--dispatcher-strict", "--", "kubectl", "--dispatcher-v=5"},
+			expected:  Flags{Strict: true},
+			remaining: []string{"kubectl", "exec", "pod", "--", "kubectl", "--dispatcher-v=5"},
+		},
+		{args: []string{"kubectl", "get", "pods", "--dispatcher-verbose"}, expectError: true},
+		// Arguments after a plugin name belong to the plugin.
+		{
+			args:      []string{"kubectl", "my-plugin", "--dispatcher-v=5"},
+			remaining: []string{"kubectl", "my-plugin", "--dispatcher-v=5"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-dry-run", "my-plugin", "--dispatcher-unknown", "--dispatcher-v=high"},
+			expected:  Flags{DryRun: true},
+			remaining: []string{"kubectl", "my-plugin", "--dispatcher-unknown", "--dispatcher-v=high"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-version", "--dispatcher-dry-run=true", "version"},
+			expected:  Flags{Version: true, DryRun: true},
+			remaining: []string{"kubectl", "version"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-strict", "get", "pods"},
+			expected:  Flags{Strict: true},
+			remaining: []string{"kubectl", "get", "pods"},
+		},
+		{
+			args:      []string{"kubectl", "--dispatcher-dry-run=false", "version"},
+			remaining: []string{"kubectl", "version"},
+		},
+		// Arguments after "--" are for the command run by kubectl.
+		{
+			args:      []string{"kubectl", "--dispatcher-dry-run", "--", "--dispatcher-v=5"},
+			expected:  Flags{DryRun: true},
+			remaining: []string{"kubectl", "--", "--dispatcher-v=5"},
+		},
+		{args: []string{"kubectl", "--dispatcher-verbose", "get"}, expectError: true},
+		{args: []string{"kubectl", "--dispatcher-v=high", "get"}, expectError: true},
+		{args: []string{"kubectl", "--dispatcher-config"}, expectError: true},
+		{args: []string{"kubectl", "--dispatcher-dry-run=maybe"}, expectError: true},
+		{args: []string{}, remaining: []string{}},
+	}
+	for _, test := range tests {
+		flags, remaining, err := ParseFlags(test.args)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error parsing (%v); received none", test.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing (%v): %v", test.args, err)
+			continue
+		}
+		if test.expected != *flags {
+			t.Errorf("ParseFlags(%v) flags error: expected (%+v), got (%+v)", test.args, test.expected, *flags)
+		}
+		if !isStringSliceEqual(test.remaining, remaining) {
+			t.Errorf("ParseFlags(%v) args error: expected (%v), got (%v)", test.args, test.remaining, remaining)
+		}
+	}
+}
+
+func TestFlagsEnv(t *testing.T) {
+	env := (&Flags{}).Env([]string{"FOO=bar"})
+	if !isStringSliceEqual([]string{"FOO=bar"}, env) {
+		t.Errorf("Flags.Env() error: expected (FOO=bar), got (%v)", env)
+	}
+	env = (&Flags{ConfigFile: "/etc/dispatcher.yaml"}).Env([]string{"FOO=bar"})
+	if value, _ := config.LookupEnv(env, config.ConfigFileEnv); value != "/etc/dispatcher.yaml" {
+		t.Errorf("Flags.Env() config file error: expected (/etc/dispatcher.yaml), got (%v)", env)
+	}
+	env = (&Flags{Strict: true}).Env([]string{"FOO=bar"})
+	if !config.NewConfig(env).Strict {
+		t.Errorf("Flags.Env() strict error: got (%v)", env)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12")
+	defer os.RemoveAll(tmp)
+	var out bytes.Buffer
+	dispatcher := NewDispatcher([]string{"kubectl", "get", "pods"}, env, clientVersion, builder)
+	dispatcher.execFunc = printExec(&out)
+	if err := dispatcher.dispatchTo(dispatcher.Candidates(&version.Info{Major: "1", Minor: "12"})); err != nil {
+		t.Errorf("Unexpected error in dry run: %v", err)
+	}
+	expected := gofilepath.Join(tmp, "bin", "kubectl.1.12") + " get pods"
+	if actual := strings.TrimSpace(out.String()); expected != actual {
+		t.Errorf("Dry run output error: expected (%s), got (%s)", expected, actual)
+	}
+}
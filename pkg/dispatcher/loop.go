@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+)
+
+const (
+	// DepthEnv is set to the number of dispatchers a kubectl command has
+	// passed through, so a dispatcher which executes itself (e.g. through a
+	// wrapper script) is detected.
+	DepthEnv = "KUBECTL_DISPATCHER_DEPTH"
+	// maxDepth allows kubectl plugins to run kubectl in turn, and a
+	// dispatcher to fall back to another dispatcher installation (e.g. on
+	// the PATH), but not to loop.
+	maxDepth = 10
+)
+
+// LoopError is returned when dispatching would execute the dispatcher
+// again, instead of a real kubectl binary.
+type LoopError struct {
+	Path   string
+	Reason string
+}
+
+func (e *LoopError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("kubectl dispatch loop: %s", e.Reason)
+	}
+	return fmt.Sprintf("kubectl dispatch loop: %s %s", e.Path, e.Reason)
+}
+
+// depth returns the number of dispatchers this command has already passed
+// through, according to DepthEnv.
+func (d *Dispatcher) depth() int {
+	value, ok := config.LookupEnv(d.GetEnv(), DepthEnv)
+	if !ok {
+		return 0
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 0 {
+		return 0
+	}
+	return depth
+}
+
+// checkDepth returns a LoopError once the command has passed through too
+// many dispatchers, which happens if a kubectl binary runs the dispatcher in
+// a way checkNotSelf can not detect (e.g. a wrapper script).
+func (d *Dispatcher) checkDepth() error {
+	if depth := d.depth(); depth >= maxDepth {
+		return &LoopError{Reason: fmt.Sprintf("already dispatched %d times (%s=%d); is a kubectl binary a wrapper for the dispatcher?", depth, DepthEnv, depth)}
+	}
+	return nil
+}
+
+// dispatchEnv returns the environment for the dispatched binary, with the
+// depth incremented.
+func (d *Dispatcher) dispatchEnv() []string {
+	env := []string{}
+	for _, e := range d.GetEnv() {
+		if strings.HasPrefix(e, DepthEnv+"=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	return append(env, fmt.Sprintf("%s=%d", DepthEnv, d.depth()+1))
+}
+
+// checkNotSelf returns a LoopError if the opened binary at "path" is the
+// dispatcher itself: the same file (e.g. through a symbolic or hard link),
+// or an identical copy.
+func (d *Dispatcher) checkNotSelf(path string, f *os.File, fi os.FileInfo) error {
+	self, err := d.executable()
+	if err != nil {
+		return nil
+	}
+	selfInfo, err := os.Stat(self)
+	if err != nil {
+		return nil
+	}
+	if os.SameFile(fi, selfInfo) {
+		return &LoopError{Path: path, Reason: fmt.Sprintf("is the dispatcher (%s)", self)}
+	}
+	if fi.Size() != selfInfo.Size() {
+		return nil
+	}
+	_, selfDigest, err := util.FileDigest(self)
+	if err != nil {
+		return nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, fi.Size())); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) == selfDigest {
+		return &LoopError{Path: path, Reason: fmt.Sprintf("is a copy of the dispatcher (%s)", self)}
+	}
+	return nil
+}
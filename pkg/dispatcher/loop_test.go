@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	gofilepath "path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestCheckDepth(t *testing.T) {
+	tests := []struct {
+		env         []string
+		expectError bool
+	}{
+		{env: []string{}},
+		{env: []string{DepthEnv + "=1"}},
+		{env: []string{DepthEnv + "=9"}},
+		{env: []string{DepthEnv + "=10"}, expectError: true},
+		{env: []string{DepthEnv + "=10", DepthEnv + "=0"}},
+		{env: []string{DepthEnv + "=bad"}},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher([]string{"kubectl"}, test.env, clientVersion, nil)
+		err := dispatcher.checkDepth()
+		if _, ok := err.(*LoopError); test.expectError != ok {
+			t.Errorf("checkDepth(%v) error: expected loop error (%t), got (%v)", test.env, test.expectError, err)
+		}
+	}
+}
+
+func TestDispatchEnv(t *testing.T) {
+	tests := []struct {
+		env      []string
+		expected []string
+	}{
+		{env: []string{"FOO=bar"}, expected: []string{"FOO=bar", DepthEnv + "=1"}},
+		{env: []string{DepthEnv + "=1", "FOO=bar", DepthEnv + "=2"}, expected: []string{"FOO=bar", DepthEnv + "=3"}},
+	}
+	for _, test := range tests {
+		dispatcher := NewDispatcher([]string{"kubectl"}, test.env, clientVersion, nil)
+		if actual := dispatcher.dispatchEnv(); !isStringSliceEqual(test.expected, actual) {
+			t.Errorf("dispatchEnv(%v) error: expected (%v), got (%v)", test.env, test.expected, actual)
+		}
+	}
+}
+
+// A kubectl plugin may run kubectl, which is dispatched again, from within
+// a dispatched kubectl, but a versioned binary which runs the dispatcher
+// again (e.g. a wrapper script) is a loop.
+func TestDispatchNesting(t *testing.T) {
+	tests := []struct {
+		name       string
+		nesting    int
+		expectLoop bool
+	}{
+		{name: "plugins", nesting: maxDepth},
+		{name: "wrapper", nesting: 2 * maxDepth, expectLoop: true},
+	}
+	for _, test := range tests {
+		env := []string{"FOO=bar"}
+		var err error
+		for nesting := 0; nesting < test.nesting && err == nil; nesting++ {
+			dispatcher := NewDispatcher([]string{"kubectl", "plugin-" + strconv.Itoa(nesting)}, env, clientVersion, nil)
+			if err = dispatcher.checkDepth(); err == nil {
+				env = dispatcher.dispatchEnv()
+			}
+		}
+		if _, ok := err.(*LoopError); test.expectLoop != ok {
+			t.Errorf("Nested dispatch (%s) error: expected loop error (%t), got (%v)", test.name, test.expectLoop, err)
+		}
+	}
+}
+
+func TestCheckNotSelf(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on Windows")
+	}
+	tmp, err := ioutil.TempDir("", "loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	self := gofilepath.Join(tmp, "kubectl")
+	path := func(name string) string { return gofilepath.Join(tmp, name) }
+	ioutil.WriteFile(self, []byte("#!/bin/sh\necho dispatcher\n"), 0755)
+	ioutil.WriteFile(path("kubectl.1.12"), []byte("#!/bin/sh\necho dispatcher\n"), 0755)
+	ioutil.WriteFile(path("kubectl.1.13"), []byte("#!/bin/sh\necho kubectl.13\n"), 0755)
+	ioutil.WriteFile(path("kubectl.1.14"), []byte("#!/bin/sh\necho kubectl\n"), 0755)
+	os.Symlink(self, path("kubectl.1.15"))
+	os.Link(self, path("kubectl.1.16"))
+
+	tests := []struct {
+		path       string
+		expectLoop bool
+	}{
+		{path: path("kubectl.1.12"), expectLoop: true},
+		// Same size, different contents.
+		{path: path("kubectl.1.13")},
+		{path: path("kubectl.1.14")},
+		{path: path("kubectl.1.15"), expectLoop: true},
+		{path: path("kubectl.1.16"), expectLoop: true},
+	}
+	for _, test := range tests {
+		var executedEnv []string
+		dispatcher := NewDispatcher([]string{"kubectl"}, []string{}, clientVersion, nil)
+		dispatcher.executable = func() (string, error) { return self, nil }
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			executedEnv = envv
+			return fmt.Errorf("exec failed")
+		}
+		err := dispatcher.execKubectl(test.path)
+		if _, ok := err.(*LoopError); test.expectLoop != ok {
+			t.Errorf("execKubectl(%s) error: expected loop error (%t), got (%v)", test.path, test.expectLoop, err)
+		}
+		if test.expectLoop && executedEnv != nil {
+			t.Errorf("execKubectl(%s) executed the dispatcher", test.path)
+		}
+		if !test.expectLoop && !isStringSliceEqual([]string{DepthEnv + "=1"}, executedEnv) {
+			t.Errorf("execKubectl(%s) environment error: expected (%s=1), got (%v)", test.path, DepthEnv, executedEnv)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/resolver"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog"
+)
+
+// versionSourceFunc adapts a function to discovery.ServerVersionInterface.
+type versionSourceFunc func() (*version.Info, error)
+
+func (f versionSourceFunc) ServerVersion() (*version.Info, error) {
+	return f()
+}
+
+// recommendationFunc adapts a function to resolver.RecommendationSource.
+type recommendationFunc func() (*client.Recommendation, error)
+
+func (f recommendationFunc) Recommendation() (*client.Recommendation, error) {
+	return f()
+}
+
+// versionInferrerFunc adapts a function to resolver.VersionInferrer.
+type versionInferrerFunc func() (*version.Info, string, error)
+
+func (f versionInferrerFunc) InferServerVersion() (*version.Info, string, error) {
+	return f()
+}
+
+// resolveServerVersion returns the server version of the first version
+// resolver to answer, accepting a cached version up to the passed age in
+// seconds. How each resolver answered is kept for the Decision.
+func (d *Dispatcher) resolveServerVersion(cacheMaxAge uint64) (*version.Info, error) {
+	chain := d.resolvers
+	if chain == nil {
+		chain = d.versionResolvers(cacheMaxAge)
+	}
+	serverVersion, reports, err := chain.Resolve()
+	d.resolutions = reports
+	for _, report := range reports {
+		klog.V(3).Infof("Version resolver %s", report)
+	}
+	if n := len(reports); err == nil && n > 0 && reports[n-1].Resolver == config.ResolverInfer {
+		klog.V(1).Infof("Server version %s is inferred (%s), not reported by the server", serverVersion.GitVersion, reports[n-1].Explanation)
+	}
+	return serverVersion, err
+}
+
+// versionResolvers returns the version resolvers named by the
+// configuration, in order.
+func (d *Dispatcher) versionResolvers(cacheMaxAge uint64) resolver.Chain {
+	cfg := d.configuration()
+	cache := resolver.NewCache(cfg.CacheDir)
+	// The cluster keys the cache. If the kube config is bad, the cache is
+	// skipped, and the probe reports the error.
+	var kubeConfigFlags *genericclioptions.ConfigFlags
+	host := ""
+	// Unless no cluster is selected, the pod's service account is not used.
+	clusterSelected := true
+	if svclient, flags, err := d.newServerVersionClient(); err == nil {
+		kubeConfigFlags = flags
+		if h, err := svclient.Host(); err == nil {
+			host = h
+		}
+		clusterSelected = svclient.SelectsCluster()
+	}
+	// The error of the probe, if any, which tells whether inferring the
+	// version is worth querying the server again.
+	var probeErr error
+	chain := resolver.Chain{}
+	for _, name := range cfg.VersionResolvers {
+		switch name {
+		case config.ResolverEnv:
+			chain = append(chain, &resolver.EnvResolver{Env: d.GetEnv()})
+		case config.ResolverFile:
+			chain = append(chain, &resolver.FileResolver{})
+		case config.ResolverKubeconfig:
+			chain = append(chain, &resolver.KubeconfigResolver{Flags: kubeConfigFlags})
+		case config.ResolverRecommended:
+			recommendation := func() (*client.Recommendation, error) {
+				svclient, _, err := d.newServerVersionClient()
+				if err != nil {
+					return nil, err
+				}
+				svclient.SetCacheMaxAge(cacheMaxAge)
+				return svclient.Recommendation()
+			}
+			probe := func() (*version.Info, error) {
+				return d.serverVersion(cacheMaxAge)
+			}
+			chain = append(chain, &resolver.RecommendedResolver{
+				Source:   recommendationFunc(recommendation),
+				Versions: versionSourceFunc(probe),
+				Cache:    cache,
+				Host:     host,
+				MaxAge:   time.Duration(cacheMaxAge) * time.Second,
+				Now:      d.now,
+			})
+		case config.ResolverCache:
+			chain = append(chain, &resolver.CacheResolver{
+				Cache:  cache,
+				Host:   host,
+				MaxAge: time.Duration(cacheMaxAge) * time.Second,
+				Now:    d.now,
+			})
+		case config.ResolverProbe:
+			probe := func() (*version.Info, error) {
+				info, err := d.serverVersion(cacheMaxAge)
+				probeErr = err
+				return info, err
+			}
+			if cfg.ProbeSamples > 1 {
+				// Each sample may reach another API server.
+				probe = func() (*version.Info, error) {
+					info, err := d.freshServerVersion()
+					probeErr = err
+					return info, err
+				}
+			}
+			chain = append(chain, &resolver.ProbeResolver{
+				Source:  versionSourceFunc(probe),
+				Samples: cfg.ProbeSamples,
+				Cache:   cache,
+				Host:    host,
+				Now:     d.now,
+			})
+		case config.ResolverInfer:
+			infer := func() (*version.Info, string, error) {
+				if probeErr != nil && !client.IsVersionBlocked(probeErr) {
+					return nil, "the server could not be probed", nil
+				}
+				svclient, _, err := d.newServerVersionClient()
+				if err != nil {
+					return nil, "", err
+				}
+				svclient.SetCacheMaxAge(cacheMaxAge)
+				return svclient.InferServerVersion()
+			}
+			chain = append(chain, &resolver.InferResolver{Source: versionInferrerFunc(infer)})
+		case config.ResolverInCluster:
+			chain = append(chain, &resolver.InClusterResolver{
+				Env:               d.GetEnv(),
+				ServiceAccountDir: d.serviceAccountDir,
+				ClusterSelected:   clusterSelected,
+			})
+		}
+	}
+	return chain
+}
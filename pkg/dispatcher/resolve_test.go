@@ -0,0 +1,400 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/resolver"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// fakeResolver answers with a fixed server version, or error, or not at all.
+type fakeResolver struct {
+	name          string
+	serverVersion *version.Info
+	err           error
+}
+
+func (r *fakeResolver) Name() string {
+	return r.name
+}
+
+func (r *fakeResolver) Resolve() (*version.Info, string, error) {
+	return r.serverVersion, "fake", r.err
+}
+
+func TestResolveWithVersionResolvers(t *testing.T) {
+	fs := fakeFileSystem{
+		"/opt/kubectl/kubectl.1.12": true,
+		"/opt/kubectl/kubectl.1.13": true,
+	}
+	env := []string{"PATH=", config.StoreDirEnv + "=/nonexistent/store"}
+	v112 := &version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.1"}
+	v113 := &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}
+
+	tests := []struct {
+		name                string
+		resolvers           []resolver.VersionResolver
+		expectedPath        string
+		expectedResolutions []string
+		expectedErr         func(error) bool
+	}{
+		{
+			name: "pin before probe",
+			resolvers: []resolver.VersionResolver{
+				&fakeResolver{name: config.ResolverEnv},
+				&fakeResolver{name: config.ResolverKubeconfig, serverVersion: v112},
+				&fakeResolver{name: config.ResolverProbe, serverVersion: v113},
+			},
+			expectedPath:        "/opt/kubectl/kubectl.1.12",
+			expectedResolutions: []string{"env: no answer (fake)", "kubeconfig: v1.12.1 (fake)"},
+		},
+		{
+			name: "probe after a failure",
+			resolvers: []resolver.VersionResolver{
+				&fakeResolver{name: config.ResolverKubeconfig, err: fmt.Errorf("bad pin")},
+				&fakeResolver{name: config.ResolverProbe, serverVersion: v113},
+			},
+			expectedPath:        "/opt/kubectl/kubectl.1.13",
+			expectedResolutions: []string{"kubeconfig: error: bad pin", "probe: v1.13.4 (fake)"},
+		},
+		{
+			name: "no answer",
+			resolvers: []resolver.VersionResolver{
+				&fakeResolver{name: config.ResolverEnv},
+			},
+			expectedPath:        "/opt/kubectl/kubectl.1.12",
+			expectedResolutions: []string{"env: no answer (fake)"},
+			expectedErr:         resolver.IsNoAnswer,
+		},
+		{
+			name: "unreachable",
+			resolvers: []resolver.VersionResolver{
+				&fakeResolver{name: config.ResolverProbe, err: fmt.Errorf("connection refused")},
+			},
+			expectedPath:        "/opt/kubectl/kubectl.1.12",
+			expectedResolutions: []string{"probe: error: connection refused"},
+			expectedErr:         IsServerUnreachable,
+		},
+	}
+	for _, test := range tests {
+		decision, err := Resolve(
+			WithEnv(env),
+			WithClientVersion(version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.1"}),
+			WithSearchPaths("/opt/kubectl"),
+			WithVersionResolvers(test.resolvers...),
+			WithFileSystem(fs),
+		)
+		if err != nil {
+			t.Errorf("Unexpected error in Resolve(%s): %v", test.name, err)
+			continue
+		}
+		if decision.Path != test.expectedPath {
+			t.Errorf("Resolve(%s) path: expected (%s), got (%s)", test.name, test.expectedPath, decision.Path)
+		}
+		resolutions := []string{}
+		for _, report := range decision.Resolutions {
+			resolutions = append(resolutions, report.String())
+		}
+		if !isStringSliceEqual(test.expectedResolutions, resolutions) {
+			t.Errorf("Resolve(%s) resolutions: expected (%v), got (%v)", test.name, test.expectedResolutions, resolutions)
+		}
+		if test.expectedErr == nil && decision.ServerVersionErr != nil {
+			t.Errorf("Resolve(%s): unexpected server version error (%v)", test.name, decision.ServerVersionErr)
+		}
+		if test.expectedErr != nil && !test.expectedErr(decision.ServerVersionErr) {
+			t.Errorf("Resolve(%s) server version error: got (%v)", test.name, decision.ServerVersionErr)
+		}
+	}
+}
+
+func TestVersionResolvers(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	kubeconfig := filepath.Join(tmp, "config")
+	args := []string{"kubectl", "--kubeconfig=" + kubeconfig, "get", "pods"}
+	tests := []struct {
+		resolvers string
+		expected  []string
+	}{
+		{resolvers: "", expected: config.DefaultVersionResolvers},
+		{resolvers: "probe,env", expected: []string{config.ResolverProbe, config.ResolverEnv}},
+		{resolvers: "cache, in-cluster", expected: []string{config.ResolverCache, config.ResolverInCluster}},
+		{resolvers: "recommended,probe", expected: []string{config.ResolverRecommended, config.ResolverProbe}},
+	}
+	for _, test := range tests {
+		env := []string{config.CacheDirEnv + "=" + tmp, config.VersionResolversEnv + "=" + test.resolvers}
+		d := New(WithArgs(args), WithEnv(env))
+		names := []string{}
+		for _, r := range d.versionResolvers(cacheMaxAge) {
+			names = append(names, r.Name())
+		}
+		if !isStringSliceEqual(test.expected, names) {
+			t.Errorf("versionResolvers(%s): expected (%v), got (%v)", test.resolvers, test.expected, names)
+		}
+	}
+
+	// The environment override answers before the server is probed, and
+	// the kubeconfig file, which is missing, is never read.
+	env := []string{
+		config.CacheDirEnv + "=" + tmp,
+		config.VersionResolversEnv + "=env,probe",
+		config.ServerVersionEnv + "=1.13",
+	}
+	d := New(WithArgs(args), WithEnv(env))
+	serverVersion, err := d.versionFunc(cacheMaxAge)
+	if err != nil || serverVersion.GitVersion != "1.13" {
+		t.Errorf("Resolved server version: expected (1.13), got (%v, %v)", serverVersion, err)
+	}
+	if len(d.resolutions) != 1 || d.resolutions[0].Resolver != config.ResolverEnv {
+		t.Errorf("Resolutions: expected env only, got (%v)", d.resolutions)
+	}
+}
+
+func TestInferServerVersion(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// The server hides /version, but not discovery.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind": "APIGroupList", "groups": [{"name": "flowcontrol.apiserver.k8s.io", "versions": [
+		  {"groupVersion": "flowcontrol.apiserver.k8s.io/v1beta3", "version": "v1beta3"},
+		  {"groupVersion": "flowcontrol.apiserver.k8s.io/v1beta2", "version": "v1beta2"}]}]}`)
+	}))
+	defer server.Close()
+	// Nothing listens on the address of a closed server.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name                string
+		server              string
+		expected            string
+		expectedExplanation string
+	}{
+		{name: "hidden version", server: server.URL, expected: "v1.27.0", expectedExplanation: "inferred from discovery"},
+		{name: "unreachable", server: closed.URL, expectedExplanation: "could not be probed"},
+	}
+	for _, test := range tests {
+		kubeconfig := filepath.Join(tmp, "config")
+		contents := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: %s
+contexts:
+- name: context
+  context:
+    cluster: cluster
+current-context: context
+`, test.server)
+		if err := ioutil.WriteFile(kubeconfig, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"kubectl", "--kubeconfig=" + kubeconfig, "--cache-dir=" + filepath.Join(tmp, "http-cache"), "get", "pods"}
+		env := []string{config.CacheDirEnv + "=" + tmp, config.VersionResolversEnv + "=probe,infer"}
+		d := New(WithArgs(args), WithEnv(env))
+		serverVersion, _ := d.versionFunc(cacheMaxAge)
+		actual := ""
+		if serverVersion != nil {
+			actual = serverVersion.GitVersion
+		}
+		if actual != test.expected {
+			t.Errorf("Resolved server version (%s): expected (%s), got (%s)", test.name, test.expected, actual)
+		}
+		if len(d.resolutions) != 2 || !strings.Contains(d.resolutions[1].Explanation, test.expectedExplanation) {
+			t.Errorf("Resolutions (%s): expected infer %s, got (%v)", test.name, test.expectedExplanation, d.resolutions)
+		}
+	}
+}
+
+func TestInClusterProbe(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// A fake API server, which only answers the service account.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "13", "gitVersion": "v1.13.4"}`)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceAccountDir := filepath.Join(tmp, "serviceaccount")
+	if err := os.Mkdir(serviceAccountDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(serviceAccountDir, "ca.crt"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(serviceAccountDir, "token"), []byte("sa-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// There is no kubeconfig in the pod.
+	kubeconfig := filepath.Join(tmp, "config")
+	if err := ioutil.WriteFile(kubeconfig, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"kubectl", "--kubeconfig=" + kubeconfig, "--cache-dir=" + filepath.Join(tmp, "http-cache"), "get", "pods"}
+	env := []string{
+		config.CacheDirEnv + "=" + tmp,
+		config.VersionResolversEnv + "=cache,probe,in-cluster",
+		"KUBERNETES_SERVICE_HOST=" + serverURL.Hostname(),
+		"KUBERNETES_SERVICE_PORT=" + serverURL.Port(),
+	}
+	d := New(WithArgs(args), WithEnv(env))
+	d.serviceAccountDir = serviceAccountDir
+	serverVersion, err := d.versionFunc(0)
+	if err != nil || serverVersion.GitVersion != "v1.13.4" {
+		t.Errorf("In-cluster server version: expected (v1.13.4), got (%v, %v)", serverVersion, err)
+	}
+	if len(d.resolutions) != 2 || d.resolutions[1].Resolver != config.ResolverProbe {
+		t.Errorf("In-cluster resolutions: expected the probe to answer, got (%v)", d.resolutions)
+	}
+	// The version is cached for the in-cluster server.
+	d = New(WithArgs(args), WithEnv(env))
+	d.serviceAccountDir = serviceAccountDir
+	serverVersion, err = d.versionFunc(cacheMaxAge)
+	if err != nil || serverVersion.GitVersion != "v1.13.4" {
+		t.Errorf("Cached in-cluster server version: expected (v1.13.4), got (%v, %v)", serverVersion, err)
+	}
+	if len(d.resolutions) != 1 || d.resolutions[0].Resolver != config.ResolverCache {
+		t.Errorf("Cached in-cluster resolutions: expected the cache to answer, got (%v)", d.resolutions)
+	}
+
+	// A kubeconfig context selects another cluster, whose probe fails: the
+	// pod's API server does not answer for it.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	contents := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: %s
+contexts:
+- name: remote
+  context:
+    cluster: remote
+current-context: remote
+`, closed.URL)
+	if err := ioutil.WriteFile(kubeconfig, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env[1] = config.VersionResolversEnv + "=probe,in-cluster"
+	d = New(WithArgs(args), WithEnv(env))
+	d.serviceAccountDir = serviceAccountDir
+	serverVersion, err = d.versionFunc(0)
+	if err == nil {
+		t.Errorf("Remote server version: expected error, got (%v)", serverVersion)
+	}
+	if len(d.resolutions) != 2 || d.resolutions[1].Resolver != config.ResolverInCluster || d.resolutions[1].Version != nil || d.resolutions[1].Err != nil {
+		t.Errorf("Remote resolutions: expected no in-cluster answer, got (%v)", d.resolutions)
+	}
+}
+
+func TestProbeSamples(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// API servers of mixed versions behind a load balancer.
+	versions := []string{
+		`{"major": "1", "minor": "14", "gitVersion": "v1.14.0"}`,
+		`{"major": "1", "minor": "13", "gitVersion": "v1.13.4"}`,
+	}
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, versions[queries%len(versions)])
+		queries++
+	}))
+	defer server.Close()
+	kubeconfig := filepath.Join(tmp, "config")
+	contents := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: %s
+contexts:
+- name: context
+  context:
+    cluster: cluster
+current-context: context
+`, server.URL)
+	if err := ioutil.WriteFile(kubeconfig, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"kubectl", "--kubeconfig=" + kubeconfig, "--cache-dir=" + filepath.Join(tmp, "http-cache"), "get", "pods"}
+	env := []string{
+		config.CacheDirEnv + "=" + tmp,
+		config.VersionResolversEnv + "=cache,probe",
+		config.ProbeSamplesEnv + "=3",
+	}
+	d := New(WithArgs(args), WithEnv(env))
+	serverVersion, err := d.versionFunc(cacheMaxAge)
+	if err != nil || serverVersion.GitVersion != "v1.13.4" {
+		t.Errorf("Sampled server version: expected the lowest (v1.13.4), got (%v, %v)", serverVersion, err)
+	}
+	if queries != 3 {
+		t.Errorf("Sampled server version: expected (3) queries, got (%d)", queries)
+	}
+	if len(d.resolutions) != 2 || !strings.Contains(d.resolutions[1].Explanation, "v1.14.0, v1.13.4") {
+		t.Errorf("Sampled resolutions: expected mixed versions, got (%v)", d.resolutions)
+	}
+	// The mixed state is cached, briefly.
+	d = New(WithArgs(args), WithEnv(env))
+	if serverVersion, err = d.versionFunc(cacheMaxAge); err != nil || serverVersion.GitVersion != "v1.13.4" {
+		t.Errorf("Cached sampled server version: expected (v1.13.4), got (%v, %v)", serverVersion, err)
+	}
+	if len(d.resolutions) != 1 || !strings.Contains(d.resolutions[0].Explanation, "mixed versions") {
+		t.Errorf("Cached sampled resolutions: expected mixed versions, got (%v)", d.resolutions)
+	}
+}
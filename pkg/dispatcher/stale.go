@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+// Only the end of kubectl's standard error is searched for stale version
+// errors.
+const stderrTailSize = 16 * 1024
+
+// Read-only kubectl commands, which are safe to run a second time.
+var readCommands = map[string]bool{
+	"api-resources": true,
+	"api-versions":  true,
+	"cluster-info":  true,
+	"describe":      true,
+	"explain":       true,
+	"get":           true,
+	"logs":          true,
+	"top":           true,
+	"version":       true,
+}
+
+// Errors kubectl reports when it does not match the server's API, as
+// happens after the cluster has been upgraded.
+var staleVersionErrors = []string{
+	"the server doesn't have a resource type",
+	"no matches for kind",
+	"the server could not find the requested resource",
+	"unable to recognize",
+	"unable to retrieve the complete list of server APIs",
+	"couldn't get resource list for",
+}
+
+// tailWriter keeps the last bytes written to it.
+type tailWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newTailWriter(size int) *tailWriter {
+	return &tailWriter{size: size}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.size {
+		w.buf = w.buf[len(w.buf)-w.size:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.buf)
+}
+
+func (w *tailWriter) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = nil
+}
+
+// isStaleVersionError returns true if kubectl's standard error shows it
+// does not match the server's API.
+func isStaleVersionError(stderr string) bool {
+	for _, e := range staleVersionErrors {
+		if strings.Contains(stderr, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns the builtin kubectl command (e.g. "get"), or the empty
+// string if there is none.
+func (d *Dispatcher) command() string {
+	_, _, commandLine, err := d.parseKubeConfigFlags()
+	if err != nil || commandLine.Plugin || len(commandLine.Command) == 0 {
+		return ""
+	}
+	return commandLine.Command[0]
+}
+
+// prepareRedispatch returns true if kubectl may be dispatched again after
+// failing because of a stale server version: only read-only commands run as
+// a child process qualify. If so, kubectl's standard error is kept.
+func (d *Dispatcher) prepareRedispatch() bool {
+	if d.configuration().ExecMode != config.ExecModeChild || !readCommands[d.command()] {
+		return false
+	}
+	d.stderrTail = newTailWriter(stderrTailSize)
+	return true
+}
+
+// redispatchIfStale dispatches once more if kubectl failed because the
+// server version, which may have come from the cache, is out of date. The
+// server version is queried again, bypassing the cache, and kubectl is only
+// run again if the server version has changed. Otherwise, returns the
+// result of the first dispatch.
+func (d *Dispatcher) redispatchIfStale(serverVersion *version.Info, err error) error {
+	exitErr, ok := err.(*ExitError)
+	if !ok || exitErr.Signal != 0 || serverVersion == nil || !isStaleVersionError(d.stderrTail.String()) {
+		return err
+	}
+	freshVersion, versionErr := d.versionFunc(0)
+	if versionErr != nil {
+		klog.V(3).Infof("Unable to refresh server version: %v", versionErr)
+		return err
+	}
+	if util.VersionMatch(*serverVersion, *freshVersion) {
+		klog.V(3).Infof("Server version %s is current; not dispatching again", freshVersion.GitVersion)
+		return err
+	}
+	klog.Warningf("kubectl failed with server version %s, but the server is now %s; running kubectl again", serverVersion.GitVersion, freshVersion.GitVersion)
+	d.stderrTail = nil
+	return d.strictError(freshVersion, d.dispatchTo(d.Candidates(freshVersion)))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	gofilepath "path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestTailWriter(t *testing.T) {
+	w := newTailWriter(5)
+	w.Write([]byte("abc"))
+	w.Write([]byte("defg"))
+	if actual := w.String(); actual != "cdefg" {
+		t.Errorf("tailWriter error: expected (cdefg), got (%s)", actual)
+	}
+	w.Reset()
+	if actual := w.String(); actual != "" {
+		t.Errorf("tailWriter reset error: expected empty, got (%s)", actual)
+	}
+}
+
+func TestRedispatchIfStale(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12", "1.13")
+	defer os.RemoveAll(tmp)
+	exe112 := gofilepath.Join(tmp, "bin", "kubectl.1.12")
+	exe113 := gofilepath.Join(tmp, "bin", "kubectl.1.13")
+	staleError := `error: the server doesn't have a resource type "widgets"`
+
+	tests := []struct {
+		args         []string
+		execMode     string
+		freshVersion string
+		stderr       string
+		expected     []string
+	}{
+		// The server was upgraded since its version was cached.
+		{args: []string{"kubectl", "get", "widgets"}, freshVersion: "1.13", stderr: staleError, expected: []string{exe112, exe113}},
+		{args: []string{"kubectl", "-n", "default", "get", "widgets"}, freshVersion: "1.13", stderr: staleError, expected: []string{exe112, exe113}},
+		// The server version is current, so kubectl would fail again.
+		{args: []string{"kubectl", "get", "widgets"}, freshVersion: "1.12", stderr: staleError, expected: []string{exe112}},
+		{args: []string{"kubectl", "get", "widgets"}, freshVersion: "1.13", stderr: "error: connection refused", expected: []string{exe112}},
+		// Commands which change the cluster are never run twice.
+		{args: []string{"kubectl", "apply", "-f", "widget.yaml"}, freshVersion: "1.13", stderr: staleError, expected: []string{exe112}},
+		{args: []string{"kubectl", "get", "widgets"}, execMode: config.ExecModeExec, freshVersion: "1.13", stderr: staleError, expected: []string{exe112}},
+	}
+	for _, test := range tests {
+		execMode := config.ExecModeChild
+		if test.execMode != "" {
+			execMode = test.execMode
+		}
+		dispatcher := NewDispatcher(test.args, append(env, config.ExecModeEnv+"="+execMode), clientVersion, builder)
+		dispatcher.versionFunc = func(cacheMaxAge uint64) (*version.Info, error) {
+			if cacheMaxAge == 0 {
+				return &version.Info{Major: "1", Minor: test.freshVersion[2:], GitVersion: "v" + test.freshVersion + ".0"}, nil
+			}
+			return &version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.0"}, nil
+		}
+		executed := []string{}
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			executed = append(executed, path)
+			if len(executed) > 1 {
+				return nil
+			}
+			if dispatcher.stderrTail != nil {
+				fmt.Fprintln(dispatcher.stderrTail, test.stderr)
+			}
+			return &ExitError{Path: path, Code: 1}
+		}
+		err := dispatcher.Dispatch()
+		if !isStringSliceEqual(test.expected, executed) {
+			t.Errorf("Dispatch(%v) error: expected (%v), got (%v)", test.args, test.expected, executed)
+		}
+		if _, ok := err.(*ExitError); len(executed) == 1 && !ok {
+			t.Errorf("Dispatch(%v) expected first ExitError, got (%v)", test.args, err)
+		}
+	}
+	// In strict mode, a missing binary for the fresh server version is
+	// reported as for the first dispatch.
+	dispatcher := NewDispatcher([]string{"kubectl", "get", "widgets"}, append(env, config.ExecModeEnv+"="+config.ExecModeChild, config.StrictEnv+"=true"), clientVersion, builder)
+	dispatcher.versionFunc = func(cacheMaxAge uint64) (*version.Info, error) {
+		if cacheMaxAge == 0 {
+			return &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.0"}, nil
+		}
+		return &version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.0"}, nil
+	}
+	dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+		if dispatcher.stderrTail != nil {
+			fmt.Fprintln(dispatcher.stderrTail, staleError)
+		}
+		return &ExitError{Path: path, Code: 1}
+	}
+	if err := dispatcher.Dispatch(); !IsMissingBinary(err) {
+		t.Errorf("Strict stale dispatch: expected MissingBinaryError, got (%T: %v)", err, err)
+	}
+}
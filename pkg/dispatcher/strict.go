@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	gofilepath "path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// ExitCodeMissingBinary is the exit code of the dispatcher in strict mode
+// when the kubectl binary matching the server version is missing
+// (EX_UNAVAILABLE in sysexits.h).
+const ExitCodeMissingBinary = 69
+
+// MissingBinaryError is returned in strict mode when the kubectl binary
+// matching the server version is missing. It explains where the binary was
+// looked for, and how to install it.
+type MissingBinaryError struct {
+	ServerVersion version.Info
+	// File is the missing binary in the first search path.
+	File        string
+	SearchPaths []string
+}
+
+func (e *MissingBinaryError) Error() string {
+	majorMinor, _ := util.MajorMinor(e.ServerVersion)
+	lines := []string{
+		fmt.Sprintf("strict mode: no kubectl %s for server version %s; %s not found in:", majorMinor, e.ServerVersion.GitVersion, gofilepath.Base(e.File)),
+	}
+	for _, path := range e.SearchPaths {
+		lines = append(lines, "  "+path)
+	}
+	lines = append(lines,
+		"Install it with \"kubectl dispatcher bundle import\", or download it:",
+		fmt.Sprintf("  curl -Lo %s %s && chmod +x %s", e.File, downloadURL(e.ServerVersion), e.File))
+	return strings.Join(lines, "\n")
+}
+
+// IsMissingBinary returns true if the error is a MissingBinaryError.
+func IsMissingBinary(err error) bool {
+	_, ok := err.(*MissingBinaryError)
+	return ok
+}
+
+// downloadURL returns the URL of the upstream kubectl release for the
+// server version (without a vendor suffix such as "-gke.1").
+func downloadURL(v version.Info) string {
+	release := v.GitVersion
+	if end := strings.IndexAny(release, "-+"); end >= 0 {
+		release = release[:end]
+	}
+	if release == "" {
+		majorMinor, _ := util.MajorMinor(v)
+		release = "v" + majorMinor + ".0"
+	}
+	return fmt.Sprintf("https://dl.k8s.io/release/%s/bin/%s/%s/%s", release, runtime.GOOS, runtime.GOARCH, kubectlName())
+}
+
+// strictError returns a MissingBinaryError in strict mode, instead of the
+// DispatchError for a known server version whose binary is missing.
+// Otherwise, the error is returned unchanged.
+func (d *Dispatcher) strictError(serverVersion *version.Info, err error) error {
+	cfg := d.configuration()
+	dispatchErr, ok := err.(*DispatchError)
+	if !ok || serverVersion == nil || !cfg.Strict {
+		return err
+	}
+	if len(dispatchErr.Attempts) != 1 || !IsBinaryNotFound(dispatchErr.Attempts[0].Err) {
+		return err
+	}
+	missingErr := &MissingBinaryError{ServerVersion: *serverVersion}
+	for _, builder := range d.filepathBuilders {
+		if path, pathErr := builder.VersionedFilePath(*serverVersion); pathErr == nil {
+			if missingErr.File == "" {
+				missingErr.File = path
+			}
+			missingErr.SearchPaths = append(missingErr.SearchPaths, gofilepath.Dir(path))
+		}
+	}
+	for _, layout := range cfg.Layouts {
+		missingErr.SearchPaths = append(missingErr.SearchPaths, layout+" (layout)")
+	}
+	missingErr.SearchPaths = append(missingErr.SearchPaths, store.NewStore(cfg.StoreDir).Dir())
+	if cfg.OCILayout != "" {
+		missingErr.SearchPaths = append(missingErr.SearchPaths, cfg.OCILayout+" (OCI image layout)")
+	}
+	return missingErr
+}
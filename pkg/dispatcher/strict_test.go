@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"os"
+	gofilepath "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestStrictDispatch(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12", "1.13")
+	defer os.RemoveAll(tmp)
+	env = append(env, config.StrictEnv+"=true")
+
+	tests := []struct {
+		serverVersion *version.Info
+		expectedExec  []string
+		expectMissing bool
+	}{
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"},
+			expectedExec:  []string{gofilepath.Join(tmp, "bin", "kubectl.1.13")},
+		},
+		// 1.12 is within the skew, but strict mode requires 1.14.
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.1-gke.2"},
+			expectedExec:  []string{},
+			expectMissing: true,
+		},
+		// An unknown server version still falls back to the default.
+		{
+			expectedExec: []string{gofilepath.Join(tmp, "path1", kubectlName())},
+		},
+	}
+	for _, test := range tests {
+		executed := []string{}
+		dispatcher := NewDispatcher([]string{"kubectl", "get", "pods"}, env, clientVersion, builder)
+		dispatcher.versionFunc = func(uint64) (*version.Info, error) {
+			if test.serverVersion == nil {
+				return nil, &ServerUnreachableError{}
+			}
+			return test.serverVersion, nil
+		}
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			executed = append(executed, path)
+			return nil
+		}
+		err := dispatcher.Dispatch()
+		if test.expectMissing != IsMissingBinary(err) {
+			t.Errorf("Strict dispatch error: expected missing binary (%t), got (%v)", test.expectMissing, err)
+		}
+		if !isStringSliceEqual(test.expectedExec, executed) {
+			t.Errorf("Strict dispatch error: expected (%v), got (%v)", test.expectedExec, executed)
+		}
+	}
+}
+
+func TestMissingBinaryError(t *testing.T) {
+	tmp, builder, env := setupCandidates(t)
+	defer os.RemoveAll(tmp)
+	layout := gofilepath.Join(tmp, "kubernetes", "{{.Version}}", "kubectl")
+	env = append(env, config.StrictEnv+"=true", config.LayoutsEnv+"="+layout)
+	dispatcher := NewDispatcher([]string{"kubectl"}, env, clientVersion, builder)
+	dispatcher.versionFunc = func(uint64) (*version.Info, error) {
+		return &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.1-gke.2"}, nil
+	}
+	_, err := dispatcher.Decide()
+	if !IsMissingBinary(err) {
+		t.Fatalf("Expected MissingBinaryError, got (%v)", err)
+	}
+	missing := gofilepath.Join(tmp, "bin", "kubectl.1.14")
+	for _, expected := range []string{
+		"no kubectl 1.14 for server version v1.14.1-gke.2; kubectl.1.14 not found in:",
+		"\n  " + gofilepath.Join(tmp, "bin") + "\n",
+		"\n  " + layout + " (layout)\n",
+		"\n  " + gofilepath.Join(tmp, "store") + "\n",
+		"kubectl dispatcher bundle import",
+		"curl -Lo " + missing + " https://dl.k8s.io/release/v1.14.1/bin/",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain (%s), got (%s)", expected, err)
+		}
+	}
+}
+
+func TestOutOfSkewWarning(t *testing.T) {
+	tmp, builder, env := setupCandidates(t, "1.12")
+	defer os.RemoveAll(tmp)
+	warning := "kubectl dispatcher: warning: no kubectl within the supported skew of the server version; using "
+
+	tests := []struct {
+		serverVersion *version.Info
+		env           []string
+		expected      string
+	}{
+		// Exact match, and nearest in skew.
+		{serverVersion: &version.Info{Major: "1", Minor: "12"}},
+		{serverVersion: &version.Info{Major: "1", Minor: "13"}},
+		// The default 1.11 is missing.
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "15"},
+			expected:      warning + "PATH (" + gofilepath.Join(tmp, "path1", kubectlName()) + ")\n",
+		},
+		{
+			serverVersion: &version.Info{Major: "1", Minor: "15"},
+			env:           []string{config.DefaultVersionEnv + "=1.12"},
+			expected:      warning + "default (kubectl 1.12)\n",
+		},
+		// No warning for an unknown server version.
+		{},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		dispatcher := NewDispatcher([]string{"kubectl"}, append(env, test.env...), clientVersion, builder)
+		dispatcher.stderr = &stderr
+		dispatcher.execFunc = func(f *os.File, path string, argv []string, envv []string) error {
+			return nil
+		}
+		dispatcher.dispatchTo(dispatcher.Candidates(test.serverVersion))
+		if test.expected != stderr.String() {
+			t.Errorf("Out of skew warning error for (%v): expected (%q), got (%q)", test.serverVersion, test.expected, stderr.String())
+		}
+	}
+}
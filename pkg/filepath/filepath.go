@@ -57,6 +57,25 @@ func (e *ExeDirGetter) GetOS() string {
 	return runtime.GOOS
 }
 
+// FixedDirGetter implements the DirectoryGetter interface for an explicitly
+// configured directory, such as an install target or the managed store.
+type FixedDirGetter struct {
+	Dir string
+}
+
+// CurrentDirectory returns the configured directory.
+func (f *FixedDirGetter) CurrentDirectory() (string, error) {
+	if f.Dir == "" {
+		return "", fmt.Errorf("FixedDirGetter: directory is empty")
+	}
+	return f.Dir, nil
+}
+
+// GetOS returns the current operating system as a string.
+func (f *FixedDirGetter) GetOS() string {
+	return runtime.GOOS
+}
+
 // FilepathBuilder encapsulates the data and functionality to build the full
 // versioned kubectl filepath from the server version.
 type FilepathBuilder struct {
@@ -76,6 +95,14 @@ func NewFilepathBuilder(dirGetter DirectoryGetter, filestat func(string) (os.Fil
 
 const kubectlBinaryName = "kubectl"
 
+// Dir returns the directory holding the versioned binaries.
+func (c *FilepathBuilder) Dir() (string, error) {
+	if c.dirGetter == nil {
+		return "", fmt.Errorf("no directory getter")
+	}
+	return c.dirGetter.CurrentDirectory()
+}
+
 // VersionedFilePath returns the full absolute file path to the versioned kubectl
 // binary to dispatch to. On error, empty string is returned.
 func (c *FilepathBuilder) VersionedFilePath(version version.Info) (string, error) {
@@ -83,6 +110,21 @@ func (c *FilepathBuilder) VersionedFilePath(version version.Info) (string, error
 	if c.dirGetter == nil {
 		return "", fmt.Errorf("VersionedFilePath: directory getter is nil")
 	}
+	kubectlFilename, err := VersionedFilename(version, c.dirGetter.GetOS())
+	if err != nil {
+		return "", err
+	}
+	currentDir, err := c.dirGetter.CurrentDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(currentDir, kubectlFilename), nil
+}
+
+// VersionedFilename returns the file name of the versioned kubectl binary
+// for the operating system (e.g. "kubectl.1.12", or "kubectl.1.12.exe" on
+// windows).
+func VersionedFilename(version version.Info, goos string) (string, error) {
 	// Get the major and minor versions.
 	major, err := util.GetMajorVersion(version)
 	if err != nil {
@@ -93,17 +135,28 @@ func (c *FilepathBuilder) VersionedFilePath(version version.Info) (string, error
 		return "", err
 	}
 	// Example: major: "1", minor: "12" -> "kubectl.1.12"
-	kubectlFilename := fmt.Sprintf("%s.%d.%d", kubectlBinaryName, major, minor)
-	if c.dirGetter.GetOS() == windowsOS {
-		kubectlFilename += ".exe"
-	}
-	currentDir, err := c.dirGetter.CurrentDirectory()
-	if err != nil {
-		return "", err
+	return fmt.Sprintf("%s.%d.%d%s", kubectlBinaryName, major, minor, ExeSuffix(goos)), nil
+}
+
+// ExeSuffix returns the file name suffix of executables on the operating
+// system: ".exe" on windows, and empty otherwise.
+func ExeSuffix(goos string) string {
+	if goos == windowsOS {
+		return ".exe"
 	}
-	return filepath.Join(currentDir, kubectlFilename), nil
+	return ""
+}
+
+// Stat returns the FileInfo of the versioned kubectl binary at the file
+// path, or the error of ValidateFilepath.
+func (c *FilepathBuilder) Stat(filepath string) (os.FileInfo, error) {
+	return c.filestatFunc(filepath)
 }
 
+// ValidateFilepath returns an error if the versioned kubectl binary at the
+// file path can not be dispatched to, as determined by the stat function.
+// With StatExecutable, a binary which exists but can not run on this host
+// returns a RejectedError with the reason.
 func (c *FilepathBuilder) ValidateFilepath(filepath string) error {
 	if _, err := c.filestatFunc(filepath); err != nil {
 		return err
@@ -227,3 +227,44 @@ func TestValidateFilepath(t *testing.T) {
 		}
 	}
 }
+
+func TestFixedDirGetter(t *testing.T) {
+	builder := NewFilepathBuilder(&FixedDirGetter{Dir: "/opt/kubectl"}, nil)
+	filePath, err := builder.VersionedFilePath(createServerVersion("1", "12"))
+	if err != nil {
+		t.Fatalf("Unexpected error: (%v)", err)
+	}
+	expected := "/opt/kubectl/kubectl.1.12"
+	if windowsOS == (&FixedDirGetter{}).GetOS() {
+		expected += ".exe"
+	}
+	if filePath != expected {
+		t.Errorf("Expected versioned file path (%s), got (%s)", expected, filePath)
+	}
+	if _, err := (&FixedDirGetter{}).CurrentDirectory(); err == nil {
+		t.Errorf("Expected error for empty fixed directory; received none")
+	}
+}
+
+func TestVersionedFilename(t *testing.T) {
+	tests := []struct {
+		version     version.Info
+		goos        string
+		expected    string
+		expectError bool
+	}{
+		{version: createServerVersion("1", "12"), goos: "linux", expected: "kubectl.1.12"},
+		{version: createServerVersion("1", "12+"), goos: "darwin", expected: "kubectl.1.12"},
+		{version: createServerVersion("1", "13"), goos: "windows", expected: "kubectl.1.13.exe"},
+		{version: createServerVersion("1", ""), goos: "linux", expectError: true},
+	}
+	for _, test := range tests {
+		actual, err := VersionedFilename(test.version, test.goos)
+		if test.expectError != (err != nil) {
+			t.Errorf("VersionedFilename(%v, %s) error: expected error (%t), got (%v)", test.version, test.goos, test.expectError, err)
+		}
+		if actual != test.expected {
+			t.Errorf("VersionedFilename(%v, %s): expected (%s), got (%s)", test.version, test.goos, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// InsecureError is returned for a kubectl binary which someone other than
+// the current user (or root) could replace.
+type InsecureError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InsecureError) Error() string {
+	return fmt.Sprintf("insecure kubectl binary location %s: %s", e.Path, e.Reason)
+}
+
+// IsInsecure returns true if the error is an InsecureError.
+func IsInsecure(err error) bool {
+	_, ok := err.(*InsecureError)
+	return ok
+}
+
+// CheckSecure returns an InsecureError if the kubectl binary at "path", or
+// any directory above it, is owned by a user other than root or the current
+// user, or is writable by group or others. This is the same check as the
+// sshd StrictModes option, since the dispatcher picks binaries by file name,
+// and anyone able to write one of these would take over every kubectl call.
+// Directories above a symbolic link, and above its target, are both checked.
+// The passed FileInfo (e.g. of an already opened binary) is checked instead
+// of the file at "path", unless it is nil. Always succeeds on Windows.
+func CheckSecure(path string, fi os.FileInfo) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return err
+	}
+	if fi == nil {
+		if fi, err = os.Stat(real); err != nil {
+			return err
+		}
+	}
+	if err := checkOwnerAndMode(real, fi); err != nil {
+		return err
+	}
+	checked := map[string]bool{}
+	for _, file := range []string{abs, real} {
+		for dir := filepath.Dir(file); !checked[dir]; dir = filepath.Dir(dir) {
+			checked[dir] = true
+			dirInfo, err := os.Stat(dir)
+			if err != nil {
+				return err
+			}
+			if err := checkOwnerAndMode(dir, dirInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
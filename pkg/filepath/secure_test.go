@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCheckSecure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("owner and mode checks are unix specific")
+	}
+	dir, err := ioutil.TempDir("", "secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secure := filepath.Join(dir, "secure")
+	open := filepath.Join(dir, "open")
+	os.Mkdir(secure, 0755)
+	os.Mkdir(open, 0777)
+	// Mkdir applies the umask.
+	os.Chmod(open, 0777)
+	files := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{path: filepath.Join(secure, "kubectl.1.11"), mode: 0755},
+		{path: filepath.Join(secure, "kubectl.1.12"), mode: 0775},
+		{path: filepath.Join(secure, "kubectl.1.13"), mode: 0757},
+		{path: filepath.Join(open, "kubectl.1.14"), mode: 0755},
+	}
+	for _, f := range files {
+		ioutil.WriteFile(f.path, []byte("#!/bin/sh\n"), f.mode)
+		os.Chmod(f.path, f.mode)
+	}
+	// Both a symbolic link in an insecure directory, and a secure link to
+	// a binary in an insecure directory, are insecure.
+	os.Symlink(filepath.Join(secure, "kubectl.1.11"), filepath.Join(open, "kubectl.1.15"))
+	os.Symlink(filepath.Join(open, "kubectl.1.14"), filepath.Join(secure, "kubectl.1.16"))
+	os.Symlink(filepath.Join(secure, "kubectl.1.11"), filepath.Join(secure, "kubectl.1.17"))
+
+	tests := []struct {
+		path           string
+		expectInsecure bool
+		expectError    bool
+	}{
+		{path: filepath.Join(secure, "kubectl.1.11")},
+		{path: filepath.Join(secure, "kubectl.1.12"), expectInsecure: true},
+		{path: filepath.Join(secure, "kubectl.1.13"), expectInsecure: true},
+		{path: filepath.Join(open, "kubectl.1.14"), expectInsecure: true},
+		{path: filepath.Join(open, "kubectl.1.15"), expectInsecure: true},
+		{path: filepath.Join(secure, "kubectl.1.16"), expectInsecure: true},
+		{path: filepath.Join(secure, "kubectl.1.17")},
+		{path: filepath.Join(secure, "kubectl.1.18"), expectError: true},
+	}
+	for _, test := range tests {
+		err := CheckSecure(test.path, nil)
+		if test.expectInsecure != IsInsecure(err) || (test.expectError || test.expectInsecure) != (err != nil) {
+			t.Errorf("CheckSecure(%s) error: expected insecure (%t), got (%v)", test.path, test.expectInsecure, err)
+		}
+	}
+
+	// The passed FileInfo is checked instead of the file at the path.
+	fi, _ := os.Stat(filepath.Join(secure, "kubectl.1.12"))
+	if err := CheckSecure(filepath.Join(secure, "kubectl.1.11"), fi); !IsInsecure(err) {
+		t.Errorf("Expected insecure FileInfo to be checked, got (%v)", err)
+	}
+}
+
+func TestCheckSecureOwner(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+	dir, err := ioutil.TempDir("", "secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubectl.1.12")
+	ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)
+	if err := CheckSecure(path, nil); err != nil {
+		t.Errorf("Unexpected error in CheckSecure: %v", err)
+	}
+	// Owned by "nobody".
+	if err := os.Chown(path, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSecure(path, nil); !IsInsecure(err) {
+		t.Errorf("Expected binary owned by another user to be insecure, got (%v)", err)
+	}
+	os.Chown(path, 0, 0)
+	os.Chown(dir, 65534, 65534)
+	if err := CheckSecure(path, nil); !IsInsecure(err) {
+		t.Errorf("Expected directory owned by another user to be insecure, got (%v)", err)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwnerAndMode returns an InsecureError unless the file is owned by root
+// or the current user, and is not writable by group or others. A directory
+// writable by others is allowed if it is owned by root and sticky (e.g.
+// "/tmp"), since then only the owner may remove or rename a file in it.
+func checkOwnerAndMode(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	uid := os.Getuid()
+	if st.Uid != 0 && int(st.Uid) != uid {
+		return &InsecureError{
+			Path:   path,
+			Reason: fmt.Sprintf("owned by uid %d, which is neither root nor the current user (uid %d)", st.Uid, uid),
+		}
+	}
+	mode := fi.Mode()
+	if mode.Perm()&0022 == 0 {
+		return nil
+	}
+	if fi.IsDir() && mode&os.ModeSticky != 0 && st.Uid == 0 {
+		return nil
+	}
+	return &InsecureError{
+		Path:   path,
+		Reason: fmt.Sprintf("writable by group or others (mode %s)", mode),
+	}
+}
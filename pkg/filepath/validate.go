@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/binary"
+)
+
+// RejectedError is returned for a candidate kubectl binary which exists,
+// but which can not be executed on this host.
+type RejectedError struct {
+	Path   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected kubectl candidate %s: %s", e.Path, e.Reason)
+}
+
+// IsRejected returns true if the error is a RejectedError.
+func IsRejected(err error) bool {
+	_, ok := err.(*RejectedError)
+	return ok
+}
+
+// StatExecutable returns the FileInfo of the candidate kubectl binary, or an
+// error if it does not exist, or if it is not a regular file executable by
+// the current user and built for this host. Pass it as the stat function of
+// a FilepathBuilder, so unusable candidates are rejected (with the reason)
+// before exec, instead of failing in syscall.Exec.
+func StatExecutable(path string) (os.FileInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &RejectedError{Path: path, Reason: fmt.Sprintf("not a regular file (mode %s)", fi.Mode())}
+	}
+	if err := checkExecutable(path, fi); err != nil {
+		return nil, &RejectedError{Path: path, Reason: fmt.Sprintf("not executable by the current user (mode %s)", fi.Mode())}
+	}
+	info, err := binary.Inspect(path)
+	if err != nil {
+		return nil, &RejectedError{Path: path, Reason: err.Error()}
+	}
+	if !info.SupportsHost() {
+		return nil, &RejectedError{
+			Path:   path,
+			Reason: fmt.Sprintf("built for %s, but this host is %s/%s", info, runtime.GOOS, runtime.GOARCH),
+		}
+	}
+	return fi, nil
+}
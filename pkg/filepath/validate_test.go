@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// foreignELF returns an ELF header for an architecture other than the host.
+func foreignELF() []byte {
+	machine := elf.EM_AARCH64
+	if runtime.GOARCH == "arm64" {
+		machine = elf.EM_X86_64
+	}
+	header := make([]byte, 64)
+	copy(header, elf.ELFMAG)
+	header[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.LittleEndian.PutUint16(header[18:], uint16(machine))
+	binary.LittleEndian.PutUint32(header[20:], uint32(elf.EV_CURRENT))
+	return header
+}
+
+func TestStatExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("execute permissions and ELF binaries are unix specific")
+	}
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "kubectl.1.10"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "kubectl.1.11"), []byte("#!/bin/sh\n"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "kubectl.1.12"), []byte("#!/bin/sh\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "kubectl.1.13"), foreignELF(), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "kubectl.1.14"), []byte("garbage"), 0755)
+
+	tests := []struct {
+		name           string
+		expectRejected string
+		expectNotExist bool
+	}{
+		{name: "kubectl.1.10", expectRejected: "not a regular file"},
+		{name: "kubectl.1.11"},
+		{name: "kubectl.1.12", expectRejected: "not executable"},
+		{name: "kubectl.1.13", expectRejected: "built for unix/"},
+		{name: "kubectl.1.14", expectRejected: "unrecognized executable format"},
+		{name: "kubectl.1.15", expectNotExist: true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		_, err := StatExecutable(path)
+		switch {
+		case test.expectNotExist:
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: expected not exist error, got (%v)", test.name, err)
+			}
+		case test.expectRejected != "":
+			if !IsRejected(err) || !strings.Contains(err.Error(), test.expectRejected) {
+				t.Errorf("%s: expected rejection (%s), got (%v)", test.name, test.expectRejected, err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+
+	// The builder reports the rejection reason from ValidateFilepath.
+	builder := NewFilepathBuilder(&FixedDirGetter{Dir: dir}, StatExecutable)
+	if err := builder.ValidateFilepath(filepath.Join(dir, "kubectl.1.13")); !IsRejected(err) {
+		t.Errorf("Expected rejection from ValidateFilepath, got (%v)", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkExecutable returns an error if the current user may not execute
+// the file. access(2) accounts for owner, group and other permissions.
+func checkExecutable(path string, fi os.FileInfo) error {
+	return unix.Access(path, unix.X_OK)
+}
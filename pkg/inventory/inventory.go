@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory lists the versioned kubectl binaries the dispatcher
+// would find (see locator.List), and checks that each one really is the version its file name
+// claims, and that it can run on this host.
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/binary"
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+const defaultVersionTimeout = 10 * time.Second
+
+// Entry describes one versioned kubectl binary found by the locators.
+type Entry struct {
+	// Version is the "<major>.<minor>" version given by the file name.
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	// Source is the locator source of the binary (e.g. "store").
+	Source string `json:"source,omitempty"`
+	// ClientVersion is the git version the binary reports for itself.
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Platform      string `json:"platform,omitempty"`
+	SHA256        string `json:"sha256,omitempty"`
+	Compressed    bool   `json:"compressed,omitempty"`
+	// Problems lists everything which would keep the dispatcher from
+	// correctly running this binary. Empty if the binary is usable.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Inventory enumerates versioned kubectl binaries. The version reported
+// by each binary is cached by the binary digest, since running dozens of
+// kubectl binaries is slow.
+type Inventory struct {
+	cacheDir       string
+	versionTimeout time.Duration
+}
+
+// NewInventory returns an Inventory caching reported versions within the
+// passed cache directory.
+func NewInventory(cacheDir string) *Inventory {
+	return &Inventory{
+		cacheDir:       cacheDir,
+		versionTimeout: defaultVersionTimeout,
+	}
+}
+
+// List returns an entry for every versioned kubectl binary in the search
+// directories, in search order. A binary shadowed by one with the same
+// version in an earlier directory is reported as a problem.
+func (inv *Inventory) List(dirs []string) ([]Entry, error) {
+	locators := []locator.BinaryLocator{}
+	for _, dir := range dirs {
+		builder := dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, os.Stat)
+		locators = append(locators, &locator.DirLocator{Builder: builder})
+	}
+	binaries, err := locator.List(locators)
+	if err != nil {
+		return nil, err
+	}
+	return inv.ListBinaries(binaries), nil
+}
+
+// ListBinaries returns an entry for each of the binaries, listed in order
+// of precedence (see locator.List). A binary shadowed by an earlier one
+// with the same version is reported as a problem.
+func (inv *Inventory) ListBinaries(binaries []locator.Binary) []Entry {
+	entries := []Entry{}
+	found := map[string]string{}
+	for _, b := range binaries {
+		e := Entry{Version: b.Version, Path: b.Path, Source: b.Source, Compressed: b.Compressed}
+		inv.check(&e)
+		if path, ok := found[e.Version]; ok {
+			e.Problems = append(e.Problems, fmt.Sprintf("shadowed by %s", path))
+		} else {
+			found[e.Version] = e.Path
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// check fills in the digest, platform, and reported version of the entry,
+// recording any problems found.
+func (inv *Inventory) check(e *Entry) {
+	if e.Source == locator.SourceOCILayout {
+		// Checked when the dispatcher extracts it into the store.
+		return
+	}
+	fi, err := os.Stat(e.Path)
+	if err != nil {
+		e.Problems = append(e.Problems, err.Error())
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		e.Problems = append(e.Problems, "not a regular file")
+		return
+	}
+	_, digest, err := util.FileDigest(e.Path)
+	if err != nil {
+		e.Problems = append(e.Problems, err.Error())
+		return
+	}
+	e.SHA256 = digest
+	if e.Compressed {
+		// Checked when the dispatcher decompresses it into the store.
+		return
+	}
+	runnable := true
+	if runtime.GOOS != "windows" && fi.Mode()&0111 == 0 {
+		e.Problems = append(e.Problems, "not executable")
+		runnable = false
+	}
+	info, err := binary.Inspect(e.Path)
+	if err != nil {
+		e.Problems = append(e.Problems, err.Error())
+		runnable = false
+	} else {
+		e.Platform = info.String()
+		if !info.SupportsHost() {
+			e.Problems = append(e.Problems, fmt.Sprintf("wrong platform %s (host is %s/%s)", info, runtime.GOOS, runtime.GOARCH))
+			runnable = false
+		}
+	}
+	if !runnable {
+		return
+	}
+	clientVersion, err := inv.clientVersion(e.Path, digest)
+	if err != nil {
+		e.Problems = append(e.Problems, fmt.Sprintf("version check failed: %v", err))
+		return
+	}
+	e.ClientVersion = clientVersion.GitVersion
+	if majorMinor, err := util.MajorMinor(*clientVersion); err != nil || majorMinor != e.Version {
+		e.Problems = append(e.Problems, fmt.Sprintf("version mismatch: named %s, reports %s", e.Version, clientVersion.GitVersion))
+	}
+}
+
+// clientVersion returns the version reported by the kubectl binary,
+// running it only if the cache has no version for its digest.
+func (inv *Inventory) clientVersion(path string, digest string) (*version.Info, error) {
+	cacheFile := filepath.Join(inv.cacheDir, "inventory", digest+".json")
+	if contents, err := ioutil.ReadFile(cacheFile); err == nil {
+		var info version.Info
+		if err := json.Unmarshal(contents, &info); err == nil {
+			return &info, nil
+		}
+	}
+	info, err := inv.runClientVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	if contents, err := json.Marshal(info); err == nil {
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err == nil {
+			err = ioutil.WriteFile(cacheFile, contents, 0644)
+		}
+		if err != nil {
+			klog.V(3).Infof("Unable to cache version of %s: %v", path, err)
+		}
+	}
+	return info, nil
+}
+
+func (inv *Inventory) runClientVersion(path string) (*version.Info, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), inv.versionTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "version", "--client", "-o", "json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", inv.versionTimeout)
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var output struct {
+		ClientVersion *version.Info `json:"clientVersion"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil || output.ClientVersion == nil {
+		return nil, fmt.Errorf("unexpected output %q", strings.TrimSpace(stdout.String()))
+	}
+	return output.ClientVersion, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/locator"
+)
+
+// fakeKubectl returns a shell script which reports the passed git version
+// for "version --client -o json", and logs every run to "log".
+func fakeKubectl(gitVersion string, log string) string {
+	parts := strings.SplitN(strings.TrimPrefix(gitVersion, "v"), ".", 3)
+	return fmt.Sprintf(`#!/bin/sh
+echo run >> %s
+echo '{"clientVersion": {"major": "%s", "minor": "%s", "gitVersion": "%s"}}'
+`, log, parts[0], parts[1], gitVersion)
+}
+
+// foreignELF returns an ELF header for an architecture other than the host.
+func foreignELF() []byte {
+	machine := elf.EM_AARCH64
+	if runtime.GOARCH == "arm64" {
+		machine = elf.EM_X86_64
+	}
+	header := make([]byte, 64)
+	copy(header, elf.ELFMAG)
+	header[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.LittleEndian.PutUint16(header[18:], uint16(machine))
+	binary.LittleEndian.PutUint32(header[20:], uint32(elf.EV_CURRENT))
+	return header
+}
+
+func TestList(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake kubectl binaries are shell scripts")
+	}
+	tmp, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	log := filepath.Join(tmp, "runs.log")
+	first := filepath.Join(tmp, "first")
+	second := filepath.Join(tmp, "second")
+	os.Mkdir(first, 0755)
+	os.Mkdir(second, 0755)
+	files := []struct {
+		path     string
+		contents string
+		mode     os.FileMode
+	}{
+		{path: filepath.Join(first, "kubectl.1.12"), contents: fakeKubectl("v1.12.3", log), mode: 0755},
+		{path: filepath.Join(first, "kubectl.1.13"), contents: fakeKubectl("v1.12.9", log), mode: 0755},
+		{path: filepath.Join(first, "kubectl.1.14"), contents: fakeKubectl("v1.14.0", log), mode: 0644},
+		{path: filepath.Join(first, "kubectl.1.15"), contents: string(foreignELF()), mode: 0755},
+		{path: filepath.Join(first, "kubectl.1.16.gz"), contents: "compressed", mode: 0644},
+		{path: filepath.Join(first, "kubectl.1.16.gz.sha256"), contents: "digest", mode: 0644},
+		{path: filepath.Join(first, "kubectl-plugin"), contents: "plugin", mode: 0755},
+		{path: filepath.Join(second, "kubectl.1.12"), contents: fakeKubectl("v1.12.3", log), mode: 0755},
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(f.path, []byte(f.contents), f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []struct {
+		version       string
+		path          string
+		clientVersion string
+		problem       string
+	}{
+		{version: "1.12", path: filepath.Join(first, "kubectl.1.12"), clientVersion: "v1.12.3"},
+		{version: "1.13", path: filepath.Join(first, "kubectl.1.13"), clientVersion: "v1.12.9", problem: "version mismatch"},
+		{version: "1.14", path: filepath.Join(first, "kubectl.1.14"), problem: "not executable"},
+		{version: "1.15", path: filepath.Join(first, "kubectl.1.15"), problem: "wrong platform"},
+		{version: "1.16", path: filepath.Join(first, "kubectl.1.16.gz")},
+		{version: "1.12", path: filepath.Join(second, "kubectl.1.12"), clientVersion: "v1.12.3", problem: "shadowed by " + filepath.Join(first, "kubectl.1.12")},
+	}
+	inv := NewInventory(filepath.Join(tmp, "cache"))
+	for i := 0; i < 2; i++ {
+		entries, err := inv.List([]string{first, second, filepath.Join(tmp, "missing")})
+		if err != nil {
+			t.Fatalf("Unexpected error in List: %v", err)
+		}
+		if len(expected) != len(entries) {
+			t.Fatalf("Expected (%d) entries, got (%d): %+v", len(expected), len(entries), entries)
+		}
+		for j, e := range entries {
+			if expected[j].version != e.Version || expected[j].path != e.Path || expected[j].clientVersion != e.ClientVersion {
+				t.Errorf("Entry (%d) error: expected (%+v), got (%+v)", j, expected[j], e)
+			}
+			problems := strings.Join(e.Problems, "; ")
+			if expected[j].problem == "" && problems != "" {
+				t.Errorf("Entry (%s) unexpected problems: %s", e.Path, problems)
+			}
+			if !strings.Contains(problems, expected[j].problem) {
+				t.Errorf("Entry (%s) expected problem (%s), got (%s)", e.Path, expected[j].problem, problems)
+			}
+		}
+	}
+	// The two identical kubectl.1.12 scripts share a digest, so only two
+	// binaries ever ran; the second listing used the cache.
+	runs, _ := ioutil.ReadFile(log)
+	if count := strings.Count(string(runs), "run"); count != 2 {
+		t.Errorf("Expected 2 version runs, got (%d)", count)
+	}
+}
+
+func TestListBinaries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	compressed := filepath.Join(tmp, "kubectl.1.16.gz")
+	ioutil.WriteFile(compressed, []byte("compressed"), 0644)
+	binaries := []locator.Binary{
+		{Version: "1.16", Path: compressed, Source: locator.SourceCompressed, Compressed: true},
+		{Version: "1.16", Path: filepath.Join(tmp, "layout", "1.16", "kubectl"), Source: locator.SourceTemplate},
+		{Version: "1.17", Path: filepath.Join(tmp, "oci") + ":v1.17.0", Source: locator.SourceOCILayout},
+	}
+	entries := NewInventory(filepath.Join(tmp, "cache")).ListBinaries(binaries)
+	if len(entries) != 3 {
+		t.Fatalf("Expected (3) entries, got (%d): %+v", len(entries), entries)
+	}
+	if entries[0].Source != locator.SourceCompressed || !entries[0].Compressed || len(entries[0].Problems) != 0 {
+		t.Errorf("Entry (0): expected usable compressed binary, got (%+v)", entries[0])
+	}
+	problems := strings.Join(entries[1].Problems, "; ")
+	if entries[1].Source != locator.SourceTemplate || !strings.Contains(problems, "shadowed by "+compressed) {
+		t.Errorf("Entry (1): expected shadowed template binary, got (%+v)", entries[1])
+	}
+	// Images are only checked on extraction.
+	if entries[2].Source != locator.SourceOCILayout || len(entries[2].Problems) != 0 {
+		t.Errorf("Entry (2): expected unchecked image, got (%+v)", entries[2])
+	}
+}
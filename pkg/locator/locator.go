@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package locator finds the kubectl binaries the dispatcher may run. Each
+// BinaryLocator knows one place binaries are kept (a flat directory, a
+// templated layout, the managed store, or the PATH); the dispatcher merges
+// and ranks the binaries found by several locators.
+package locator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// Sources of kubectl binaries.
+const (
+	SourceDirectory  = "directory"
+	SourceCompressed = "compressed"
+	SourceTemplate   = "template"
+	SourceStore      = "store"
+	SourceOCILayout  = "OCI layout"
+	SourcePath       = "PATH"
+)
+
+// Binary is a kubectl binary found by a locator.
+type Binary struct {
+	// Version is the "<major>.<minor>" version the binary claims to be, or
+	// empty if unknown (as for PATH binaries).
+	Version string
+	Path    string
+	// Digest is the SHA-256 digest recorded for the binary (in a
+	// "<path>.sha256" file), or empty if none is recorded.
+	Digest string
+	Source string
+	// Compressed is true if the binary must be decompressed before it
+	// runs (see store.Decompressed).
+	Compressed bool
+}
+
+func (b Binary) String() string {
+	version := b.Version
+	if version == "" {
+		version = "unknown version"
+	}
+	return fmt.Sprintf("%s (%s, %s)", b.Path, version, b.Source)
+}
+
+// BinaryLocator finds kubectl binaries in one place.
+type BinaryLocator interface {
+	// Locate returns the usable binaries for the version, in order of
+	// preference. Locators which can not tell the version of a binary
+	// return every binary, with an empty Version. If there is none, the
+	// error explains why (e.g. the binary is missing, or rejected).
+	Locate(v version.Info) ([]Binary, error)
+}
+
+// BinaryLister is a BinaryLocator which can also list every binary it
+// holds, whether usable or not, for inventories. Locators which can not
+// tell the versions of their binaries (e.g. PathLocator) are not listers.
+type BinaryLister interface {
+	BinaryLocator
+	// List returns every binary, ordered by version.
+	List() ([]Binary, error)
+}
+
+// List returns the binaries listed by each locator which is a
+// BinaryLister, in order of precedence.
+func List(locators []BinaryLocator) ([]Binary, error) {
+	binaries := []Binary{}
+	for _, l := range locators {
+		lister, ok := l.(BinaryLister)
+		if !ok {
+			continue
+		}
+		listed, err := lister.List()
+		if err != nil {
+			return nil, err
+		}
+		binaries = append(binaries, listed...)
+	}
+	return binaries, nil
+}
+
+// Locate merges the binaries found for the version by each locator, in
+// order, and ranks them (see Rank). The error, returned only if no binary
+// was found, is that of the first locator which failed.
+func Locate(locators []BinaryLocator, v version.Info) ([]Binary, error) {
+	var binaries []Binary
+	var firstErr error
+	for _, l := range locators {
+		found, err := l.Locate(v)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		binaries = append(binaries, found...)
+	}
+	binaries = Rank(binaries, v)
+	if len(binaries) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no kubectl binary found for version %s", v.GitVersion)
+		}
+		return nil, firstErr
+	}
+	return binaries, nil
+}
+
+// Rank returns the binaries for the version in order of preference: those
+// claiming the version, then those of unknown version, each in their
+// original order. Binaries claiming another version are dropped, as are
+// duplicates: binaries with the path, or the recorded digest, of a
+// preferred binary.
+func Rank(binaries []Binary, v version.Info) []Binary {
+	majorMinor, err := util.MajorMinor(v)
+	if err != nil {
+		majorMinor = ""
+	}
+	ranked := []Binary{}
+	for _, b := range binaries {
+		if b.Version == "" || b.Version == majorMinor {
+			ranked = append(ranked, b)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Version != "" && ranked[j].Version == ""
+	})
+	unique := ranked[:0]
+	paths := map[string]bool{}
+	digests := map[string]bool{}
+	for _, b := range ranked {
+		if paths[b.Path] || (b.Digest != "" && digests[b.Digest]) {
+			continue
+		}
+		paths[b.Path] = true
+		if b.Digest != "" {
+			digests[b.Digest] = true
+		}
+		unique = append(unique, b)
+	}
+	return unique
+}
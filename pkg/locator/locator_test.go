@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locator
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// fakeLocator returns fixed binaries, or error.
+type fakeLocator struct {
+	binaries []Binary
+	err      error
+}
+
+func (l *fakeLocator) Locate(version.Info) ([]Binary, error) {
+	return l.binaries, l.err
+}
+
+// fakeLister also lists fixed binaries.
+type fakeLister struct {
+	fakeLocator
+	listed []Binary
+}
+
+func (l *fakeLister) List() ([]Binary, error) {
+	return l.listed, l.err
+}
+
+func paths(binaries []Binary) []string {
+	paths := []string{}
+	for _, b := range binaries {
+		paths = append(paths, b.Path)
+	}
+	return paths
+}
+
+func isStringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRank(t *testing.T) {
+	v113 := version.Info{Major: "1", Minor: "13"}
+	tests := []struct {
+		name     string
+		binaries []Binary
+		expected []string
+	}{
+		{
+			name: "unknown versions last",
+			binaries: []Binary{
+				{Path: "/usr/bin/kubectl", Source: SourcePath},
+				{Version: "1.13", Path: "/opt/kubectl.1.13", Source: SourceDirectory},
+				{Path: "/usr/local/bin/kubectl", Source: SourcePath},
+				{Version: "1.13", Path: "/store/kubectl.1.13", Source: SourceStore},
+			},
+			expected: []string{"/opt/kubectl.1.13", "/store/kubectl.1.13", "/usr/bin/kubectl", "/usr/local/bin/kubectl"},
+		},
+		{
+			name: "other versions dropped",
+			binaries: []Binary{
+				{Version: "1.12", Path: "/opt/kubectl.1.12", Source: SourceDirectory},
+				{Version: "1.13", Path: "/opt/kubectl.1.13", Source: SourceDirectory},
+			},
+			expected: []string{"/opt/kubectl.1.13"},
+		},
+		{
+			name: "duplicates dropped",
+			binaries: []Binary{
+				{Version: "1.13", Path: "/opt/kubectl.1.13.xz", Digest: "abc", Source: SourceCompressed, Compressed: true},
+				{Version: "1.13", Path: "/store/kubectl.1.13", Digest: "abc", Source: SourceStore},
+				{Version: "1.13", Path: "/opt/kubectl.1.13.xz", Source: SourceCompressed, Compressed: true},
+				{Version: "1.13", Path: "/mnt/kubectl.1.13", Digest: "def", Source: SourceTemplate},
+			},
+			expected: []string{"/opt/kubectl.1.13.xz", "/mnt/kubectl.1.13"},
+		},
+		{
+			name:     "none",
+			binaries: nil,
+			expected: []string{},
+		},
+	}
+	for _, test := range tests {
+		actual := paths(Rank(test.binaries, v113))
+		if !isStringSliceEqual(test.expected, actual) {
+			t.Errorf("Rank (%s): expected (%v), got (%v)", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	v113 := version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.0"}
+	missing := fmt.Errorf("missing")
+	rejected := fmt.Errorf("rejected")
+	dir := &fakeLocator{binaries: []Binary{{Version: "1.13", Path: "/opt/kubectl.1.13", Source: SourceDirectory}}}
+	path := &fakeLocator{binaries: []Binary{{Path: "/usr/bin/kubectl", Source: SourcePath}}}
+	store := &fakeLocator{binaries: []Binary{{Version: "1.13", Path: "/store/kubectl.1.13", Source: SourceStore}}}
+	tests := []struct {
+		name        string
+		locators    []BinaryLocator
+		expected    []string
+		expectedErr error
+	}{
+		{
+			name:     "merged and ranked",
+			locators: []BinaryLocator{path, &fakeLocator{err: missing}, dir, store},
+			expected: []string{"/opt/kubectl.1.13", "/store/kubectl.1.13", "/usr/bin/kubectl"},
+		},
+		{
+			name:        "first error",
+			locators:    []BinaryLocator{&fakeLocator{err: rejected}, &fakeLocator{err: missing}},
+			expected:    []string{},
+			expectedErr: rejected,
+		},
+	}
+	for _, test := range tests {
+		binaries, err := Locate(test.locators, v113)
+		if err != test.expectedErr {
+			t.Errorf("Locate (%s) error: expected (%v), got (%v)", test.name, test.expectedErr, err)
+		}
+		if actual := paths(binaries); !isStringSliceEqual(test.expected, actual) {
+			t.Errorf("Locate (%s): expected (%v), got (%v)", test.name, test.expected, actual)
+		}
+	}
+	if _, err := Locate(nil, v113); err == nil {
+		t.Errorf("Locate without locators: expected error, got none")
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := &fakeLister{listed: []Binary{{Version: "1.12", Path: "/opt/kubectl.1.12"}, {Version: "1.13", Path: "/opt/kubectl.1.13"}}}
+	path := &fakeLocator{binaries: []Binary{{Path: "/usr/bin/kubectl", Source: SourcePath}}}
+	store := &fakeLister{listed: []Binary{{Version: "1.12", Path: "/store/kubectl.1.12"}}}
+	binaries, err := List([]BinaryLocator{path, dir, store})
+	expected := []string{"/opt/kubectl.1.12", "/opt/kubectl.1.13", "/store/kubectl.1.12"}
+	if actual := paths(binaries); err != nil || !isStringSliceEqual(expected, actual) {
+		t.Errorf("List: expected (%v), got (%v, %v)", expected, actual, err)
+	}
+	failed := fmt.Errorf("failed")
+	if _, err := List([]BinaryLocator{dir, &fakeLister{fakeLocator: fakeLocator{err: failed}}}); err != failed {
+		t.Errorf("List error: expected (%v), got (%v)", failed, err)
+	}
+}
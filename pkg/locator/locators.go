@@ -0,0 +1,386 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+// DirLocator finds versioned binaries in a flat directory, named as by the
+// FilepathBuilder (e.g. "kubectl.1.12"). If the binary is unusable, a
+// compressed variant (e.g. "kubectl.1.12.xz") is returned instead.
+type DirLocator struct {
+	Builder *dfilepath.FilepathBuilder
+}
+
+// NewDirLocator returns the locator for the directory, where binaries are
+// validated by the stat function (e.g. filepath.StatExecutable).
+func NewDirLocator(dir string, stat func(string) (os.FileInfo, error)) *DirLocator {
+	return &DirLocator{Builder: dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, stat)}
+}
+
+func (l *DirLocator) Locate(v version.Info) ([]Binary, error) {
+	path, err := l.Builder.VersionedFilePath(v)
+	if err != nil {
+		return nil, err
+	}
+	majorMinor, _ := util.MajorMinor(v)
+	err = l.Builder.ValidateFilepath(path)
+	if err == nil {
+		return []Binary{newBinary(majorMinor, path, SourceDirectory)}, nil
+	}
+	if compressed := store.FindCompressed(path); compressed != "" {
+		b := newBinary(majorMinor, compressed, SourceCompressed)
+		b.Compressed = true
+		return []Binary{b}, nil
+	}
+	return nil, err
+}
+
+// List returns every versioned binary in the directory, including
+// compressed ones.
+func (l *DirLocator) List() ([]Binary, error) {
+	dir, err := l.Builder.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return listDir(dir, SourceDirectory)
+}
+
+// Matches versioned kubectl binaries, optionally compressed. Example:
+// kubectl.1.12, kubectl.1.12.exe, kubectl.1.12.xz
+var binaryNameRegexp = regexp.MustCompile(`^kubectl\.(\d+)\.(\d+)(\.exe)?(\.zst|\.xz|\.gz)?$`)
+
+// listDir returns the versioned binaries in the directory, ordered by
+// version, with uncompressed binaries first. A missing directory holds
+// none.
+func listDir(dir string, source string) ([]Binary, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type listed struct {
+		Binary
+		major, minor int
+	}
+	found := []listed{}
+	for _, fi := range files {
+		match := binaryNameRegexp.FindStringSubmatch(fi.Name())
+		if match == nil {
+			continue
+		}
+		major, _ := strconv.Atoi(match[1])
+		minor, _ := strconv.Atoi(match[2])
+		b := newBinary(fmt.Sprintf("%d.%d", major, minor), filepath.Join(dir, fi.Name()), source)
+		if match[4] != "" {
+			b.Source, b.Compressed = SourceCompressed, true
+		}
+		found = append(found, listed{Binary: b, major: major, minor: minor})
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].major != found[j].major {
+			return found[i].major < found[j].major
+		}
+		if found[i].minor != found[j].minor {
+			return found[i].minor < found[j].minor
+		}
+		return !found[i].Compressed && found[j].Compressed
+	})
+	binaries := make([]Binary, 0, len(found))
+	for _, f := range found {
+		binaries = append(binaries, f.Binary)
+	}
+	return binaries, nil
+}
+
+// LayoutData is the data a layout template is executed with.
+type LayoutData struct {
+	Major, Minor int
+	// Version is "<major>.<minor>".
+	Version string
+	OS      string
+	Arch    string
+	// Ext is the suffix of executables on the OS (".exe" on windows).
+	Ext string
+}
+
+// TemplateLocator finds versioned binaries at the path given by a layout
+// template, such as "/opt/kubernetes/{{.Version}}/bin/kubectl{{.Ext}}".
+type TemplateLocator struct {
+	layout *template.Template
+	stat   func(string) (os.FileInfo, error)
+}
+
+// NewTemplateLocator returns the locator for the layout template (with the
+// fields of LayoutData), where binaries are validated by the stat function.
+func NewTemplateLocator(layout string, stat func(string) (os.FileInfo, error)) (*TemplateLocator, error) {
+	t, err := ParseLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateLocator{layout: t, stat: stat}, nil
+}
+
+// ParseLayout parses the layout template.
+func ParseLayout(layout string) (*template.Template, error) {
+	t, err := template.New("layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %q: %v", layout, err)
+	}
+	return t, nil
+}
+
+func (l *TemplateLocator) Locate(v version.Info) ([]Binary, error) {
+	major, err := util.GetMajorVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	minor, err := util.GetMinorVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	path, err := l.render(major, minor)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.stat(path); err != nil {
+		return nil, err
+	}
+	return []Binary{newBinary(fmt.Sprintf("%d.%d", major, minor), path, SourceTemplate)}, nil
+}
+
+// render returns the path the layout gives for the version.
+func (l *TemplateLocator) render(major, minor int) (string, error) {
+	data := LayoutData{
+		Major:   major,
+		Minor:   minor,
+		Version: fmt.Sprintf("%d.%d", major, minor),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Ext:     dfilepath.ExeSuffix(runtime.GOOS),
+	}
+	var path bytes.Buffer
+	if err := l.layout.Execute(&path, data); err != nil {
+		return "", err
+	}
+	return path.String(), nil
+}
+
+// Placeholder versions the layout is executed with to list its binaries.
+const (
+	listMajor = 900001
+	listMinor = 900002
+)
+
+// List returns every binary at a path the layout gives for some version,
+// whether usable or not. The layout is executed with placeholder versions,
+// which are globbed for, and parsed back from the matching paths.
+func (l *TemplateLocator) List() ([]Binary, error) {
+	rendered, err := l.render(listMajor, listMinor)
+	if err != nil {
+		return nil, err
+	}
+	major, minor := strconv.Itoa(listMajor), strconv.Itoa(listMinor)
+	glob := strings.NewReplacer(major, "*", minor, "*").Replace(rendered)
+	pattern := regexp.MustCompile("^" + strings.NewReplacer(major, `(?P<major>\d+)`, minor, `(?P<minor>\d+)`).Replace(regexp.QuoteMeta(rendered)) + "$")
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	type listed struct {
+		Binary
+		major, minor int
+	}
+	found := []listed{}
+	for _, path := range paths {
+		match := pattern.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+		major, _ := strconv.Atoi(match[pattern.SubexpIndex("major")])
+		minor, _ := strconv.Atoi(match[pattern.SubexpIndex("minor")])
+		// Each placeholder must give the same version.
+		if expected, err := l.render(major, minor); err != nil || expected != path {
+			continue
+		}
+		found = append(found, listed{Binary: newBinary(fmt.Sprintf("%d.%d", major, minor), path, SourceTemplate), major: major, minor: minor})
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].major != found[j].major {
+			return found[i].major < found[j].major
+		}
+		return found[i].minor < found[j].minor
+	})
+	binaries := make([]Binary, 0, len(found))
+	for _, f := range found {
+		binaries = append(binaries, f.Binary)
+	}
+	return binaries, nil
+}
+
+// StoreLocator finds versioned binaries in the managed store, under each
+// key (see store.Key), with the digest they were stored with. Binaries
+// which no longer have that digest are skipped.
+type StoreLocator struct {
+	store *store.Store
+	stat  func(string) (os.FileInfo, error)
+}
+
+// NewStoreLocator returns the locator for the store, where binaries are
+// validated by the stat function.
+func NewStoreLocator(s *store.Store, stat func(string) (os.FileInfo, error)) *StoreLocator {
+	return &StoreLocator{store: s, stat: stat}
+}
+
+func (l *StoreLocator) Locate(v version.Info) ([]Binary, error) {
+	keys, err := l.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	majorMinor, _ := util.MajorMinor(v)
+	binaries := []Binary{}
+	err = fmt.Errorf("no kubectl %s in the store %s", majorMinor, l.store.Dir())
+	for _, key := range keys {
+		path, lookupErr := l.store.LookupKeyed(v, key)
+		if lookupErr == nil {
+			_, lookupErr = l.stat(path)
+		}
+		if lookupErr != nil {
+			if !os.IsNotExist(lookupErr) {
+				err = lookupErr
+			}
+			continue
+		}
+		binaries = append(binaries, newBinary(majorMinor, path, SourceStore))
+	}
+	if len(binaries) == 0 {
+		return nil, err
+	}
+	return binaries, nil
+}
+
+// List returns every binary stored, by version, which still has the digest
+// it was stored with.
+func (l *StoreLocator) List() ([]Binary, error) {
+	keys, err := l.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	binaries := []Binary{}
+	for _, key := range keys {
+		listed, err := listDir(filepath.Join(l.store.Dir(), key), SourceStore)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range listed {
+			if err := store.VerifyFile(b.Path); err != nil {
+				klog.V(3).Infof("Skipping stored binary %s: %v", b.Path, err)
+				continue
+			}
+			binaries = append(binaries, b)
+		}
+	}
+	sort.SliceStable(binaries, func(i, j int) bool {
+		return versionLess(binaries[i].Version, binaries[j].Version)
+	})
+	return binaries, nil
+}
+
+// versionLess orders "<major>.<minor>" versions numerically.
+func versionLess(a, b string) bool {
+	var aMajor, aMinor, bMajor, bMinor int
+	fmt.Sscanf(a, "%d.%d", &aMajor, &aMinor)
+	fmt.Sscanf(b, "%d.%d", &bMajor, &bMinor)
+	if aMajor != bMajor {
+		return aMajor < bMajor
+	}
+	return aMinor < bMinor
+}
+
+// PathLocator finds every kubectl binary on a PATH. Their versions are
+// unknown, so they are returned for every version.
+type PathLocator struct {
+	// Path is the value of the PATH environment variable.
+	Path string
+	// Stat returns the FileInfo of a binary; os.Stat if nil.
+	Stat func(string) (os.FileInfo, error)
+	// Exclude is a binary to skip, such as the dispatcher itself.
+	Exclude os.FileInfo
+}
+
+func (l *PathLocator) Locate(version.Info) ([]Binary, error) {
+	stat := l.Stat
+	if stat == nil {
+		stat = os.Stat
+	}
+	binaries := []Binary{}
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(l.Path) {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, "kubectl"+dfilepath.ExeSuffix(runtime.GOOS))
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		fi, err := stat(path)
+		if err != nil || !isExecutable(fi) || (l.Exclude != nil && os.SameFile(fi, l.Exclude)) {
+			continue
+		}
+		binaries = append(binaries, Binary{Path: path, Source: SourcePath})
+	}
+	return binaries, nil
+}
+
+// isExecutable returns true for a regular file which is executable (as
+// exec.LookPath checks).
+func isExecutable(fi os.FileInfo) bool {
+	if !fi.Mode().IsRegular() {
+		return false
+	}
+	return runtime.GOOS == "windows" || fi.Mode()&0111 != 0
+}
+
+// newBinary returns the binary with the digest recorded next to it, if
+// any. Compressed binaries record the digest of their contents.
+func newBinary(majorMinor string, path string, source string) Binary {
+	digest, err := store.ReadDigestFile(path + store.DigestSuffix)
+	if err != nil {
+		klog.V(3).Infof("Ignoring digest of %s: %v", path, err)
+	}
+	return Binary{Version: majorMinor, Path: path, Digest: digest, Source: source}
+}
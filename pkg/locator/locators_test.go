@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locator
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/store"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+var (
+	v112 = version.Info{Major: "1", Minor: "12"}
+	v113 = version.Info{Major: "1", Minor: "13"}
+	v114 = version.Info{Major: "1", Minor: "14"}
+)
+
+func tempDir(t *testing.T) string {
+	tmp, err := ioutil.TempDir("", "locator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmp
+}
+
+func TestDirLocator(t *testing.T) {
+	tmp := tempDir(t)
+	defer os.RemoveAll(tmp)
+	exe := dfilepath.ExeSuffix(runtime.GOOS)
+	ioutil.WriteFile(filepath.Join(tmp, "kubectl.1.12"+exe), []byte("kubectl"), 0755)
+	ioutil.WriteFile(filepath.Join(tmp, "kubectl.1.12"+exe+store.DigestSuffix), []byte("abc  kubectl.1.12\n"), 0644)
+	ioutil.WriteFile(filepath.Join(tmp, "kubectl.1.13"+exe+".gz"), []byte("compressed"), 0644)
+	l := NewDirLocator(tmp, os.Stat)
+
+	binaries, err := l.Locate(v112)
+	expected := Binary{Version: "1.12", Path: filepath.Join(tmp, "kubectl.1.12"+exe), Digest: "abc", Source: SourceDirectory}
+	if err != nil || len(binaries) != 1 || binaries[0] != expected {
+		t.Errorf("DirLocator: expected (%+v), got (%+v, %v)", expected, binaries, err)
+	}
+	binaries, err = l.Locate(v113)
+	expected = Binary{Version: "1.13", Path: filepath.Join(tmp, "kubectl.1.13"+exe+".gz"), Source: SourceCompressed, Compressed: true}
+	if err != nil || len(binaries) != 1 || binaries[0] != expected {
+		t.Errorf("DirLocator compressed: expected (%+v), got (%+v, %v)", expected, binaries, err)
+	}
+	if binaries, err = l.Locate(v114); err == nil || len(binaries) != 0 {
+		t.Errorf("DirLocator missing: expected error, got (%+v)", binaries)
+	}
+
+	ioutil.WriteFile(filepath.Join(tmp, "kubectl.1.9"+exe), []byte("kubectl"), 0644)
+	ioutil.WriteFile(filepath.Join(tmp, "kubectl.1.13"+exe), []byte("kubectl"), 0755)
+	binaries, err = l.List()
+	expectedPaths := []string{
+		filepath.Join(tmp, "kubectl.1.9"+exe),
+		filepath.Join(tmp, "kubectl.1.12"+exe),
+		filepath.Join(tmp, "kubectl.1.13"+exe),
+		filepath.Join(tmp, "kubectl.1.13"+exe+".gz"),
+	}
+	if actual := paths(binaries); err != nil || !isStringSliceEqual(expectedPaths, actual) {
+		t.Errorf("DirLocator list: expected (%v), got (%v, %v)", expectedPaths, actual, err)
+	}
+	if binaries, err = NewDirLocator(filepath.Join(tmp, "missing"), os.Stat).List(); err != nil || len(binaries) != 0 {
+		t.Errorf("DirLocator list missing: expected none, got (%+v, %v)", binaries, err)
+	}
+}
+
+func TestTemplateLocator(t *testing.T) {
+	tmp := tempDir(t)
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "1.13", runtime.GOOS+"-"+runtime.GOARCH, "kubectl"+dfilepath.ExeSuffix(runtime.GOOS))
+	os.MkdirAll(filepath.Dir(path), 0755)
+	ioutil.WriteFile(path, []byte("kubectl"), 0755)
+	l, err := NewTemplateLocator(filepath.Join(tmp, "{{.Major}}.{{.Minor}}", "{{.OS}}-{{.Arch}}", "kubectl{{.Ext}}"), os.Stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaries, err := l.Locate(v113)
+	expected := Binary{Version: "1.13", Path: path, Source: SourceTemplate}
+	if err != nil || len(binaries) != 1 || binaries[0] != expected {
+		t.Errorf("TemplateLocator: expected (%+v), got (%+v, %v)", expected, binaries, err)
+	}
+	if binaries, err = l.Locate(v112); err == nil || len(binaries) != 0 {
+		t.Errorf("TemplateLocator missing: expected error, got (%+v)", binaries)
+	}
+
+	// Listed even if unusable.
+	unusable := filepath.Join(tmp, "1.9", runtime.GOOS+"-"+runtime.GOARCH, "kubectl"+dfilepath.ExeSuffix(runtime.GOOS))
+	os.MkdirAll(filepath.Dir(unusable), 0755)
+	ioutil.WriteFile(unusable, []byte("kubectl"), 0644)
+	os.MkdirAll(filepath.Join(tmp, "1.14", "other-arch"), 0755)
+	ioutil.WriteFile(filepath.Join(tmp, "1.14", "other-arch", "kubectl"+dfilepath.ExeSuffix(runtime.GOOS)), []byte("kubectl"), 0755)
+	binaries, err = l.List()
+	expectedBinaries := []Binary{{Version: "1.9", Path: unusable, Source: SourceTemplate}, expected}
+	if err != nil || len(binaries) != 2 || binaries[0] != expectedBinaries[0] || binaries[1] != expectedBinaries[1] {
+		t.Errorf("TemplateLocator list: expected (%+v), got (%+v, %v)", expectedBinaries, binaries, err)
+	}
+	// Placeholders repeated in the layout must give the same version.
+	l, err = NewTemplateLocator(filepath.Join(tmp, "{{.Version}}", "kubectl.{{.Major}}.{{.Minor}}"), os.Stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(tmp, "1.15"), 0755)
+	ioutil.WriteFile(filepath.Join(tmp, "1.15", "kubectl.1.15"), []byte("kubectl"), 0755)
+	ioutil.WriteFile(filepath.Join(tmp, "1.15", "kubectl.1.16"), []byte("kubectl"), 0755)
+	binaries, err = l.List()
+	expectedPaths := []string{filepath.Join(tmp, "1.15", "kubectl.1.15")}
+	if actual := paths(binaries); err != nil || !isStringSliceEqual(expectedPaths, actual) {
+		t.Errorf("TemplateLocator list repeated: expected (%v), got (%v, %v)", expectedPaths, actual, err)
+	}
+
+	tests := []struct {
+		layout      string
+		expectError bool
+	}{
+		{layout: "/opt/{{.Version}}/kubectl"},
+		{layout: "/opt/{{.Version}/kubectl", expectError: true},
+		{layout: "/opt/{{.Release}}/kubectl", expectError: true},
+	}
+	for _, test := range tests {
+		l, err := NewTemplateLocator(test.layout, os.Stat)
+		if err == nil {
+			// Unknown fields fail on execution.
+			_, err = l.Locate(v113)
+			if err != nil && os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if test.expectError != (err != nil) {
+			t.Errorf("TemplateLocator (%s): expected error (%t), got (%v)", test.layout, test.expectError, err)
+		}
+	}
+}
+
+func TestStoreLocator(t *testing.T) {
+	tmp := tempDir(t)
+	defer os.RemoveAll(tmp)
+	s := store.NewStore(tmp)
+	write := func(contents string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, contents)
+			return err
+		}
+	}
+	path, digest, err := s.PutKeyed(v113, store.Key("a"), write("stored kubectl"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another key holds a binary of the same version, which was modified.
+	tampered, _, err := s.PutKeyed(v113, store.Key("b"), write("stored kubectl"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tampered, []byte("tampered"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l := NewStoreLocator(s, os.Stat)
+	binaries, err := l.Locate(v113)
+	expected := Binary{Version: "1.13", Path: path, Digest: digest, Source: SourceStore}
+	if err != nil || len(binaries) != 1 || binaries[0] != expected {
+		t.Errorf("StoreLocator: expected (%+v), got (%+v, %v)", expected, binaries, err)
+	}
+	if binaries, err = l.Locate(v112); err == nil || len(binaries) != 0 {
+		t.Errorf("StoreLocator missing: expected error, got (%+v)", binaries)
+	}
+	binaries, err = l.List()
+	if err != nil || len(binaries) != 1 || binaries[0] != expected {
+		t.Errorf("StoreLocator list: expected (%+v), got (%+v, %v)", expected, binaries, err)
+	}
+	// An empty store holds nothing.
+	l = NewStoreLocator(store.NewStore(filepath.Join(tmp, "missing")), os.Stat)
+	if binaries, err = l.List(); err != nil || len(binaries) != 0 {
+		t.Errorf("StoreLocator empty list: expected none, got (%+v, %v)", binaries, err)
+	}
+}
+
+func TestPathLocator(t *testing.T) {
+	tmp := tempDir(t)
+	defer os.RemoveAll(tmp)
+	kubectl := "kubectl" + dfilepath.ExeSuffix(runtime.GOOS)
+	for _, dir := range []string{"self", "path1", "path2", "notexec"} {
+		os.MkdirAll(filepath.Join(tmp, dir), 0755)
+		ioutil.WriteFile(filepath.Join(tmp, dir, kubectl), []byte("#!/bin/sh\n"), 0755)
+	}
+	os.Chmod(filepath.Join(tmp, "notexec", kubectl), 0644)
+	self, err := os.Stat(filepath.Join(tmp, "self", kubectl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{"self", "path1", "", "notexec", "missing", "path2", "path1"}
+	for i := range dirs {
+		if dirs[i] != "" {
+			dirs[i] = filepath.Join(tmp, dirs[i])
+		}
+	}
+	l := &PathLocator{Path: strings.Join(dirs, string(filepath.ListSeparator)), Exclude: self}
+	binaries, err := l.Locate(v113)
+	expected := []string{filepath.Join(tmp, "path1", kubectl), filepath.Join(tmp, "path2", kubectl)}
+	if runtime.GOOS == "windows" {
+		// Every file is executable.
+		expected = []string{filepath.Join(tmp, "path1", kubectl), filepath.Join(tmp, "notexec", kubectl), filepath.Join(tmp, "path2", kubectl)}
+	}
+	if actual := paths(binaries); err != nil || !isStringSliceEqual(expected, actual) {
+		t.Errorf("PathLocator: expected (%v), got (%v, %v)", expected, actual, err)
+	}
+	for _, b := range binaries {
+		if b.Version != "" || b.Source != SourcePath {
+			t.Errorf("PathLocator: expected unknown version from PATH, got (%+v)", b)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oci reads kubectl binaries out of container images stored in an
+// OCI image layout directory (index.json, oci-layout and blobs/). Every blob
+// is verified against its digest, so the binary extracted is exactly the one
+// in the image that was scanned and approved.
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	layoutFile        = "oci-layout"
+	indexFile         = "index.json"
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+
+	mediaTypeImageIndex         = "application/vnd.oci.image.index.v1+json"
+	mediaTypeImageManifest      = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Descriptor references a blob within the layout.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform describes the platform an image in an index is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Index is an OCI image index, such as the index.json of a layout.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Layout is an OCI image layout directory on disk.
+type Layout struct {
+	dir string
+}
+
+// NewLayout returns the OCI image layout rooted at the passed directory.
+func NewLayout(dir string) (*Layout, error) {
+	if _, err := os.Stat(filepath.Join(dir, layoutFile)); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %v", dir, err)
+	}
+	return &Layout{dir: dir}, nil
+}
+
+// Tags returns the tags (reference names) of the images in the layout.
+func (l *Layout) Tags() ([]string, error) {
+	index, err := l.index()
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, d := range index.Manifests {
+		if tag := refName(d); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// FindTag returns the tag of the image for the major/minor version. Tags
+// must parse as versions (e.g. "v1.12.3" or "1.12"); if several match, the
+// highest patch version wins.
+func (l *Layout) FindTag(v version.Info) (string, error) {
+	want, err := util.MajorMinor(v)
+	if err != nil {
+		return "", err
+	}
+	tags, err := l.Tags()
+	if err != nil {
+		return "", err
+	}
+	found, foundPatch := "", -1
+	for _, tag := range tags {
+		info, err := util.ParseVersion(tag)
+		if err != nil {
+			continue
+		}
+		if majorMinor, _ := util.MajorMinor(info); majorMinor != want {
+			continue
+		}
+		if patch := patchVersion(tag); patch > foundPatch {
+			found, foundPatch = tag, patch
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("no image for kubectl %s in OCI layout %s", want, l.dir)
+	}
+	return found, nil
+}
+
+// Digest returns the digest of the image (or image index) with the tag,
+// which changes with every build pushed to the tag.
+func (l *Layout) Digest(tag string) (string, error) {
+	index, err := l.index()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range index.Manifests {
+		if refName(d) == tag {
+			return d.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("no image tagged %s in OCI layout %s", tag, l.dir)
+}
+
+// ExtractKubectl writes the kubectl binary from the image with the passed
+// tag to "w". Layers are searched from the top of the image down, and the
+// first regular file named "kubectl" is extracted. The containing layer is
+// read to the end to verify its digest; a mismatch is returned as an error
+// after the binary was written, so callers must discard it.
+func (l *Layout) ExtractKubectl(tag string, w io.Writer) error {
+	manifest, err := l.manifestForTag(tag)
+	if err != nil {
+		return err
+	}
+	name := "kubectl"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		found, err := l.extractFromLayer(manifest.Layers[i], name, w)
+		if err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s binary in image %s", name, tag)
+}
+
+func (l *Layout) index() (*Index, error) {
+	var index Index
+	contents, err := ioutil.ReadFile(filepath.Join(l.dir, indexFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return nil, fmt.Errorf("bad %s in %s: %v", indexFile, l.dir, err)
+	}
+	return &index, nil
+}
+
+// manifestForTag returns the image manifest for the tag, resolving a
+// multi-platform index to the image for the current platform.
+func (l *Layout) manifestForTag(tag string) (*Manifest, error) {
+	index, err := l.index()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range index.Manifests {
+		if refName(d) == tag {
+			return l.resolveManifest(d)
+		}
+	}
+	return nil, fmt.Errorf("no image tagged %s in OCI layout %s", tag, l.dir)
+}
+
+func (l *Layout) resolveManifest(d Descriptor) (*Manifest, error) {
+	contents, err := l.readBlob(d)
+	if err != nil {
+		return nil, err
+	}
+	switch d.MediaType {
+	case mediaTypeImageManifest, mediaTypeDockerManifest:
+		var manifest Manifest
+		if err := json.Unmarshal(contents, &manifest); err != nil {
+			return nil, fmt.Errorf("bad image manifest %s: %v", d.Digest, err)
+		}
+		return &manifest, nil
+	case mediaTypeImageIndex, mediaTypeDockerManifestList:
+		var index Index
+		if err := json.Unmarshal(contents, &index); err != nil {
+			return nil, fmt.Errorf("bad image index %s: %v", d.Digest, err)
+		}
+		for _, m := range index.Manifests {
+			if m.Platform == nil || (m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH) {
+				return l.resolveManifest(m)
+			}
+		}
+		return nil, fmt.Errorf("no image for %s/%s in index %s", runtime.GOOS, runtime.GOARCH, d.Digest)
+	}
+	return nil, fmt.Errorf("unsupported manifest media type %q", d.MediaType)
+}
+
+// readBlob returns the verified contents of a (small) blob.
+func (l *Layout) readBlob(d Descriptor) ([]byte, error) {
+	r, err := l.openBlob(d)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return contents, r.verify()
+}
+
+// extractFromLayer copies the file with the base name from the layer to
+// "w". Returns true if the file was found.
+func (l *Layout) extractFromLayer(d Descriptor, name string, w io.Writer) (bool, error) {
+	blob, err := l.openBlob(d)
+	if err != nil {
+		return false, err
+	}
+	defer blob.Close()
+	var r io.Reader = bufio.NewReader(blob)
+	if strings.HasSuffix(d.MediaType, "gzip") {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return false, fmt.Errorf("layer %s: %v", d.Digest, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	} else if !strings.HasSuffix(d.MediaType, ".tar") {
+		return false, fmt.Errorf("unsupported layer media type %q", d.MediaType)
+	}
+	tr := tar.NewReader(r)
+	found := false
+	for !found {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("layer %s: %v", d.Digest, err)
+		}
+		if header.Typeflag != tar.TypeReg || path.Base(header.Name) != name {
+			continue
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return false, err
+		}
+		found = true
+	}
+	if !found {
+		return false, nil
+	}
+	// Drain the rest of the layer, so its digest can be verified.
+	if _, err := io.Copy(ioutil.Discard, blob); err != nil {
+		return false, err
+	}
+	return true, blob.verify()
+}
+
+// blobReader reads a blob while computing its digest.
+type blobReader struct {
+	*os.File
+	descriptor Descriptor
+	hash       hash.Hash
+	size       int64
+}
+
+func (l *Layout) openBlob(d Descriptor) (*blobReader, error) {
+	parts := strings.SplitN(d.Digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || len(parts[1]) != sha256.Size*2 || strings.ContainsAny(parts[1], "/\\.") {
+		return nil, fmt.Errorf("unsupported blob digest %q", d.Digest)
+	}
+	f, err := os.Open(filepath.Join(l.dir, "blobs", parts[0], parts[1]))
+	if err != nil {
+		return nil, err
+	}
+	return &blobReader{File: f, descriptor: d, hash: sha256.New()}, nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.File.Read(p)
+	b.hash.Write(p[:n])
+	b.size += int64(n)
+	return n, err
+}
+
+// verify checks the size and digest of the blob, which must have been
+// read to the end.
+func (b *blobReader) verify() error {
+	if b.descriptor.Size > 0 && b.size != b.descriptor.Size {
+		return fmt.Errorf("blob %s size mismatch: expected (%d), got (%d)", b.descriptor.Digest, b.descriptor.Size, b.size)
+	}
+	if digest := "sha256:" + hex.EncodeToString(b.hash.Sum(nil)); digest != b.descriptor.Digest {
+		return fmt.Errorf("blob %s digest mismatch: got (%s)", b.descriptor.Digest, digest)
+	}
+	return nil
+}
+
+// refName returns the tag of the descriptor. Some tools annotate the full
+// image reference (e.g. "registry/kubectl:v1.12.3") instead of the tag.
+func refName(d Descriptor) string {
+	name := d.Annotations[refNameAnnotation]
+	if i := strings.LastIndex(name, ":"); i >= 0 && i > strings.LastIndex(name, "/") {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// patchVersion returns the patch version of a tag such as "v1.12.3-gke.1",
+// or zero if there is none.
+func patchVersion(tag string) int {
+	parts := strings.SplitN(strings.TrimPrefix(tag, "v"), ".", 3)
+	if len(parts) < 3 {
+		return 0
+	}
+	digits := strings.IndexFunc(parts[2], func(c rune) bool { return c < '0' || c > '9' })
+	if digits < 0 {
+		digits = len(parts[2])
+	}
+	patch, err := strconv.Atoi(parts[2][:digits])
+	if err != nil {
+		return 0
+	}
+	return patch
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const mediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+// fixture builds an OCI image layout in a temporary directory.
+type fixture struct {
+	t     *testing.T
+	dir   string
+	index Index
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, layoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+	return &fixture{t: t, dir: dir, index: Index{SchemaVersion: 2}}
+}
+
+func (f *fixture) writeBlob(mediaType string, contents []byte) Descriptor {
+	sum := sha256.Sum256(contents)
+	digest := hex.EncodeToString(sum[:])
+	if err := ioutil.WriteFile(filepath.Join(f.dir, "blobs", "sha256", digest), contents, 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	return Descriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(contents))}
+}
+
+// layer returns a gzipped tar layer blob with the passed files.
+func (f *fixture) layer(files map[string]string) Descriptor {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, contents := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+	gzw.Close()
+	return f.writeBlob(mediaTypeLayerGzip, buf.Bytes())
+}
+
+// image adds an image with the layers to the layout, tagged with "tag".
+// If "platformIndex" is true, the image is wrapped in a multi-platform index.
+func (f *fixture) image(tag string, platformIndex bool, layers ...Descriptor) {
+	config := f.writeBlob("application/vnd.oci.image.config.v1+json", []byte("{}"))
+	manifestBytes, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: mediaTypeImageManifest, Config: config, Layers: layers})
+	d := f.writeBlob(mediaTypeImageManifest, manifestBytes)
+	if platformIndex {
+		other := d
+		other.Platform = &Platform{OS: "plan9", Architecture: "mips"}
+		d.Platform = &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+		indexBytes, _ := json.Marshal(Index{SchemaVersion: 2, MediaType: mediaTypeImageIndex, Manifests: []Descriptor{other, d}})
+		d = f.writeBlob(mediaTypeImageIndex, indexBytes)
+	}
+	d.Annotations = map[string]string{refNameAnnotation: tag}
+	f.index.Manifests = append(f.index.Manifests, d)
+	indexBytes, _ := json.Marshal(f.index)
+	if err := ioutil.WriteFile(filepath.Join(f.dir, indexFile), indexBytes, 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func kubectlName() string {
+	if runtime.GOOS == "windows" {
+		return "kubectl.exe"
+	}
+	return "kubectl"
+}
+
+func TestFindTag(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+	layer := f.layer(map[string]string{"bin/" + kubectlName(): "kubectl"})
+	for _, tag := range []string{"v1.11.7", "v1.12.3", "v1.12.10", "registry.local:5000/kubectl:v1.13.1", "latest"} {
+		f.image(tag, false, layer)
+	}
+	layout, err := NewLayout(f.dir)
+	if err != nil {
+		t.Fatalf("Unexpected error in NewLayout: %v", err)
+	}
+
+	tests := []struct {
+		version     version.Info
+		tag         string
+		expectError bool
+	}{
+		{version: version.Info{Major: "1", Minor: "11"}, tag: "v1.11.7"},
+		{version: version.Info{Major: "1", Minor: "12+"}, tag: "v1.12.10"},
+		{version: version.Info{Major: "1", Minor: "13"}, tag: "v1.13.1"},
+		{version: version.Info{Major: "1", Minor: "14"}, expectError: true},
+	}
+	for _, test := range tests {
+		tag, err := layout.FindTag(test.version)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error finding tag for (%v); received none", test.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error finding tag for (%v): %v", test.version, err)
+		}
+		if test.tag != tag {
+			t.Errorf("FindTag error: expected (%s), got (%s)", test.tag, tag)
+		}
+	}
+}
+
+func TestExtractKubectl(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+	base := f.layer(map[string]string{"etc/passwd": "root", "usr/bin/" + kubectlName(): "old kubectl"})
+	top := f.layer(map[string]string{"opt/bin/" + kubectlName(): "new kubectl"})
+	f.image("v1.12.3", false, base, top)
+	f.image("v1.13.0", true, base)
+	f.image("v1.14.0", false, f.layer(map[string]string{"README": "no kubectl here"}))
+
+	layout, err := NewLayout(f.dir)
+	if err != nil {
+		t.Fatalf("Unexpected error in NewLayout: %v", err)
+	}
+	tests := []struct {
+		tag         string
+		contents    string
+		expectError bool
+	}{
+		{tag: "v1.12.3", contents: "new kubectl"},
+		{tag: "v1.13.0", contents: "old kubectl"},
+		{tag: "v1.14.0", expectError: true},
+		{tag: "v1.15.0", expectError: true},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := layout.ExtractKubectl(test.tag, &buf)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error extracting (%s); received none", test.tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error extracting (%s): %v", test.tag, err)
+		}
+		if test.contents != buf.String() {
+			t.Errorf("ExtractKubectl(%s) error: expected (%s), got (%s)", test.tag, test.contents, buf.String())
+		}
+	}
+}
+
+// Images pushed again to the same tag have a new digest.
+func TestDigest(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+	f.image("v1.12.3", false, f.layer(map[string]string{kubectlName(): "kubectl"}))
+	f.image("v1.12.4", false, f.layer(map[string]string{kubectlName(): "rebuilt kubectl"}))
+	layout, err := NewLayout(f.dir)
+	if err != nil {
+		t.Fatalf("Unexpected error in NewLayout: %v", err)
+	}
+	digest1, err := layout.Digest("v1.12.3")
+	if err != nil {
+		t.Fatalf("Unexpected error in Digest: %v", err)
+	}
+	if expected := f.index.Manifests[0].Digest; digest1 != expected {
+		t.Errorf("Digest error: expected (%s), got (%s)", expected, digest1)
+	}
+	if digest2, _ := layout.Digest("v1.12.4"); digest2 == digest1 {
+		t.Errorf("Expected distinct digests for distinct images, got (%s) twice", digest1)
+	}
+	if _, err := layout.Digest("v1.15.0"); err == nil {
+		t.Errorf("Expected error for a missing tag; received none")
+	}
+}
+
+func TestExtractKubectlCorruptLayer(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+	layer := f.layer(map[string]string{kubectlName(): "kubectl"})
+	f.image("v1.12.3", false, layer)
+
+	// Append to the layer blob; the gzip stream still decodes, but the
+	// digest no longer matches the manifest.
+	blobPath := filepath.Join(f.dir, "blobs", "sha256", layer.Digest[len("sha256:"):])
+	blob, err := os.OpenFile(blobPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.Write([]byte("tampered"))
+	blob.Close()
+
+	layout, err := NewLayout(f.dir)
+	if err != nil {
+		t.Fatalf("Unexpected error in NewLayout: %v", err)
+	}
+	if err := layout.ExtractKubectl("v1.12.3", ioutil.Discard); err == nil {
+		t.Errorf("Expected digest error extracting from corrupt layer; received none")
+	}
+}
+
+func TestNewLayoutNotALayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := NewLayout(dir); err == nil {
+		t.Errorf("Expected error for directory without oci-layout file; received none")
+	}
+}
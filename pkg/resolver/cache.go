@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const cacheFile = "server-versions.json"
+
+// DefaultMixedMaxAge is the maximum age of a version probed from a control
+// plane of mixed versions, which is likely being upgraded.
+const DefaultMixedMaxAge = time.Minute
+
+// cacheEntry is the last version probed from a cluster, and the last
+// version recommendation fetched from it.
+type cacheEntry struct {
+	Version version.Info `json:"version"`
+	Probed  time.Time    `json:"probed"`
+	// Mixed are the versions reported by the API servers, if they differ.
+	Mixed       []string             `json:"mixed,omitempty"`
+	Recommended *recommendationEntry `json:"recommended,omitempty"`
+}
+
+// recommendationEntry is the recommendation fetched from a cluster, which
+// is nil if the cluster recommends no version.
+type recommendationEntry struct {
+	Recommendation *client.Recommendation `json:"recommendation"`
+	Fetched        time.Time              `json:"fetched"`
+}
+
+// Cache records the last version probed from each cluster, by host, and
+// the version it recommends.
+type Cache struct {
+	path string
+}
+
+// NewCache returns the server version cache kept in the cache directory.
+func NewCache(cacheDir string) *Cache {
+	return &Cache{path: filepath.Join(cacheDir, cacheFile)}
+}
+
+// Get returns the version last probed from the cluster at the host, and
+// when it was probed, or nil if the cluster was never probed.
+func (c *Cache) Get(host string) (*version.Info, time.Time) {
+	entry, ok := c.load()[host]
+	if !ok || entry.Probed.IsZero() {
+		return nil, time.Time{}
+	}
+	return &entry.Version, entry.Probed
+}
+
+// Put records the version probed from the cluster at the host.
+func (c *Cache) Put(host string, info version.Info, probed time.Time) error {
+	return c.PutMixed(host, info, nil, probed)
+}
+
+// PutMixed records the version probed from the cluster at the host, whose
+// API servers reported the mixed versions.
+func (c *Cache) PutMixed(host string, info version.Info, mixed []string, probed time.Time) error {
+	return c.update(host, func(entry *cacheEntry) {
+		entry.Version = info
+		entry.Probed = probed
+		entry.Mixed = mixed
+	})
+}
+
+// Mixed returns the versions reported by the API servers of the cluster at
+// the host when it was last probed, if they differed.
+func (c *Cache) Mixed(host string) []string {
+	return c.load()[host].Mixed
+}
+
+// GetRecommendation returns the recommendation last fetched from the
+// cluster at the host (nil if it recommends no version), and when it was
+// fetched. The result is false if it was never fetched.
+func (c *Cache) GetRecommendation(host string) (*client.Recommendation, time.Time, bool) {
+	entry, ok := c.load()[host]
+	if !ok || entry.Recommended == nil {
+		return nil, time.Time{}, false
+	}
+	return entry.Recommended.Recommendation, entry.Recommended.Fetched, true
+}
+
+// PutRecommendation records the recommendation fetched from the cluster at
+// the host, which is nil if it recommends no version.
+func (c *Cache) PutRecommendation(host string, recommendation *client.Recommendation, fetched time.Time) error {
+	return c.update(host, func(entry *cacheEntry) {
+		entry.Recommended = &recommendationEntry{Recommendation: recommendation, Fetched: fetched}
+	})
+}
+
+// update changes the entry of the host, and writes the cache.
+func (c *Cache) update(host string, change func(*cacheEntry)) error {
+	entries := c.load()
+	entry := entries[host]
+	change(&entry)
+	entries[host] = entry
+	contents, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	// Write and rename, so concurrent dispatchers never read a partial file.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), cacheFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// load returns the cache entries by host; an unreadable cache is empty.
+func (c *Cache) load() map[string]cacheEntry {
+	entries := map[string]cacheEntry{}
+	if contents, err := ioutil.ReadFile(c.path); err == nil {
+		if err := json.Unmarshal(contents, &entries); err != nil {
+			return map[string]cacheEntry{}
+		}
+	}
+	return entries
+}
+
+// CacheResolver answers with the version last probed from the cluster,
+// unless it is older than the maximum age.
+type CacheResolver struct {
+	Cache *Cache
+	// Host is the URL of the cluster, keying the cache.
+	Host   string
+	MaxAge time.Duration
+	// MixedMaxAge is the shorter maximum age of a version probed from API
+	// servers of mixed versions. The default is DefaultMixedMaxAge.
+	MixedMaxAge time.Duration
+	Now         func() time.Time
+}
+
+func (r *CacheResolver) Name() string {
+	return config.ResolverCache
+}
+
+func (r *CacheResolver) Resolve() (*version.Info, string, error) {
+	if r.MaxAge <= 0 {
+		return nil, "a fresh version is required", nil
+	}
+	if r.Host == "" {
+		return nil, "unknown cluster", nil
+	}
+	info, probed := r.Cache.Get(r.Host)
+	if info == nil {
+		return nil, "never probed " + r.Host, nil
+	}
+	age := now(r.Now).Sub(probed)
+	maxAge, mixed := r.MaxAge, ""
+	if versions := r.Cache.Mixed(r.Host); len(versions) > 0 {
+		mixedMaxAge := r.MixedMaxAge
+		if mixedMaxAge <= 0 {
+			mixedMaxAge = DefaultMixedMaxAge
+		}
+		if mixedMaxAge < maxAge {
+			maxAge = mixedMaxAge
+		}
+		mixed = fmt.Sprintf(" (the lowest of mixed versions %s)", strings.Join(versions, ", "))
+	}
+	if age > maxAge {
+		return nil, fmt.Sprintf("version probed from %s %s ago%s has expired", r.Host, age.Round(time.Second), mixed), nil
+	}
+	return info, fmt.Sprintf("probed from %s %s ago%s", r.Host, age.Round(time.Second), mixed), nil
+}
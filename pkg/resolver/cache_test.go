@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestCacheResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	probed := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := cache.Put("https://a.example.com", version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}, probed); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put("https://b.example.com", version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.1"}, probed); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		host            string
+		maxAge          time.Duration
+		age             time.Duration
+		expectedVersion string
+	}{
+		{name: "fresh", host: "https://a.example.com", maxAge: time.Hour, age: time.Minute, expectedVersion: "v1.13.4"},
+		{name: "other host", host: "https://b.example.com", maxAge: time.Hour, age: time.Minute, expectedVersion: "v1.12.1"},
+		{name: "expired", host: "https://a.example.com", maxAge: time.Hour, age: 2 * time.Hour},
+		{name: "fresh version required", host: "https://a.example.com", maxAge: 0, age: time.Minute},
+		{name: "never probed", host: "https://c.example.com", maxAge: time.Hour, age: time.Minute},
+		{name: "unknown cluster", host: "", maxAge: time.Hour, age: time.Minute},
+	}
+	for _, test := range tests {
+		now := probed.Add(test.age)
+		r := &CacheResolver{Cache: cache, Host: test.host, MaxAge: test.maxAge, Now: func() time.Time { return now }}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "CacheResolver", test.name, actual, explanation, err, test.expectedVersion, false)
+	}
+}
+
+func TestCacheResolverMixed(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	host := "https://a.example.com"
+	probed := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	v113 := version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}
+	if err := cache.PutMixed(host, v113, []string{"v1.14.0", "v1.13.4"}, probed); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		mixedMaxAge     time.Duration
+		age             time.Duration
+		expectedVersion string
+	}{
+		{name: "fresh", age: 30 * time.Second, expectedVersion: "v1.13.4"},
+		{name: "expired", age: 2 * time.Minute},
+		{name: "longer mixed max age", mixedMaxAge: 5 * time.Minute, age: 2 * time.Minute, expectedVersion: "v1.13.4"},
+		{name: "mixed max age above max age", mixedMaxAge: 5 * time.Hour, age: 2 * time.Hour},
+	}
+	for _, test := range tests {
+		now := probed.Add(test.age)
+		r := &CacheResolver{Cache: cache, Host: host, MaxAge: time.Hour, MixedMaxAge: test.mixedMaxAge, Now: func() time.Time { return now }}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "CacheResolver", test.name, actual, explanation, err, test.expectedVersion, false)
+	}
+	// Agreeing versions clear the mixed state.
+	if err := cache.Put(host, v113, probed); err != nil {
+		t.Fatal(err)
+	}
+	if mixed := cache.Mixed(host); len(mixed) != 0 {
+		t.Errorf("Mixed versions after agreement: expected none, got (%v)", mixed)
+	}
+}
+
+func TestCacheUnreadable(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	if err := ioutil.WriteFile(cache.path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := cache.Get("https://a.example.com"); info != nil {
+		t.Errorf("Unreadable cache: expected no version, got (%v)", info)
+	}
+	// A corrupt cache is replaced.
+	if err := cache.Put("https://a.example.com", version.Info{GitVersion: "v1.13.4"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := cache.Get("https://a.example.com"); info == nil || info.GitVersion != "v1.13.4" {
+		t.Errorf("Rewritten cache: expected (v1.13.4), got (%v)", info)
+	}
+}
+
+func TestCacheRecommendation(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	host := "https://a.example.com"
+	fetched := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	if _, _, ok := cache.GetRecommendation(host); ok {
+		t.Errorf("Never fetched recommendation: expected none")
+	}
+	if err := cache.PutRecommendation(host, &client.Recommendation{Version: "1.13"}, fetched); err != nil {
+		t.Fatal(err)
+	}
+	// A recommendation alone is no probed version.
+	if info, _ := cache.Get(host); info != nil {
+		t.Errorf("Never probed version: expected none, got (%v)", info)
+	}
+	// The version and the recommendation are kept side by side.
+	if err := cache.Put(host, version.Info{GitVersion: "v1.12.1"}, fetched); err != nil {
+		t.Fatal(err)
+	}
+	recommendation, actualFetched, ok := cache.GetRecommendation(host)
+	if !ok || recommendation == nil || recommendation.Version != "1.13" || !actualFetched.Equal(fetched) {
+		t.Errorf("Cached recommendation: expected (1.13), got (%v, %v)", recommendation, ok)
+	}
+	if info, _ := cache.Get(host); info == nil || info.GitVersion != "v1.12.1" {
+		t.Errorf("Cached version: expected (v1.12.1), got (%v)", info)
+	}
+	// That the cluster recommends nothing is cached too.
+	if err := cache.PutRecommendation(host, nil, fetched); err != nil {
+		t.Fatal(err)
+	}
+	if recommendation, _, ok := cache.GetRecommendation(host); !ok || recommendation != nil {
+		t.Errorf("Cached absent recommendation: expected (nil, true), got (%v, %t)", recommendation, ok)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog"
+)
+
+// minorVersion is a Kubernetes "<major>.<minor>" version.
+type minorVersion struct {
+	major, minor int
+}
+
+func parseMinorVersion(s string) (minorVersion, error) {
+	info, err := util.ParseVersion(s)
+	if err != nil {
+		return minorVersion{}, err
+	}
+	return toMinorVersion(info)
+}
+
+func toMinorVersion(info version.Info) (minorVersion, error) {
+	major, err := util.GetMajorVersion(info)
+	if err != nil {
+		return minorVersion{}, err
+	}
+	minor, err := util.GetMinorVersion(info)
+	if err != nil {
+		return minorVersion{}, err
+	}
+	return minorVersion{major: major, minor: minor}, nil
+}
+
+func (v minorVersion) less(other minorVersion) bool {
+	return v.major < other.major || (v.major == other.major && v.minor < other.minor)
+}
+
+func (v minorVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// Range is a range of minor versions, such as ">=1.26, <1.29". A single
+// version (e.g. "1.27") is a range of one.
+type Range struct {
+	min, max *minorVersion // Nil is unbounded
+}
+
+// ParseRange parses comparisons of versions (=, >=, >, <= or <), separated
+// by commas or spaces, which must all hold.
+func ParseRange(s string) (*Range, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	r := &Range{}
+	for i := 0; i < len(fields); i++ {
+		term := fields[i]
+		// The operator may be separated from its version (e.g. ">= 1.26").
+		if strings.Trim(term, "<>=") == "" && i+1 < len(fields) {
+			i++
+			term += fields[i]
+		}
+		version := strings.TrimLeft(term, "<>=")
+		op := strings.TrimSuffix(term, version)
+		v, err := parseMinorVersion(version)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "", "=", "==":
+			r.atLeast(v)
+			r.atMost(v)
+		case ">=":
+			r.atLeast(v)
+		case ">":
+			r.atLeast(minorVersion{major: v.major, minor: v.minor + 1})
+		case "<=":
+			r.atMost(v)
+		case "<":
+			if v.minor == 0 {
+				return nil, fmt.Errorf("unsupported comparison %q", term)
+			}
+			r.atMost(minorVersion{major: v.major, minor: v.minor - 1})
+		default:
+			return nil, fmt.Errorf("unknown comparison %q", term)
+		}
+	}
+	if r.min == nil && r.max == nil {
+		return nil, fmt.Errorf("no versions in %q", s)
+	}
+	if r.min != nil && r.max != nil && r.max.less(*r.min) {
+		return nil, fmt.Errorf("no version is %s", s)
+	}
+	return r, nil
+}
+
+func (r *Range) atLeast(v minorVersion) {
+	if r.min == nil || r.min.less(v) {
+		r.min = &v
+	}
+}
+
+func (r *Range) atMost(v minorVersion) {
+	if r.max == nil || v.less(*r.max) {
+		r.max = &v
+	}
+}
+
+// exact returns the only version in the range, if there is only one.
+func (r *Range) exact() (minorVersion, bool) {
+	if r.min != nil && r.max != nil && *r.min == *r.max {
+		return *r.min, true
+	}
+	return minorVersion{}, false
+}
+
+// clamp returns the version in the range nearest to the passed one.
+func (r *Range) clamp(v minorVersion) minorVersion {
+	if r.min != nil && v.less(*r.min) {
+		return *r.min
+	}
+	if r.max != nil && r.max.less(v) {
+		return *r.max
+	}
+	return v
+}
+
+// RecommendationSource returns the kubectl version recommended by the
+// cluster (see client.ServerVersionClient.Recommendation).
+type RecommendationSource interface {
+	Recommendation() (*client.Recommendation, error)
+}
+
+// RecommendedResolver answers with the kubectl version recommended by the
+// cluster, in the client.RecommendationConfigMap ConfigMap, instead of the
+// server version. If the cluster recommends a range of versions, the server
+// version is moved into the range. The recommendation is cached along with
+// the server version.
+type RecommendedResolver struct {
+	Source RecommendationSource
+	// Versions is the source of the server version, for ranges.
+	Versions discovery.ServerVersionInterface
+	Cache    *Cache
+	// Host is the URL of the cluster, keying the cache.
+	Host   string
+	MaxAge time.Duration
+	Now    func() time.Time
+}
+
+func (r *RecommendedResolver) Name() string {
+	return config.ResolverRecommended
+}
+
+func (r *RecommendedResolver) Resolve() (*version.Info, string, error) {
+	recommendation, err := r.recommendation()
+	if err != nil {
+		return nil, "", err
+	}
+	configMap := client.RecommendationNamespace + "/" + client.RecommendationConfigMap
+	if recommendation == nil {
+		return nil, "the cluster recommends no version in " + configMap, nil
+	}
+	if recommendation.MinDispatcherVersion != "" {
+		older, err := isOlderDispatcher(client.DispatcherVersion, recommendation.MinDispatcherVersion)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid %s in %s: %v", client.MinDispatcherVersionKey, configMap, err)
+		}
+		if older {
+			klog.Warningf("The cluster recommends a kubectl version to kubectl-dispatcher %s or later (this is %s); please upgrade the dispatcher", recommendation.MinDispatcherVersion, client.DispatcherVersion)
+			return nil, "the recommendation requires kubectl-dispatcher " + recommendation.MinDispatcherVersion + " or later", nil
+		}
+	}
+	versions, err := ParseRange(recommendation.Version)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s in %s: %v", client.RecommendedVersionKey, configMap, err)
+	}
+	if v, ok := versions.exact(); ok {
+		info, err := util.ParseVersion(v.String())
+		if err != nil {
+			return nil, "", err
+		}
+		return &info, "recommended by the cluster in " + configMap, nil
+	}
+	// The server version, cached or probed.
+	chain := Chain{&ProbeResolver{Source: r.Versions, Cache: r.Cache, Host: r.Host, Now: r.Now}}
+	if r.Cache != nil {
+		chain = append(Chain{&CacheResolver{Cache: r.Cache, Host: r.Host, MaxAge: r.MaxAge, Now: r.Now}}, chain...)
+	}
+	serverVersion, _, err := chain.Resolve()
+	if err != nil {
+		return nil, "", err
+	}
+	server, err := toMinorVersion(*serverVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	v := versions.clamp(server)
+	if v == server {
+		return serverVersion, fmt.Sprintf("the server version is within the range %q recommended by the cluster in %s", recommendation.Version, configMap), nil
+	}
+	info, err := util.ParseVersion(v.String())
+	if err != nil {
+		return nil, "", err
+	}
+	return &info, fmt.Sprintf("the server version %s moved into the range %q recommended by the cluster in %s", serverVersion.GitVersion, recommendation.Version, configMap), nil
+}
+
+// isOlderDispatcher returns true if the dispatcher version is older than
+// the minimum, comparing their dot separated numbers (e.g. "1.0" < "1.2.1").
+func isOlderDispatcher(current, min string) (bool, error) {
+	parse := func(v string) ([]int, error) {
+		var numbers []int
+		for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad dispatcher version %q", v)
+			}
+			numbers = append(numbers, n)
+		}
+		return numbers, nil
+	}
+	c, err := parse(current)
+	if err != nil {
+		return false, err
+	}
+	m, err := parse(min)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < len(c) || i < len(m); i++ {
+		var a, b int
+		if i < len(c) {
+			a = c[i]
+		}
+		if i < len(m) {
+			b = m[i]
+		}
+		if a != b {
+			return a < b, nil
+		}
+	}
+	return false, nil
+}
+
+// recommendation returns the cached recommendation of the cluster, unless
+// it is older than the maximum age, or else fetches and caches it.
+func (r *RecommendedResolver) recommendation() (*client.Recommendation, error) {
+	cached := r.Cache != nil && r.Host != ""
+	if cached && r.MaxAge > 0 {
+		recommendation, fetched, ok := r.Cache.GetRecommendation(r.Host)
+		if ok && now(r.Now).Sub(fetched) <= r.MaxAge {
+			return recommendation, nil
+		}
+	}
+	recommendation, err := r.Source.Recommendation()
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		if err := r.Cache.PutRecommendation(r.Host, recommendation, now(r.Now)); err != nil {
+			klog.V(3).Infof("Unable to cache the recommended version: %v", err)
+		}
+	}
+	return recommendation, nil
+}
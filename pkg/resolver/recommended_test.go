@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value       string
+		server      string
+		expected    string
+		expectedErr bool
+	}{
+		{value: "1.27", server: "1.29", expected: "1.27"},
+		{value: "v1.27.3", server: "1.29", expected: "1.27"},
+		{value: "=1.27", server: "1.26", expected: "1.27"},
+		{value: ">=1.26, <1.29", server: "1.27", expected: "1.27"},
+		{value: ">=1.26, <1.29", server: "1.30", expected: "1.28"},
+		{value: ">= 1.26 <= 1.28", server: "1.25", expected: "1.26"},
+		{value: ">1.26", server: "1.25", expected: "1.27"},
+		{value: "<=1.28", server: "1.30", expected: "1.28"},
+		{value: "", expectedErr: true},
+		{value: "latest", expectedErr: true},
+		{value: "~1.27", expectedErr: true},
+		{value: ">=1.29, <1.27", expectedErr: true},
+		{value: "<1.0", expectedErr: true},
+	}
+	for _, test := range tests {
+		r, err := ParseRange(test.value)
+		if test.expectedErr != (err != nil) {
+			t.Errorf("ParseRange(%q) error: expected error (%t), got (%v)", test.value, test.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		server, err := parseMinorVersion(test.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := r.clamp(server).String(); actual != test.expected {
+			t.Errorf("ParseRange(%q) with server %s: expected (%s), got (%s)", test.value, test.server, test.expected, actual)
+		}
+	}
+}
+
+func TestIsOlderDispatcher(t *testing.T) {
+	tests := []struct {
+		current, min string
+		expected     bool
+	}{
+		{current: "1.0", min: "1.0", expected: false},
+		{current: "1.0", min: "1.1", expected: true},
+		{current: "1.0", min: "1.0.1", expected: true},
+		{current: "1.10", min: "v1.9", expected: false},
+		{current: "2.0", min: "1.9", expected: false},
+	}
+	for _, test := range tests {
+		actual, err := isOlderDispatcher(test.current, test.min)
+		if err != nil || actual != test.expected {
+			t.Errorf("isOlderDispatcher(%s, %s): expected (%t), got (%t, %v)", test.current, test.min, test.expected, actual, err)
+		}
+	}
+}
+
+// fakeRecommendationSource returns a fixed recommendation, and counts the
+// queries.
+type fakeRecommendationSource struct {
+	recommendation *client.Recommendation
+	err            error
+	queries        int
+}
+
+func (s *fakeRecommendationSource) Recommendation() (*client.Recommendation, error) {
+	s.queries++
+	return s.recommendation, s.err
+}
+
+func TestRecommendedResolver(t *testing.T) {
+	v129 := &version.Info{Major: "1", Minor: "29", GitVersion: "v1.29.2"}
+	tests := []struct {
+		name            string
+		recommendation  *client.Recommendation
+		err             error
+		expectedVersion string
+		expectedErr     bool
+	}{
+		{name: "none"},
+		{name: "version", recommendation: &client.Recommendation{Version: "1.27"}, expectedVersion: "1.27"},
+		{name: "server in range", recommendation: &client.Recommendation{Version: ">=1.27"}, expectedVersion: "v1.29.2"},
+		{name: "server out of range", recommendation: &client.Recommendation{Version: ">=1.26, <1.29"}, expectedVersion: "1.28"},
+		{name: "dispatcher too old", recommendation: &client.Recommendation{Version: "1.27", MinDispatcherVersion: "99.0"}},
+		{name: "dispatcher new enough", recommendation: &client.Recommendation{Version: "1.27", MinDispatcherVersion: client.DispatcherVersion}, expectedVersion: "1.27"},
+		{name: "invalid dispatcher version", recommendation: &client.Recommendation{Version: "1.27", MinDispatcherVersion: "next"}, expectedErr: true},
+		{name: "invalid version", recommendation: &client.Recommendation{Version: "latest"}, expectedErr: true},
+		{name: "forbidden", err: errors.New("forbidden"), expectedErr: true},
+	}
+	for _, test := range tests {
+		source := &fakeRecommendationSource{recommendation: test.recommendation, err: test.err}
+		r := &RecommendedResolver{Source: source, Versions: &fakeVersionSource{version: v129}}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "RecommendedResolver", test.name, actual, explanation, err, test.expectedVersion, test.expectedErr)
+	}
+}
+
+func TestRecommendedResolverCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	host := "https://a.example.com"
+	fetched := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := fetched
+	source := &fakeRecommendationSource{recommendation: &client.Recommendation{Version: ">=1.12, <=1.13"}}
+	versions := &fakeVersionSource{version: &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.0"}}
+	r := &RecommendedResolver{
+		Source:   source,
+		Versions: versions,
+		Cache:    cache,
+		Host:     host,
+		MaxAge:   time.Hour,
+		Now:      func() time.Time { return now },
+	}
+	actual, explanation, err := r.Resolve()
+	checkResolved(t, "RecommendedResolver", "uncached", actual, explanation, err, "1.13", false)
+	// Both the recommendation and the server version are cached.
+	now = fetched.Add(time.Minute)
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "RecommendedResolver", "cached", actual, explanation, err, "1.13", false)
+	if source.queries != 1 || versions.queries != 1 {
+		t.Errorf("Cached recommendation: expected one query of each, got (%d, %d)", source.queries, versions.queries)
+	}
+	// An expired recommendation is fetched again.
+	now = fetched.Add(2 * time.Hour)
+	source.recommendation = nil
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "RecommendedResolver", "expired", actual, explanation, err, "", false)
+	if source.queries != 2 {
+		t.Errorf("Expired recommendation: expected two queries, got (%d)", source.queries)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resolver finds the server version the dispatcher dispatches to,
+// by consulting an ordered chain of version resolvers, each of which knows
+// one source of the version: an override, a pin, a cache, or the server.
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// VersionResolver is one source of the server version.
+type VersionResolver interface {
+	// Name identifies the resolver in reports and in the configuration
+	// (e.g. config.ResolverProbe).
+	Name() string
+	// Resolve returns the server version, or nil if the resolver has no
+	// answer. The explanation says where the version came from, or why
+	// the resolver has no answer. An error means the source failed.
+	Resolve() (*version.Info, string, error)
+}
+
+// Report is the outcome of consulting one resolver.
+type Report struct {
+	Resolver string
+	// Version is nil if the resolver did not answer.
+	Version     *version.Info
+	Explanation string
+	Err         error
+}
+
+func (r Report) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: error: %v", r.Resolver, r.Err)
+	case r.Version != nil:
+		return fmt.Sprintf("%s: %s (%s)", r.Resolver, r.Version.GitVersion, r.Explanation)
+	}
+	return fmt.Sprintf("%s: no answer (%s)", r.Resolver, r.Explanation)
+}
+
+// Chain consults resolvers in order, until one answers.
+type Chain []VersionResolver
+
+// Resolve returns the version of the first resolver to answer, with a
+// report for each resolver consulted. If none answers, the error is that
+// of the last resolver which failed, or else a NoAnswerError.
+func (c Chain) Resolve() (*version.Info, []Report, error) {
+	reports := make([]Report, 0, len(c))
+	var lastErr error
+	for _, r := range c {
+		info, explanation, err := r.Resolve()
+		if err != nil {
+			info = nil
+			lastErr = err
+		}
+		reports = append(reports, Report{Resolver: r.Name(), Version: info, Explanation: explanation, Err: err})
+		if info != nil {
+			return info, reports, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, reports, lastErr
+	}
+	return nil, reports, &NoAnswerError{Reports: reports}
+}
+
+// NoAnswerError is returned by a Chain when no resolver answered, and none
+// failed.
+type NoAnswerError struct {
+	Reports []Report
+}
+
+func (e *NoAnswerError) Error() string {
+	if len(e.Reports) == 0 {
+		return "no version resolvers"
+	}
+	reasons := make([]string, 0, len(e.Reports))
+	for _, r := range e.Reports {
+		reasons = append(reasons, r.String())
+	}
+	return "no version resolver answered: " + strings.Join(reasons, "; ")
+}
+
+// IsNoAnswer returns true if the error is a NoAnswerError.
+func IsNoAnswer(err error) bool {
+	_, ok := err.(*NoAnswerError)
+	return ok
+}
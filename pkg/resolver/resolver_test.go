@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// fakeResolver answers with its version, or fails with its error.
+type fakeResolver struct {
+	name     string
+	version  *version.Info
+	err      error
+	resolved *[]string
+}
+
+func (r *fakeResolver) Name() string {
+	return r.name
+}
+
+func (r *fakeResolver) Resolve() (*version.Info, string, error) {
+	if r.resolved != nil {
+		*r.resolved = append(*r.resolved, r.name)
+	}
+	if r.err != nil {
+		return nil, "", r.err
+	}
+	if r.version == nil {
+		return nil, "nothing to say", nil
+	}
+	return r.version, "fake", nil
+}
+
+func TestChainResolve(t *testing.T) {
+	v112 := &version.Info{Major: "1", Minor: "12", GitVersion: "v1.12.0"}
+	v113 := &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.0"}
+	errProbe := errors.New("probe failed")
+	errPin := errors.New("bad pin")
+	tests := []struct {
+		name             string
+		chain            []*fakeResolver
+		expectedVersion  *version.Info
+		expectedResolved []string
+		expectedErr      error
+		expectNoAnswer   bool
+	}{
+		{
+			name:             "first answer wins",
+			chain:            []*fakeResolver{{name: "a"}, {name: "b", version: v112}, {name: "c", version: v113}},
+			expectedVersion:  v112,
+			expectedResolved: []string{"a", "b"},
+		},
+		{
+			name:             "errors are skipped",
+			chain:            []*fakeResolver{{name: "a", err: errPin}, {name: "b", version: v113}},
+			expectedVersion:  v113,
+			expectedResolved: []string{"a", "b"},
+		},
+		{
+			name:             "last error is returned",
+			chain:            []*fakeResolver{{name: "a", err: errPin}, {name: "b", err: errProbe}, {name: "c"}},
+			expectedResolved: []string{"a", "b", "c"},
+			expectedErr:      errProbe,
+		},
+		{
+			name:             "no answer",
+			chain:            []*fakeResolver{{name: "a"}, {name: "b"}},
+			expectedResolved: []string{"a", "b"},
+			expectNoAnswer:   true,
+		},
+		{
+			name:           "empty chain",
+			expectNoAnswer: true,
+		},
+	}
+	for _, test := range tests {
+		resolved := []string{}
+		chain := Chain{}
+		for _, r := range test.chain {
+			r.resolved = &resolved
+			chain = append(chain, r)
+		}
+		actual, reports, err := chain.Resolve()
+		if actual != test.expectedVersion {
+			t.Errorf("Chain.Resolve (%s): expected version (%v), got (%v)", test.name, test.expectedVersion, actual)
+		}
+		if test.expectNoAnswer != IsNoAnswer(err) {
+			t.Errorf("Chain.Resolve (%s): expected no answer (%t), got (%v)", test.name, test.expectNoAnswer, err)
+		}
+		if test.expectedErr != nil && err != test.expectedErr {
+			t.Errorf("Chain.Resolve (%s): expected error (%v), got (%v)", test.name, test.expectedErr, err)
+		}
+		if test.expectedVersion != nil && err != nil {
+			t.Errorf("Chain.Resolve (%s): unexpected error (%v)", test.name, err)
+		}
+		if strings.Join(resolved, ",") != strings.Join(test.expectedResolved, ",") {
+			t.Errorf("Chain.Resolve (%s): expected resolvers (%v), got (%v)", test.name, test.expectedResolved, resolved)
+		}
+		if len(reports) != len(resolved) {
+			t.Fatalf("Chain.Resolve (%s): expected (%d) reports, got (%v)", test.name, len(resolved), reports)
+		}
+		for i, report := range reports {
+			if report.Resolver != resolved[i] {
+				t.Errorf("Chain.Resolve (%s): expected report of (%s), got (%s)", test.name, resolved[i], report.Resolver)
+			}
+		}
+	}
+}
+
+func TestReportString(t *testing.T) {
+	tests := []struct {
+		report   Report
+		expected string
+	}{
+		{
+			report:   Report{Resolver: "env", Explanation: "KUBECTL_DISPATCHER_SERVER_VERSION is not set"},
+			expected: "env: no answer (KUBECTL_DISPATCHER_SERVER_VERSION is not set)",
+		},
+		{
+			report:   Report{Resolver: "probe", Version: &version.Info{GitVersion: "v1.13.4"}, Explanation: "reported by the server"},
+			expected: "probe: v1.13.4 (reported by the server)",
+		},
+		{
+			report:   Report{Resolver: "probe", Err: errors.New("connection refused")},
+			expected: "probe: error: connection refused",
+		},
+	}
+	for _, test := range tests {
+		if actual := test.report.String(); actual != test.expected {
+			t.Errorf("Report.String: expected (%s), got (%s)", test.expected, actual)
+		}
+	}
+}
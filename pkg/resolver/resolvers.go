@@ -0,0 +1,275 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/client"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog"
+)
+
+// EnvResolver answers with the version in the config.ServerVersionEnv
+// environment variable.
+type EnvResolver struct {
+	// Env is the environment, as returned by os.Environ().
+	Env []string
+}
+
+func (r *EnvResolver) Name() string {
+	return config.ResolverEnv
+}
+
+func (r *EnvResolver) Resolve() (*version.Info, string, error) {
+	value, ok := config.LookupEnv(r.Env, config.ServerVersionEnv)
+	if !ok || value == "" {
+		return nil, config.ServerVersionEnv + " is not set", nil
+	}
+	info, err := util.ParseVersion(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s: %v", config.ServerVersionEnv, err)
+	}
+	return &info, "set by " + config.ServerVersionEnv, nil
+}
+
+// FileResolver answers with the version in the first line of a
+// config.VersionFile in a directory or one of its parents, so a project
+// can pin the kubectl version of its clusters.
+type FileResolver struct {
+	// Dir is the directory searched first. The default is the current
+	// working directory.
+	Dir string
+}
+
+func (r *FileResolver) Name() string {
+	return config.ResolverFile
+}
+
+func (r *FileResolver) Resolve() (*version.Info, string, error) {
+	start := r.Dir
+	if start == "" {
+		var err error
+		if start, err = os.Getwd(); err != nil {
+			return nil, "", err
+		}
+	}
+	start, err := filepath.Abs(start)
+	if err != nil {
+		return nil, "", err
+	}
+	for dir := start; ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, config.VersionFile)
+		contents, err := ioutil.ReadFile(path)
+		if err == nil {
+			line, _, _ := bufio.NewReader(bytes.NewReader(contents)).ReadLine()
+			info, err := util.ParseVersion(string(line))
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: %v", path, err)
+			}
+			return &info, "read from " + path, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", err
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return nil, fmt.Sprintf("no %s in %s or its parents", config.VersionFile, start), nil
+}
+
+// KubeconfigResolver answers with the version pinned by the kubeconfig
+// cluster (see client.PinExtension).
+type KubeconfigResolver struct {
+	Flags *genericclioptions.ConfigFlags
+}
+
+func (r *KubeconfigResolver) Name() string {
+	return config.ResolverKubeconfig
+}
+
+func (r *KubeconfigResolver) Resolve() (*version.Info, string, error) {
+	if r.Flags == nil {
+		return nil, "no kubeconfig", nil
+	}
+	pinned, err := client.PinnedVersion(r.Flags)
+	if err != nil {
+		return nil, "", err
+	}
+	if pinned == "" {
+		return nil, "the cluster does not pin a version", nil
+	}
+	info, err := util.ParseVersion(pinned)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s extension: %v", client.PinExtension, err)
+	}
+	return &info, "pinned by the cluster's " + client.PinExtension + " extension", nil
+}
+
+// ProbeResolver asks the server for its version, and records the answer
+// in the cache, if any, for the CacheResolver.
+type ProbeResolver struct {
+	Source discovery.ServerVersionInterface
+	// Samples is how many times the server is asked, to detect API servers
+	// of mixed versions during an upgrade of the control plane. The source
+	// must then ask a possibly different API server each time. The lowest
+	// version reported is the answer. Zero means once.
+	Samples int
+	Cache   *Cache
+	// Host is the URL of the cluster, keying the cache.
+	Host string
+	Now  func() time.Time
+}
+
+func (r *ProbeResolver) Name() string {
+	return config.ResolverProbe
+}
+
+func (r *ProbeResolver) Resolve() (*version.Info, string, error) {
+	var lowest *version.Info
+	var lowestMinor minorVersion
+	var versions []string
+	minors := map[minorVersion]bool{}
+	comparable := true
+	for i := 0; i < r.Samples || i == 0; i++ {
+		info, err := r.Source.ServerVersion()
+		if err != nil {
+			if lowest == nil {
+				return nil, "", err
+			}
+			// The versions already reported are enough.
+			klog.V(3).Infof("Unable to sample the server version again: %v", err)
+			break
+		}
+		v, err := toMinorVersion(*info)
+		if err != nil {
+			// Not comparable: the dispatch reports the bad version.
+			lowest, minors, comparable = info, nil, false
+			break
+		}
+		if lowest == nil || v.less(lowestMinor) {
+			lowest, lowestMinor = info, v
+		}
+		if !minors[v] {
+			minors[v] = true
+			versions = append(versions, info.GitVersion)
+		}
+	}
+	if len(minors) < 2 {
+		versions = nil
+	}
+	// Only versions that parse are cached: a bad one is probed again.
+	if r.Cache != nil && r.Host != "" && comparable {
+		if err := r.Cache.PutMixed(r.Host, *lowest, versions, now(r.Now)); err != nil {
+			klog.V(3).Infof("Unable to cache the server version: %v", err)
+		}
+	}
+	if versions != nil {
+		klog.V(1).Infof("The API servers of %s report mixed versions %s, likely during an upgrade; using the lowest, %s", r.Host, strings.Join(versions, ", "), lowest.GitVersion)
+		return lowest, "the lowest of the mixed versions reported by the API servers: " + strings.Join(versions, ", "), nil
+	}
+	return lowest, "reported by the server", nil
+}
+
+// VersionInferrer infers the server version from other sources than the
+// server's version (see client.ServerVersionClient.InferServerVersion).
+type VersionInferrer interface {
+	// InferServerVersion returns the inferred version, or nil if there is
+	// no answer, with an explanation.
+	InferServerVersion() (*version.Info, string, error)
+}
+
+// InferResolver answers with the version inferred from other documents of
+// the server, for servers refusing or hiding their version. The inferred
+// version is not cached, so the server is probed again next time.
+type InferResolver struct {
+	Source VersionInferrer
+}
+
+func (r *InferResolver) Name() string {
+	return config.ResolverInfer
+}
+
+func (r *InferResolver) Resolve() (*version.Info, string, error) {
+	return r.Source.InferServerVersion()
+}
+
+// InClusterResolver asks the server for its version with the service
+// account of the pod the dispatcher runs in, if any.
+type InClusterResolver struct {
+	// Env is the environment, as returned by os.Environ().
+	Env []string
+	// ServiceAccountDir holds the credentials of the service account. The
+	// default is client.ServiceAccountDir.
+	ServiceAccountDir string
+	// ClusterSelected is true if a kubeconfig context, --cluster or --server
+	// selects a cluster, which may not be the pod's: as for kubectl, the
+	// service account is then not used.
+	ClusterSelected bool
+	// Source is the server version source. The default uses
+	// client.InClusterConfig().
+	Source discovery.ServerVersionInterface
+}
+
+func (r *InClusterResolver) Name() string {
+	return config.ResolverInCluster
+}
+
+func (r *InClusterResolver) Resolve() (*version.Info, string, error) {
+	if host, ok := config.LookupEnv(r.Env, client.ServiceHostEnv); !ok || host == "" {
+		return nil, "not running in a pod", nil
+	}
+	if r.ClusterSelected {
+		return nil, "the kubeconfig or flags select another cluster", nil
+	}
+	source := r.Source
+	if source == nil {
+		dir := r.ServiceAccountDir
+		if dir == "" {
+			dir = client.ServiceAccountDir
+		}
+		inClusterConfig, err := client.InClusterConfig(r.Env, dir)
+		if err != nil {
+			return nil, "", err
+		}
+		source = client.NewServerVersionClientForConfig(inClusterConfig)
+	}
+	info, err := source.ServerVersion()
+	if err != nil {
+		return nil, "", err
+	}
+	return info, "reported by the server to the pod's service account", nil
+}
+
+func now(clock func() time.Time) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock()
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/config"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// fakeVersionSource implements discovery.ServerVersionInterface.
+type fakeVersionSource struct {
+	version *version.Info
+	err     error
+	queries int
+}
+
+func (s *fakeVersionSource) ServerVersion() (*version.Info, error) {
+	s.queries++
+	return s.version, s.err
+}
+
+func TestEnvResolver(t *testing.T) {
+	tests := []struct {
+		env             []string
+		expectedVersion string
+		expectError     bool
+	}{
+		{env: []string{}},
+		{env: []string{config.ServerVersionEnv + "="}},
+		{env: []string{config.ServerVersionEnv + "=1.13"}, expectedVersion: "1.13"},
+		{env: []string{config.ServerVersionEnv + "=v1.12.3"}, expectedVersion: "v1.12.3"},
+		{env: []string{config.ServerVersionEnv + "=latest"}, expectError: true},
+	}
+	for _, test := range tests {
+		r := &EnvResolver{Env: test.env}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "EnvResolver", test.env, actual, explanation, err, test.expectedVersion, test.expectError)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	project := filepath.Join(tmp, "project")
+	nested := filepath.Join(project, "deploy", "prod")
+	bad := filepath.Join(tmp, "bad")
+	for _, dir := range []string{nested, bad} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(project, config.VersionFile), []byte("1.13\n# pinned for the prod cluster\n"), 0644)
+	ioutil.WriteFile(filepath.Join(bad, config.VersionFile), []byte("stable\n"), 0644)
+	tests := []struct {
+		dir             string
+		expectedVersion string
+		expectError     bool
+	}{
+		{dir: project, expectedVersion: "1.13"},
+		// The parent directories are searched.
+		{dir: nested, expectedVersion: "1.13"},
+		{dir: tmp},
+		{dir: bad, expectError: true},
+	}
+	for _, test := range tests {
+		r := &FileResolver{Dir: test.dir}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "FileResolver", test.dir, actual, explanation, err, test.expectedVersion, test.expectError)
+	}
+}
+
+func TestKubeconfigResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: pinned
+  cluster:
+    server: https://pinned.example.com
+    extensions:
+    - name: kubectl-dispatcher
+      extension:
+        version: "1.12"
+- name: unpinned
+  cluster:
+    server: https://unpinned.example.com
+- name: bad
+  cluster:
+    server: https://bad.example.com
+    extensions:
+    - name: kubectl-dispatcher
+      extension:
+        version: "twelve"
+contexts:
+- name: pinned
+  context:
+    cluster: pinned
+- name: unpinned
+  context:
+    cluster: unpinned
+- name: bad
+  context:
+    cluster: bad
+current-context: pinned
+`
+	path := filepath.Join(tmp, "config")
+	if err := ioutil.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		context         string
+		expectedVersion string
+		expectError     bool
+	}{
+		{context: "", expectedVersion: "1.12"},
+		{context: "unpinned"},
+		{context: "bad", expectError: true},
+	}
+	for _, test := range tests {
+		flags := genericclioptions.NewConfigFlags(false)
+		*flags.KubeConfig = path
+		*flags.Context = test.context
+		r := &KubeconfigResolver{Flags: flags}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "KubeconfigResolver", test.context, actual, explanation, err, test.expectedVersion, test.expectError)
+	}
+	r := &KubeconfigResolver{}
+	actual, explanation, err := r.Resolve()
+	checkResolved(t, "KubeconfigResolver", "no flags", actual, explanation, err, "", false)
+}
+
+func TestProbeResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache := NewCache(tmp)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	source := &fakeVersionSource{err: errors.New("connection refused")}
+	r := &ProbeResolver{Source: source, Cache: cache, Host: "https://a.example.com", Now: clock}
+	actual, explanation, err := r.Resolve()
+	checkResolved(t, "ProbeResolver", "unreachable", actual, explanation, err, "", true)
+
+	source = &fakeVersionSource{version: &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}}
+	r.Source = source
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "ProbeResolver", "reachable", actual, explanation, err, "v1.13.4", false)
+	// The probed version is cached.
+	cached, probed := cache.Get("https://a.example.com")
+	if cached == nil || cached.GitVersion != "v1.13.4" || !probed.Equal(now) {
+		t.Errorf("ProbeResolver cache: expected (v1.13.4, %s), got (%v, %s)", now, cached, probed)
+	}
+
+	// A version that does not parse is returned, but not cached.
+	r.Source = &fakeVersionSource{version: &version.Info{GitVersion: "unknown"}}
+	r.Host = "https://b.example.com"
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "ProbeResolver", "unparsable", actual, explanation, err, "unknown", false)
+	if cached, _ := cache.Get("https://b.example.com"); cached != nil {
+		t.Errorf("ProbeResolver cache (unparsable): expected (nil), got (%v)", cached)
+	}
+}
+
+// fakeSamplingSource reports each version in turn, as API servers of
+// mixed versions behind a load balancer, then fails.
+type fakeSamplingSource struct {
+	versions []*version.Info
+	queries  int
+}
+
+func (s *fakeSamplingSource) ServerVersion() (*version.Info, error) {
+	s.queries++
+	if s.queries > len(s.versions) {
+		return nil, errors.New("connection refused")
+	}
+	return s.versions[s.queries-1], nil
+}
+
+func TestProbeResolverSamples(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	v113 := &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}
+	v114 := &version.Info{Major: "1", Minor: "14", GitVersion: "v1.14.0"}
+	v1131 := &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.1"}
+	tests := []struct {
+		name            string
+		versions        []*version.Info
+		samples         int
+		expectedVersion string
+		expectedQueries int
+		expectedMixed   []string
+	}{
+		{name: "once", versions: []*version.Info{v114, v113}, samples: 0, expectedVersion: "v1.14.0", expectedQueries: 1},
+		{name: "agreeing", versions: []*version.Info{v113, v1131, v113}, samples: 3, expectedVersion: "v1.13.4", expectedQueries: 3},
+		{name: "mixed", versions: []*version.Info{v114, v113, v114}, samples: 3, expectedVersion: "v1.13.4", expectedQueries: 3, expectedMixed: []string{"v1.14.0", "v1.13.4"}},
+		{name: "failed sample", versions: []*version.Info{v114}, samples: 3, expectedVersion: "v1.14.0", expectedQueries: 2},
+	}
+	for _, test := range tests {
+		cache := NewCache(tmp)
+		source := &fakeSamplingSource{versions: test.versions}
+		r := &ProbeResolver{Source: source, Samples: test.samples, Cache: cache, Host: "https://a.example.com"}
+		actual, explanation, err := r.Resolve()
+		checkResolved(t, "ProbeResolver", test.name, actual, explanation, err, test.expectedVersion, false)
+		if source.queries != test.expectedQueries {
+			t.Errorf("ProbeResolver (%s) queries: expected (%d), got (%d)", test.name, test.expectedQueries, source.queries)
+		}
+		if mixed := cache.Mixed("https://a.example.com"); !isStringSliceEqual(test.expectedMixed, mixed) {
+			t.Errorf("ProbeResolver (%s) mixed versions: expected (%v), got (%v)", test.name, test.expectedMixed, mixed)
+		}
+	}
+}
+
+// fakeInferrer infers a fixed server version, or fails.
+type fakeInferrer struct {
+	version *version.Info
+	err     error
+}
+
+func (i *fakeInferrer) InferServerVersion() (*version.Info, string, error) {
+	if i.err != nil {
+		return nil, "", i.err
+	}
+	return i.version, "inferred from discovery", nil
+}
+
+func TestInferResolver(t *testing.T) {
+	r := &InferResolver{Source: &fakeInferrer{version: &version.Info{Major: "1", Minor: "27", GitVersion: "v1.27.0"}}}
+	actual, explanation, err := r.Resolve()
+	checkResolved(t, "InferResolver", "inferred", actual, explanation, err, "v1.27.0", false)
+	r = &InferResolver{Source: &fakeInferrer{err: errors.New("no known API group versions")}}
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "InferResolver", "failed", actual, explanation, err, "", true)
+}
+
+func TestInClusterResolver(t *testing.T) {
+	source := &fakeVersionSource{version: &version.Info{Major: "1", Minor: "13", GitVersion: "v1.13.4"}}
+	r := &InClusterResolver{Env: []string{}, Source: source}
+	actual, explanation, err := r.Resolve()
+	checkResolved(t, "InClusterResolver", "outside a pod", actual, explanation, err, "", false)
+	if source.queries != 0 {
+		t.Errorf("InClusterResolver outside a pod: expected no queries, got (%d)", source.queries)
+	}
+	r.Env = []string{"KUBERNETES_SERVICE_HOST=10.0.0.1"}
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "InClusterResolver", "in a pod", actual, explanation, err, "v1.13.4", false)
+	// The kubeconfig selects another cluster, even if its probe failed.
+	r.ClusterSelected = true
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "InClusterResolver", "cluster selected", actual, explanation, err, "", false)
+	if source.queries != 1 {
+		t.Errorf("InClusterResolver cluster selected: expected one query, got (%d)", source.queries)
+	}
+	// Without a source, the service account credentials are required.
+	r = &InClusterResolver{Env: []string{"KUBERNETES_SERVICE_HOST=10.0.0.1", "KUBERNETES_SERVICE_PORT=443"}, ServiceAccountDir: "/nonexistent"}
+	actual, explanation, err = r.Resolve()
+	checkResolved(t, "InClusterResolver", "no service account", actual, explanation, err, "", true)
+}
+
+// checkResolved checks the answer of a resolver: an error, a version, or
+// no answer, which are all explained.
+func checkResolved(t *testing.T, name string, input interface{}, actual *version.Info, explanation string, err error, expectedVersion string, expectError bool) {
+	t.Helper()
+	if expectError != (err != nil) {
+		t.Errorf("%s (%v) error: expected error (%t), got (%v)", name, input, expectError, err)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if explanation == "" {
+		t.Errorf("%s (%v): expected an explanation", name, input)
+	}
+	actualVersion := ""
+	if actual != nil {
+		actualVersion = actual.GitVersion
+	}
+	if actualVersion != expectedVersion {
+		t.Errorf("%s (%v) version: expected (%s), got (%s)", name, input, expectedVersion, actualVersion)
+	}
+}
+
+func isStringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
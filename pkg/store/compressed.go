@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+)
+
+// CompressedSuffixes are the file name suffixes of compressed versioned
+// kubectl binaries (e.g. "kubectl.1.12.xz"), in order of preference.
+var CompressedSuffixes = []string{".zst", ".xz", ".gz"}
+
+// External decompressors, since only gzip is in the standard library.
+var decompressCommands = map[string][]string{
+	".zst": {"zstd", "-d", "-c", "-q"},
+	".xz":  {"xz", "-d", "-c", "-q"},
+}
+
+// FindCompressed returns the path of a compressed variant of the binary
+// at "path", or the empty string if there is none.
+func FindCompressed(path string) string {
+	for _, suffix := range CompressedSuffixes {
+		if fi, err := os.Stat(path + suffix); err == nil && fi.Mode().IsRegular() {
+			return path + suffix
+		}
+	}
+	return ""
+}
+
+// Decompress writes the decompressed contents of the compressed file to
+// "w". The compression format is given by the file name suffix.
+func Decompress(path string, w io.Writer) error {
+	suffix := filepath.Ext(path)
+	if suffix == ".gz" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gzr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("decompressing %s: %v", path, err)
+		}
+		defer gzr.Close()
+		_, err = io.Copy(w, gzr)
+		return err
+	}
+	args, ok := decompressCommands[suffix]
+	if !ok {
+		return fmt.Errorf("unsupported compression format %q", suffix)
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("decompressing %s requires %s, which is not installed: %v", path, args[0], err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("decompressing %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Decompressed returns the path of the decompressed copy of the compressed
+// binary for the version. The copy is created in the store on first use,
+// under a key for the compressed binary (see DecompressedPath), and reused
+// as long as it is newer than the compressed binary and still has the
+// digest it was stored with; otherwise it is decompressed again. If a
+// digest file ("<compressed>.sha256") exists next to the compressed binary,
+// the decompressed contents must match it.
+func (s *Store) Decompressed(v version.Info, compressed string) (string, error) {
+	compressedInfo, err := os.Stat(compressed)
+	if err != nil {
+		return "", err
+	}
+	expectedDigest, err := ReadDigestFile(compressed + DigestSuffix)
+	if err != nil {
+		return "", err
+	}
+	key := decompressedKey(compressed)
+	if path, err := s.LookupKeyed(v, key); err == nil {
+		fi, statErr := os.Stat(path)
+		digest, _ := ReadDigestFile(path + DigestSuffix)
+		if statErr == nil && !fi.ModTime().Before(compressedInfo.ModTime()) && (expectedDigest == "" || strings.EqualFold(expectedDigest, digest)) {
+			return path, nil
+		}
+	} else if !os.IsNotExist(err) {
+		klog.V(3).Infof("Decompressing %s again: %v", compressed, err)
+	}
+	path, digest, err := s.PutKeyed(v, key, func(w io.Writer) error {
+		return Decompress(compressed, w)
+	}, expectedDigest)
+	if err != nil {
+		return "", err
+	}
+	klog.V(3).Infof("Decompressed %s (sha256:%s) into store: %s", compressed, digest, path)
+	return path, nil
+}
+
+// DecompressedPath returns the path of the decompressed copy of the
+// compressed binary for the version, whether or not it exists.
+func (s *Store) DecompressedPath(v version.Info, compressed string) (string, error) {
+	return s.KeyedPath(v, decompressedKey(compressed))
+}
+
+// decompressedKey returns the store key of the decompressed copies of the
+// compressed binary.
+func decompressedKey(compressed string) string {
+	if abs, err := filepath.Abs(compressed); err == nil {
+		compressed = abs
+	}
+	return Key("compressed:" + compressed)
+}
+
+// ReadDigestFile returns the digest in a "sha256sum" style digest file, or
+// the empty string if the file does not exist.
+func ReadDigestFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty digest file %s", path)
+	}
+	return fields[0], nil
+}
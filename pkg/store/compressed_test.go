@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCompressed writes "contents" compressed with the format given by
+// the suffix into the directory, and returns the compressed file path.
+func writeCompressed(t *testing.T, dir string, suffix string) string {
+	path := filepath.Join(dir, "kubectl.1.12")
+	if suffix == ".gz" {
+		var buf bytes.Buffer
+		gzw := gzip.NewWriter(&buf)
+		gzw.Write([]byte(contents))
+		gzw.Close()
+		if err := ioutil.WriteFile(path+suffix, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path + suffix
+	}
+	args := map[string][]string{
+		".xz":  {"xz", "-z", "-q"},
+		".zst": {"zstd", "-q", "--rm"},
+	}[suffix]
+	if _, err := exec.LookPath(args[0]); err != nil {
+		t.Skipf("%s not installed", args[0])
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(args[0], append(args[1:], path)...).CombinedOutput(); err != nil {
+		t.Fatalf("compressing with %s: %v: %s", args[0], err, out)
+	}
+	return path + suffix
+}
+
+func TestDecompressed(t *testing.T) {
+	for _, suffix := range CompressedSuffixes {
+		t.Run(suffix, func(t *testing.T) {
+			s, cleanup := tempStore(t)
+			defer cleanup()
+			dir := filepath.Dir(s.Dir())
+			compressed := writeCompressed(t, dir, suffix)
+			if found := FindCompressed(filepath.Join(dir, "kubectl.1.12")); found != compressed {
+				t.Errorf("FindCompressed error: expected (%s), got (%s)", compressed, found)
+			}
+			ioutil.WriteFile(compressed+DigestSuffix, []byte(contentsDigest+"  kubectl.1.12\n"), 0644)
+
+			path, err := s.Decompressed(version112, compressed)
+			if err != nil {
+				t.Fatalf("Unexpected error in Decompressed: %v", err)
+			}
+			actual, _ := ioutil.ReadFile(path)
+			if string(actual) != contents {
+				t.Errorf("Decompressed contents error: expected (%s), got (%s)", contents, actual)
+			}
+			if expected, _ := s.DecompressedPath(version112, compressed); expected != path {
+				t.Errorf("Decompressed path error: expected (%s), got (%s)", expected, path)
+			}
+			if err := VerifyFile(path); err != nil {
+				t.Errorf("Unexpected error verifying decompressed binary: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecompressedReusesCopy(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	compressed := writeCompressed(t, filepath.Dir(s.Dir()), ".gz")
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(compressed, past, past)
+
+	path, err := s.Decompressed(version112, compressed)
+	if err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	// The intact copy is reused the second time.
+	before, _ := os.Stat(path)
+	if _, err := s.Decompressed(version112, compressed); err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	if after, _ := os.Stat(path); !os.SameFile(before, after) {
+		t.Errorf("Expected decompressed copy to be reused")
+	}
+	// A tampered copy is rejected, and decompressed again.
+	ioutil.WriteFile(path, []byte("tampered"), 0755)
+	if _, err := s.Decompressed(version112, compressed); err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	if actual, _ := ioutil.ReadFile(path); string(actual) != contents {
+		t.Errorf("Expected tampered copy to be replaced, got (%s)", actual)
+	}
+	// A compressed binary newer than the copy is decompressed again.
+	ioutil.WriteFile(path, []byte("stale"), 0755)
+	ioutil.WriteFile(path+DigestSuffix, []byte(fmt.Sprintf("%x\n", sha256.Sum256([]byte("stale")))), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(compressed, future, future)
+	if _, err := s.Decompressed(version112, compressed); err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	if actual, _ := ioutil.ReadFile(path); string(actual) != contents {
+		t.Errorf("Expected stale copy to be replaced, got (%s)", actual)
+	}
+}
+
+func TestDecompressedDigestMismatch(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	compressed := writeCompressed(t, filepath.Dir(s.Dir()), ".gz")
+	ioutil.WriteFile(compressed+DigestSuffix, []byte("0123456789abcdef\n"), 0644)
+
+	if _, err := s.Decompressed(version112, compressed); err == nil {
+		t.Errorf("Expected digest mismatch error; received none")
+	}
+	if path, _ := s.DecompressedPath(version112, compressed); isFile(path) {
+		t.Errorf("Expected nothing stored after digest mismatch")
+	}
+}
+
+// Compressed binaries of the same version from different directories are
+// decompressed apart.
+func TestDecompressedKeyedBySource(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	dir1, dir2 := filepath.Join(filepath.Dir(s.Dir()), "1"), filepath.Join(filepath.Dir(s.Dir()), "2")
+	os.Mkdir(dir1, 0755)
+	os.Mkdir(dir2, 0755)
+	path1, err := s.Decompressed(version112, writeCompressed(t, dir1, ".gz"))
+	if err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	path2, err := s.Decompressed(version112, writeCompressed(t, dir2, ".gz"))
+	if err != nil {
+		t.Fatalf("Unexpected error in Decompressed: %v", err)
+	}
+	if path1 == path2 {
+		t.Errorf("Expected distinct decompressed copies, got (%s) twice", path1)
+	}
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"kubectl.1.12.gz", "kubectl.1.12.bz2"} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte("not compressed"), 0644)
+		if err := Decompress(path, ioutil.Discard); err == nil {
+			t.Errorf("Expected error decompressing (%s); received none", name)
+		}
+	}
+}
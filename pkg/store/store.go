@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dfilepath "github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/filepath"
+	"github.com/GoogleCloudPlatform/kubectl-dispatcher/pkg/util"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// DigestSuffix is appended to the binary file name to get the name of the
+// file recording the SHA-256 digest the binary was stored with.
+const DigestSuffix = ".sha256"
+
+// Store is a directory of versioned kubectl binaries which the dispatcher
+// manages itself (e.g. decompressed, or extracted from OCI images). Each
+// binary is stored under a key (see Key), in a subdirectory named by the
+// key, with the same kubectl.<major>.<minor> name as in the dispatcher
+// directory.
+type Store struct {
+	dir     string
+	builder *dfilepath.FilepathBuilder
+}
+
+// NewStore returns the store rooted at the passed directory. The directory
+// is created on the first PutKeyed.
+func NewStore(dir string) *Store {
+	return &Store{
+		dir:     dir,
+		builder: dfilepath.NewFilepathBuilder(&dfilepath.FixedDirGetter{Dir: dir}, os.Stat),
+	}
+}
+
+// Dir returns the root directory of the store.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Key returns the key under which binaries from the source (e.g. the path
+// of a compressed binary, or an OCI image digest) are stored, so builds of
+// the same version from different sources do not replace each other.
+func Key(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// KeyedPath returns the path of the binary for the version stored under
+// the key, whether or not it has been stored.
+func (s *Store) KeyedPath(v version.Info, key string) (string, error) {
+	path, err := s.builder.VersionedFilePath(v)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, key, filepath.Base(path)), nil
+}
+
+// Keys returns the keys binaries are stored under, in order.
+func (s *Store) Keys() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, fi := range files {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			keys = append(keys, fi.Name())
+		}
+	}
+	return keys, nil
+}
+
+// LookupKeyed returns the path of the binary for the version stored under
+// the key, after checking it still has the digest it was stored with.
+func (s *Store) LookupKeyed(v version.Info, key string) (string, error) {
+	path, err := s.KeyedPath(v, key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.builder.ValidateFilepath(path); err != nil {
+		return "", err
+	}
+	if err := VerifyFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// PutKeyed stores the binary written by the "write" function under the
+// key (see Key), and returns its path and digest. If "expectedDigest" is
+// not empty, the contents must match it; otherwise nothing is stored. If
+// the function returns an error, nothing is stored either. The binary is
+// written to a temporary file and renamed into place, so concurrent
+// dispatchers never execute a partially written binary.
+func (s *Store) PutKeyed(v version.Info, key string, write func(io.Writer) error, expectedDigest string) (string, string, error) {
+	path, err := s.KeyedPath(v, key)
+	if err != nil {
+		return "", "", err
+	}
+	return s.put(path, write, expectedDigest)
+}
+
+func (s *Store) put(path string, write func(io.Writer) error, expectedDigest string) (string, string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(f.Name())
+	hash := sha256.New()
+	err = write(io.MultiWriter(f, hash))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if expectedDigest != "" && !strings.EqualFold(expectedDigest, digest) {
+		return "", "", fmt.Errorf("digest mismatch storing %s: expected (%s), got (%s)", filepath.Base(path), expectedDigest, digest)
+	}
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(path+DigestSuffix, []byte(digest+"\n"), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", "", err
+	}
+	return path, digest, nil
+}
+
+// VerifyFile checks that the stored binary at the path still has the digest
+// recorded next to it.
+func VerifyFile(path string) error {
+	recorded, err := ioutil.ReadFile(path + DigestSuffix)
+	if err != nil {
+		return err
+	}
+	_, digest, err := util.FileDigest(path)
+	if err != nil {
+		return err
+	}
+	if expected := strings.TrimSpace(string(recorded)); expected != digest {
+		return fmt.Errorf("stored binary %s was modified: expected digest (%s), got (%s)", path, expected, digest)
+	}
+	return nil
+}
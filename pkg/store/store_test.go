@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+var version112 = version.Info{Major: "1", Minor: "12"}
+
+const (
+	contents       = "fake kubectl"
+	contentsDigest = "fe080e72f3cf1603385df29f397c387b138690be10fb650b3a24b7b363fb7e71"
+)
+
+func tempStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use a subdirectory to check the store creates its directory.
+	return NewStore(filepath.Join(dir, "store")), func() { os.RemoveAll(dir) }
+}
+
+// write returns a function writing the contents, for PutKeyed.
+func write(contents string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, contents)
+		return err
+	}
+}
+
+func TestStorePutKeyedLookup(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+
+	key := Key("source")
+	if _, err := s.LookupKeyed(version112, key); err == nil {
+		t.Errorf("Expected error looking up empty store; received none")
+	}
+	path, digest, err := s.PutKeyed(version112, key, write(contents), "")
+	if err != nil {
+		t.Fatalf("Unexpected error in PutKeyed: %v", err)
+	}
+	if digest != contentsDigest {
+		t.Errorf("PutKeyed digest error: expected (%s), got (%s)", contentsDigest, digest)
+	}
+	if expected, _ := s.KeyedPath(version112, key); expected != path {
+		t.Errorf("PutKeyed path error: expected (%s), got (%s)", expected, path)
+	}
+	actual, err := s.LookupKeyed(version112, key)
+	if err != nil {
+		t.Fatalf("Unexpected error in LookupKeyed: %v", err)
+	}
+	if actual != path {
+		t.Errorf("LookupKeyed error: expected (%s), got (%s)", path, actual)
+	}
+	if fi, _ := os.Stat(path); fi.Mode()&0111 == 0 {
+		t.Errorf("Stored binary (%s) is not executable", path)
+	}
+	if keys, err := s.Keys(); err != nil || len(keys) != 1 || keys[0] != key {
+		t.Errorf("Keys error: expected ([%s]), got (%v, %v)", key, keys, err)
+	}
+	// Another key does not hold the binary.
+	if _, err := s.LookupKeyed(version112, Key("other")); err == nil {
+		t.Errorf("Expected error looking up another key; received none")
+	}
+	// A second PutKeyed with the recorded digest succeeds.
+	if _, _, err := s.PutKeyed(version112, key, write(contents), digest); err != nil {
+		t.Errorf("Unexpected error in PutKeyed with expected digest: %v", err)
+	}
+	// Modifying the binary fails verification.
+	if err := ioutil.WriteFile(path, []byte("tampered"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(path); err == nil {
+		t.Errorf("Expected error verifying modified binary; received none")
+	}
+	if _, err := s.LookupKeyed(version112, key); err == nil {
+		t.Errorf("Expected error looking up modified binary; received none")
+	}
+}
+
+func TestStorePutKeyedDigestMismatch(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+
+	key := Key("source")
+	if _, _, err := s.PutKeyed(version112, key, write(contents), "0123"); err == nil {
+		t.Errorf("Expected error for digest mismatch; received none")
+	}
+	if _, err := s.LookupKeyed(version112, key); err == nil {
+		t.Errorf("Expected nothing stored after digest mismatch")
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(s.Dir(), key)); len(entries) != 0 {
+		t.Errorf("Expected empty store after digest mismatch, got (%d) files", len(entries))
+	}
+}
+
+func TestStorePutKeyedWriteError(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+
+	key := Key("source")
+	_, _, err := s.PutKeyed(version112, key, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return fmt.Errorf("forced write error")
+	}, "")
+	if err == nil {
+		t.Errorf("Expected error from PutKeyed; received none")
+	}
+	if _, err := s.LookupKeyed(version112, key); err == nil {
+		t.Errorf("Expected nothing stored after write error")
+	}
+}
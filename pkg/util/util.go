@@ -17,7 +17,12 @@ limitations under the License.
 package util
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 	"unicode"
@@ -52,6 +57,22 @@ func CopyStrSlice(s []string) []string {
 	return c
 }
 
+// FileDigest returns the size and the hex encoded SHA-256 digest of the
+// contents of the file.
+func FileDigest(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, bufio.NewReader(f))
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // versionMatch returns true if the Major and Minor versions match
 // for the passed version infos v1 and v2. Examples:
 //   1.11.7 == 1.11.9
@@ -80,6 +101,41 @@ func VersionMatch(v1 version.Info, v2 version.Info) bool {
 	return false
 }
 
+// ParseVersion returns the version info for a "<major>.<minor>" version
+// string, allowing an optional "v" prefix and a trailing patch or build
+// suffix. Examples:
+//   1.12        -> major: 1, minor: 12
+//   v1.12.3-gke -> major: 1, minor: 12
+func ParseVersion(s string) (version.Info, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	parts := strings.SplitN(trimmed, ".", 3)
+	if len(parts) < 2 {
+		return version.Info{}, fmt.Errorf("Bad version string (%s)", s)
+	}
+	info := version.Info{Major: parts[0], Minor: parts[1], GitVersion: strings.TrimSpace(s)}
+	if _, err := GetMajorVersion(info); err != nil {
+		return version.Info{}, err
+	}
+	if _, err := GetMinorVersion(info); err != nil {
+		return version.Info{}, err
+	}
+	return info, nil
+}
+
+// MajorMinor returns the normalized "<major>.<minor>" string for the
+// version info, or an error if either component is invalid.
+func MajorMinor(v version.Info) (string, error) {
+	major, err := GetMajorVersion(v)
+	if err != nil {
+		return "", err
+	}
+	minor, err := GetMinorVersion(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d", major, minor), nil
+}
+
 func GetMajorVersion(serverVersion version.Info) (int, error) {
 	majorStr, err := normalizeVersionStr(serverVersion.Major)
 	if err != nil {
@@ -17,6 +17,8 @@ limitations under the License.
 package util
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/version"
@@ -180,3 +182,61 @@ func TestVersionMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version     string
+		majorMinor  string
+		expectError bool
+	}{
+		{version: "1.12", majorMinor: "1.12"},
+		{version: "v1.12", majorMinor: "1.12"},
+		{version: "v1.12.3", majorMinor: "1.12"},
+		{version: " v1.9.11-gke.1\n", majorMinor: "1.9"},
+		{version: "1", expectError: true},
+		{version: "", expectError: true},
+		{version: "vfoo.bar", expectError: true},
+		{version: "1.0", expectError: true},
+	}
+	for _, test := range tests {
+		info, err := ParseVersion(test.version)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Expected error parsing version (%q); received none", test.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing version (%q): %v", test.version, err)
+			continue
+		}
+		actual, err := MajorMinor(info)
+		if err != nil {
+			t.Errorf("Unexpected error in MajorMinor for (%q): %v", test.version, err)
+		}
+		if test.majorMinor != actual {
+			t.Errorf("ParseVersion error: expected (%s), got (%s)", test.majorMinor, actual)
+		}
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	f, err := ioutil.TempFile("", "digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("hello\n")
+	f.Close()
+	size, digest, err := FileDigest(f.Name())
+	if err != nil {
+		t.Fatalf("Unexpected error in FileDigest: %v", err)
+	}
+	const expected = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if size != 6 || digest != expected {
+		t.Errorf("FileDigest error: expected (6, %s), got (%d, %s)", expected, size, digest)
+	}
+	if _, _, err := FileDigest(f.Name() + ".missing"); err == nil {
+		t.Errorf("Expected error for missing file; received none")
+	}
+}